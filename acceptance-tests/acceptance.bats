@@ -42,3 +42,8 @@
   run tests/1mkeys.sh
   [ "$status" -eq 0 ]
 }
+
+@test "Pass when restoring a dump with redis-dump-go" {
+  run tests/restore.sh
+  [ "$status" -eq 0 ]
+}
@@ -0,0 +1,34 @@
+#!/bin/sh -e
+
+export DB=3
+
+echo "-> Filling Redis with Mock Data..."
+redis-cli -h redis -n $DB FLUSHDB
+/generator -output resp -type strings -n 1000 | redis-cli -h redis -n $DB --pipe
+redis-cli -h redis -n $DB RPUSH somelist a b c d e
+DBSIZE=`redis-cli -h redis -n $DB dbsize`
+
+echo "-> Dumping DB..."
+time /redis-dump-go -host redis -n 250 -db $DB -output commands -batchSize 2 >backup
+
+echo "-> Flushing DB and restoring dump with redis-dump-go..."
+redis-cli -h redis -n $DB FLUSHDB
+time /redis-dump-go restore -host redis -n 10 -input backup
+NEWDBSIZE=`redis-cli -h redis -n $DB dbsize`
+echo "Redis has $DBSIZE entries"
+
+echo "-> Comparing DB sizes..."
+if [ $DBSIZE -ne $NEWDBSIZE ]; then
+  echo "ERROR - restored DB has $NEWDBSIZE elements, expected $DBSIZE"
+  exit 1
+fi
+
+echo "-> Checking list order..."
+LIST=`redis-cli -h redis -n $DB LRANGE somelist 0 -1 | tr -d '\n'`
+if [ "$LIST" != "abcde" ]; then
+  echo "ERROR - restored list is $LIST, expected abcde"
+  exit 1
+fi
+
+echo "OK - $NEWDBSIZE elements"
+exit 0
@@ -2,6 +2,15 @@ module github.com/yannh/redis-dump-go
 
 go 1.22
 
-require github.com/mediocregopher/radix/v3 v3.8.1
+require (
+	filippo.io/age v1.2.1
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/mediocregopher/radix/v3 v3.8.1
+)
 
-require golang.org/x/xerrors v0.0.0-20240716161551-93cc26a95ae9 // indirect
+require (
+	golang.org/x/crypto v0.24.0 // indirect
+	golang.org/x/sys v0.21.0 // indirect
+	golang.org/x/xerrors v0.0.0-20240716161551-93cc26a95ae9 // indirect
+)
@@ -1,23 +1,39 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"filippo.io/age"
 	"github.com/yannh/redis-dump-go/pkg/config"
 	"github.com/yannh/redis-dump-go/pkg/redisdump"
 )
 
+// version is set at build time
+var version = "dev"
+
 type progressLogger struct {
 	stats map[uint8]int
+	unit  string
 }
 
-func newProgressLogger() *progressLogger {
+func newProgressLogger(unit string) *progressLogger {
 	return &progressLogger{
 		stats: map[uint8]int{},
+		unit:  unit,
 	}
 }
 
@@ -32,12 +48,293 @@ func (p *progressLogger) drawProgress(to io.Writer, db uint8, nDumped int) {
 		return
 	}
 
-	fmt.Fprintf(to, "\rDatabase %d: %d element dumped", db, nDumped)
+	fmt.Fprintf(to, "\rDatabase %d: %d %s", db, nDumped, p.unit)
+}
+
+// openInput opens the file name, or the S3 object it is the URL of
+func openInput(name string) (io.ReadCloser, error) {
+	if !redisdump.IsS3URL(name) {
+		return os.Open(name)
+	}
+	client, err := redisdump.S3ClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return client.Open(name)
+}
+
+// outputCreator returns the function creating the output file name: S3
+// objects for s3:// URLs, local files otherwise
+func outputCreator(name string) (func(string) (redisdump.Output, error), error) {
+	if !redisdump.IsS3URL(name) {
+		return redisdump.CreateLocalFile, nil
+	}
+	client, err := redisdump.S3ClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	return client.Create, nil
+}
+
+// decodeInput decrypts, with the identities of identityFile, and
+// decompresses the dump or RDB file read by restore and convert
+func decodeInput(in io.Reader, identityFile string) (io.ReadCloser, error) {
+	var identities []age.Identity
+	if identityFile != "" {
+		var err error
+		if identities, err = redisdump.LoadIdentities(identityFile); err != nil {
+			return nil, err
+		}
+	}
+	decrypted, err := redisdump.NewDecryptedReader(in, identities)
+	if err != nil {
+		return nil, err
+	}
+	return redisdump.NewDecompressedReader(decrypted)
+}
+
+func restoreMain(c config.Config, s redisdump.Host, conflict redisdump.ConflictPolicy) int {
+	var in io.Reader = os.Stdin
+	if c.Input != "" {
+		f, err := openInput(c.Input)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed opening dump: %s\n", err)
+			return 1
+		}
+		defer f.Close()
+		in = f
+	}
+	dump, err := decodeInput(in, c.Identity)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed reading dump: %s\n", err)
+		return 1
+	}
+	defer dump.Close()
+
+	progressNotifs := make(chan redisdump.ProgressNotification)
+	failures := make(chan redisdump.RestoreError)
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	pl := newProgressLogger("commands restored")
+	go func() {
+		for n := range progressNotifs {
+			if !(c.Silent) {
+				pl.drawProgress(os.Stderr, n.Db, n.Done)
+			}
+		}
+		wg.Done()
+	}()
+
+	go func() {
+		for f := range failures {
+			fmt.Fprintln(os.Stderr, "\nError: "+f.Error())
+		}
+		wg.Done()
+	}()
+
+	stats, err := redisdump.RestoreServer(s, dump, c.NWorkers, c.BatchSize, conflict, progressNotifs, failures)
+	close(progressNotifs)
+	close(failures)
+	wg.Wait()
+
+	if !(c.Silent) {
+		fmt.Fprintf(os.Stderr, "\n%d commands restored, %d failed\n", stats.Restored, stats.Failed)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		return 1
+	}
+	if stats.Failed > 0 {
+		return 1
+	}
+
+	return 0
+}
+
+// verifyMain checks the files of a dump against the sizes and SHA-256 of
+// its manifest
+func verifyMain(c config.Config) int {
+	if c.Manifest == "" {
+		fmt.Fprintln(os.Stderr, "verify requires -manifest")
+		return 1
+	}
+	f, err := openInput(c.Manifest)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed opening manifest: %s\n", err)
+		return 1
+	}
+	m, err := redisdump.LoadManifest(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	// A dump written to the standard output is read from -input, or from
+	// the standard input
+	stdout := len(m.Files) == 1 && m.Files[0].Name == ""
+	if c.Input != "" && len(m.Files) != 1 {
+		fmt.Fprintf(os.Stderr, "the dump has %d files, -input can only be verified against a single one\n", len(m.Files))
+		return 1
+	}
+
+	failed := 0
+	for _, file := range m.Files {
+		name := file.Name
+		if c.Input != "" {
+			name = c.Input
+		}
+		var in io.ReadCloser = os.Stdin
+		if name != "" {
+			if in, err = openInput(name); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+				failed++
+				continue
+			}
+		} else if stdout {
+			name = "standard input"
+		}
+		err = redisdump.VerifyFile(in, file)
+		in.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, err)
+			failed++
+		} else if !(c.Silent) {
+			fmt.Fprintf(os.Stderr, "%s: OK\n", name)
+		}
+	}
+
+	if !(c.Silent) {
+		fmt.Fprintf(os.Stderr, "%d keys dumped from %s at %s, %d of %d files verified\n", m.Keys, m.Source, m.EndTime.Format(time.RFC3339), len(m.Files)-failed, len(m.Files))
+	}
+	// Keys that failed to be dumped make the dump incomplete, even if its
+	// files are intact
+	if m.Errors > 0 {
+		fmt.Fprintf(os.Stderr, "%d keys failed to be dumped, and are missing from the dump\n", m.Errors)
+	}
+	if failed > 0 || m.Errors > 0 {
+		return 1
+	}
+	return 0
+}
+
+// targetTlsFiles returns the CA certificate, certificate and private key
+// files the -target server is connected to with. Those of the source server
+// are used unless given for the target: the certificate and its key are
+// taken together.
+func targetTlsFiles(c config.Config) (caCert string, cert string, key string) {
+	caCert, cert, key = c.CaCert, c.Cert, c.Key
+	if c.TargetCaCert != "" {
+		caCert = c.TargetCaCert
+	}
+	if c.TargetTlsCert != "" || c.TargetTlsKey != "" {
+		cert, key = c.TargetTlsCert, c.TargetTlsKey
+	}
+	return caCert, cert, key
+}
+
+// checkDumpFlags returns an error if flags that can not be used together
+// were passed to dump or convert. encrypted is set if the dump is
+// encrypted to recipients.
+func checkDumpFlags(c config.Config, compression redisdump.Compression, encrypted bool, conflict redisdump.ConflictPolicy) error {
+	// Keys the checkpoint records as dumped can still be buffered by the
+	// compression or the encryption when the dump crashes
+	if compression != redisdump.NoCompression && (c.Target != "" || c.Checkpoint != "" || c.Resume) {
+		return errors.New("-compress can not be used with -target, -checkpoint or -resume")
+	}
+	if encrypted && (c.Target != "" || c.Checkpoint != "" || c.Resume) {
+		return errors.New("-recipient and -recipientsFile can not be used with -target, -checkpoint or -resume")
+	}
+	if c.Manifest != "" && (c.Target != "" || c.Checkpoint != "" || c.Watch || c.Follow) {
+		return errors.New("-manifest can not be used with -target, -checkpoint, -watch or -follow")
+	}
+
+	if c.Out != "" {
+		if c.Target != "" || c.Checkpoint != "" {
+			return errors.New("-out can not be used with -target or -checkpoint")
+		}
+		if c.Output == "rdb" && (strings.Contains(c.Out, "{db}") || strings.Contains(c.Out, "{n}") || c.MaxFileSize > 0) {
+			return errors.New("-output rdb is written to a single file, without {db}, {n} or -maxFileSize")
+		}
+	} else if c.MaxFileSize > 0 {
+		return errors.New("-maxFileSize requires -out")
+	}
+	if c.Output == "rdb" && c.Checkpoint != "" {
+		return errors.New("-checkpoint can not be used with -output rdb")
+	}
+
+	if c.Target != "" {
+		if c.Output != "resp" && c.Output != "commands" && c.Output != "dump" {
+			return errors.New("-target requires -output resp, commands or dump")
+		}
+		if !c.TargetTls && (c.TargetCaCert != "" || c.TargetTlsCert != "" || c.TargetTlsKey != "") {
+			return errors.New("-targetCaCert, -targetTlsCert and -targetTlsKey require -targetTls")
+		}
+	} else if c.TargetUser != "" || c.TargetTls || c.TargetCaCert != "" || c.TargetTlsCert != "" || c.TargetTlsKey != "" {
+		return errors.New("-targetUser, -targetTls, -targetCaCert, -targetTlsCert and -targetTlsKey require -target")
+	}
+
+	if conflict != redisdump.MergeKeys {
+		if c.Output == "json" || c.Output == "rdb" {
+			return errors.New("-conflict can not be used with -output json or rdb")
+		}
+		// Changes to keys would be skipped, as they exist once restored
+		if conflict == redisdump.SkipExistingKeys && (c.Watch || c.Follow) {
+			return errors.New("-conflict skip-existing can not be used with -watch or -follow")
+		}
+	}
+	if c.RenameTo != "" && c.RenameKeys == "" {
+		return errors.New("-renameTo requires -renameKeys")
+	}
+
+	if c.Checkpoint != "" {
+		if c.Cluster || c.Noscan {
+			return errors.New("-checkpoint can not be used with -cluster or -noscan")
+		}
+	} else if c.Resume {
+		return errors.New("-resume requires -checkpoint")
+	}
+
+	if c.Psync {
+		if c.Output == "dump" || c.Checkpoint != "" || c.Cluster || c.Command == "convert" {
+			return errors.New("-output dump, -checkpoint, -cluster and convert can not be used with -psync")
+		}
+		if c.Follow && c.Output != "resp" && c.Output != "commands" {
+			return errors.New("-follow requires -output resp or commands")
+		}
+	} else if c.Follow {
+		return errors.New("-follow requires -psync")
+	}
+
+	if c.Watch {
+		if c.Checkpoint != "" || c.Cluster || c.Psync || c.Command == "convert" {
+			return errors.New("-checkpoint, -cluster, -psync and convert can not be used with -watch")
+		}
+		if c.Output != "resp" && c.Output != "commands" && c.Output != "dump" {
+			return errors.New("-watch requires -output resp, commands or dump")
+		}
+	}
+
+	if c.Command == "convert" && (c.Output == "dump" || c.Checkpoint != "" || c.Cluster) {
+		return errors.New("-output dump, -checkpoint and -cluster can not be used with convert")
+	}
+	if c.Cluster && c.Db > 0 {
+		return errors.New("Redis Cluster only supports database 0")
+	}
+	return nil
 }
 
 func realMain() int {
 	var err error
 
+	// The first SIGINT or SIGTERM interrupts the dump cleanly, a second one exits immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	c, outBuf, err := config.FromFlags(os.Args[0], os.Args[1:])
 	if outBuf != "" {
 		out := os.Stderr
@@ -54,6 +351,10 @@ func realMain() int {
 		return 1
 	}
 
+	if c.Command == "verify" {
+		return verifyMain(c)
+	}
+
 	var tlshandler *redisdump.TlsHandler = nil
 	if c.Tls == true {
 		tlshandler, err = redisdump.NewTlsHandler(c.CaCert, c.Cert, c.Key, c.Insecure)
@@ -63,19 +364,219 @@ func realMain() int {
 		}
 	}
 
-	var serializer func([]string) string
+	redisPassword := os.Getenv("REDISDUMPGO_AUTH")
+
+	s := redisdump.Host{
+		Host:       c.Host,
+		Port:       c.Port,
+		Username:   c.Username,
+		Password:   redisPassword,
+		TlsHandler: tlshandler,
+	}
+
+	if c.Sentinel != "" {
+		sentinels := strings.Split(c.Sentinel, ",")
+		s, err = redisdump.ResolveSentinel(sentinels, c.Master, c.Replica, c.SentinelUser, os.Getenv("REDISDUMPGO_SENTINEL_AUTH"), s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		if !(c.Silent) {
+			fmt.Fprintf(os.Stderr, "Using %s:%d, resolved through sentinel\n", s.Host, s.Port)
+		}
+	} else if c.Replica || c.SentinelUser != "" {
+		fmt.Fprintln(os.Stderr, "-replica and -sentinelUser require -sentinel")
+		return 1
+	}
+
+	conflict, err := redisdump.ParseConflictPolicy(c.Conflict)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if c.Command == "restore" {
+		return restoreMain(c, s, conflict)
+	}
+
+	compression, err := redisdump.ParseCompression(c.Compress)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	recipients, err := redisdump.ParseRecipients(c.Recipient, c.RecipientsFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	if err := checkDumpFlags(c, compression, len(recipients) > 0, conflict); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	// The output is compressed before being encrypted
+	newWriter := func(w io.Writer) (io.WriteCloser, error) {
+		return redisdump.NewOutputWriter(w, compression, runtime.GOMAXPROCS(0), recipients)
+	}
+	createOutput, err := outputCreator(c.Out)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+
+	// The manifest describes a complete dump, written once
+	var createManifest func(string) (redisdump.Output, error)
+	if c.Manifest != "" {
+		if createManifest, err = outputCreator(c.Manifest); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	var out io.WriteCloser
+	var outFile *redisdump.OutputFile
+	var stdoutSum *redisdump.ChecksumWriter
+	if c.Out != "" {
+		if c.Output == "rdb" {
+			if outFile, err = redisdump.CreateOutputFile(c.Out, createOutput, newWriter); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+		}
+	} else {
+		var stdout io.Writer = os.Stdout
+		if c.Manifest != "" {
+			stdoutSum = redisdump.NewChecksumWriter(os.Stdout)
+			stdout = stdoutSum
+		}
+		if out, err = newWriter(stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+	}
+
+	var serializer redisdump.Serializer
+	var rdbSerializer *redisdump.RDBSerializer
+	dumpPayloads := false
 	switch c.Output {
 	case "resp":
-		serializer = redisdump.RESPSerializer
+		serializer = redisdump.CmdSerializer(redisdump.RESPSerializer)
 
 	case "commands":
-		serializer = redisdump.RedisCmdSerializer
+		serializer = redisdump.CmdSerializer(redisdump.RedisCmdSerializer)
+
+	case "dump":
+		// DUMP payloads are binary, and can only be written as RESP
+		serializer = redisdump.CmdSerializer(redisdump.RESPSerializer)
+		dumpPayloads = true
+
+	case "json":
+		serializer = redisdump.JSONSerializer{}
+
+	case "rdb":
+		var rdbOut io.Writer = out
+		if outFile != nil {
+			rdbOut = outFile
+		}
+		if rdbSerializer, err = redisdump.NewRDBSerializer(rdbOut); err != nil {
+			fmt.Fprintf(os.Stderr, "failed writing RDB file: %s\n", err)
+			return 1
+		}
+		serializer = rdbSerializer
 
 	default:
-		log.Fatalf("Failed parsing parameter flag: can only be resp or json")
+		log.Fatalf("Failed parsing parameter flag: can only be resp, commands, dump, json or rdb")
 	}
 
-	redisPassword := os.Getenv("REDISDUMPGO_AUTH")
+	var fileSerializer *redisdump.FileSerializer
+	if c.Out != "" && rdbSerializer == nil {
+		if fileSerializer, err = redisdump.NewFileSerializer(serializer, c.Out, int64(c.MaxFileSize)<<20, createOutput, newWriter); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		serializer = fileSerializer
+	}
+
+	// Once commands can no longer be sent to the target, the dump is interrupted
+	dumpCtx, cancelDump := context.WithCancel(ctx)
+	defer cancelDump()
+
+	var target *redisdump.TargetSerializer
+	targetFailures := make(chan redisdump.RestoreError)
+	var failuresDone sync.WaitGroup
+	if c.Target != "" {
+		host, port, err := net.SplitHostPort(c.Target)
+		if err != nil {
+			host, port = c.Target, "6379"
+		}
+		targetPort, err := strconv.Atoi(port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -target port %s\n", port)
+			return 1
+		}
+		var targetTlsHandler *redisdump.TlsHandler
+		if c.TargetTls {
+			caCert, cert, key := targetTlsFiles(c)
+			if targetTlsHandler, err = redisdump.NewTlsHandler(caCert, cert, key, c.Insecure); err != nil {
+				fmt.Fprintln(os.Stderr, err.Error())
+				return 1
+			}
+		}
+
+		nWorkers := c.NWorkers
+		if c.Follow {
+			// Replicated transactions span several keys, a single
+			// connection applies them in order
+			nWorkers = 1
+		}
+		failuresDone.Add(1)
+		go func() {
+			for f := range targetFailures {
+				fmt.Fprintln(os.Stderr, "\nError: "+f.Error())
+			}
+			failuresDone.Done()
+		}()
+		t := redisdump.Host{
+			Host:       host,
+			Port:       targetPort,
+			Username:   c.TargetUser,
+			Password:   os.Getenv("REDISDUMPGO_TARGET_AUTH"),
+			TlsHandler: targetTlsHandler,
+		}
+		target = redisdump.NewTargetSerializer(t, nWorkers, c.BatchSize, targetFailures, func(error) { cancelDump() })
+		serializer = target
+	}
+
+	if conflict != redisdump.MergeKeys {
+		serializer = redisdump.NewConflictSerializer(serializer, conflict)
+	}
+
+	// Keys are renamed before the conflict policy and the target apply to
+	// them
+	if c.StripPrefix != "" || c.AddPrefix != "" || c.RenameKeys != "" || c.RemapDb != "" {
+		var rename func(string) string
+		if c.StripPrefix != "" || c.AddPrefix != "" || c.RenameKeys != "" {
+			var pattern *regexp.Regexp
+			if c.RenameKeys != "" {
+				if pattern, err = regexp.Compile(c.RenameKeys); err != nil {
+					fmt.Fprintf(os.Stderr, "invalid -renameKeys: %s\n", err)
+					return 1
+				}
+			}
+			rename = redisdump.KeyRenamer(c.StripPrefix, pattern, c.RenameTo, c.AddPrefix)
+		}
+		dbs, err := redisdump.ParseDBMapping(c.RemapDb)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			return 1
+		}
+		serializer = redisdump.NewRenameSerializer(serializer, rename, dbs)
+	}
+
+	// Keys are counted as they were read, before being renamed
+	var manifestSerializer *redisdump.ManifestSerializer
+	if c.Manifest != "" {
+		manifestSerializer = redisdump.NewManifestSerializer(serializer)
+		serializer = manifestSerializer
+	}
 
 	progressNotifs := make(chan redisdump.ProgressNotification)
 	var wg sync.WaitGroup
@@ -89,9 +590,19 @@ func realMain() int {
 		}
 	}()
 
-	pl := newProgressLogger()
+	unit := "element dumped"
+	if c.Command == "convert" {
+		unit = "keys converted"
+	}
+	pl := newProgressLogger(unit)
+	// scanned is the number of keys found in each database
+	var scannedMu sync.Mutex
+	scanned := map[uint8]int{}
 	go func() {
 		for n := range progressNotifs {
+			scannedMu.Lock()
+			scanned[n.Db] = n.Done
+			scannedMu.Unlock()
 			if !(c.Silent) {
 				pl.drawProgress(os.Stderr, n.Db, n.Done)
 			}
@@ -99,7 +610,20 @@ func realMain() int {
 		wg.Done()
 	}()
 
-	logger := log.New(os.Stdout, "", 0)
+	// Nothing is written to the logger with -out
+	var logOut io.Writer = os.Stdout
+	if out != nil {
+		logOut = out
+	}
+	logger := log.New(logOut, "", 0)
+
+	ttlMode := redisdump.NoTTL
+	if c.WithTTL {
+		ttlMode = redisdump.AbsoluteTTL
+		if c.RelativeTTL {
+			ttlMode = redisdump.RelativeTTL
+		}
+	}
 
 	var db = new(uint8)
 	// If the user passed a db as parameter, we only dump that db
@@ -109,16 +633,155 @@ func realMain() int {
 		db = redisdump.AllDBs
 	}
 
-	s := redisdump.Host{
-		Host:       c.Host,
-		Port:       c.Port,
-		Username:   c.Username,
-		Password:   redisPassword,
-		TlsHandler: tlshandler,
+	var checkpoint *redisdump.Checkpoint
+	if c.Checkpoint != "" {
+		if c.Resume {
+			if checkpoint, err = redisdump.LoadCheckpoint(c.Checkpoint); err != nil {
+				fmt.Fprintf(os.Stderr, "failed loading checkpoint: %s\n", err)
+				return 1
+			}
+		} else {
+			checkpoint = redisdump.NewCheckpoint(c.Checkpoint)
+		}
+	}
+
+	var rdbFile io.Reader = os.Stdin
+	if c.Command == "convert" {
+		if c.Input != "" {
+			f, err := openInput(c.Input)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed opening RDB file: %s\n", err)
+				return 1
+			}
+			defer f.Close()
+			rdbFile = f
+		}
+		rdb, err := decodeInput(rdbFile, c.Identity)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed reading RDB file: %s\n", err)
+			return 1
+		}
+		defer rdb.Close()
+		rdbFile = rdb
+	}
+
+	manifest := &redisdump.Manifest{
+		Version:   version,
+		Source:    fmt.Sprintf("%s:%d", s.Host, s.Port),
+		StartTime: time.Now().UTC(),
+		Output:    c.Output,
+		Filter:    c.Filter,
+	}
+	if c.Command == "convert" {
+		manifest.Source = c.Input
+		if c.Input == "" {
+			manifest.Source = "standard input"
+		}
+	} else if manifestSerializer != nil {
+		// The version is informative, servers can deny INFO
+		manifest.RedisVersion, _ = redisdump.ServerVersion(s)
 	}
 
-	if err = redisdump.DumpServer(s, db, c.Filter, c.NWorkers, c.WithTTL, c.BatchSize, c.Noscan, logger, serializer, progressNotifs); err != nil {
-		fmt.Fprintf(os.Stderr, "%s", err)
+	opts := redisdump.DumpOptions{
+		Filter:         c.Filter,
+		NWorkers:       c.NWorkers,
+		TTLMode:        ttlMode,
+		BatchSize:      c.BatchSize,
+		ChunkThreshold: c.ChunkThreshold,
+		Atomic:         c.Atomic,
+		Noscan:         c.Noscan,
+		DumpPayloads:   dumpPayloads,
+		MaxErrors:      c.MaxErrors,
+	}
+	if c.Command == "convert" {
+		err = redisdump.ConvertRDB(dumpCtx, rdbFile, db, c.Filter, ttlMode, c.BatchSize, c.MaxErrors, logger, serializer, progressNotifs)
+	} else if c.Psync {
+		err = redisdump.DumpReplication(dumpCtx, s, db, c.Filter, ttlMode, c.BatchSize, c.MaxErrors, c.Follow, logger, serializer, progressNotifs)
+	} else if c.Watch {
+		err = redisdump.WatchServer(dumpCtx, s, db, opts, logger, serializer, progressNotifs)
+	} else if c.Cluster {
+		err = redisdump.DumpClusterContext(dumpCtx, s, opts, logger, serializer, progressNotifs)
+	} else {
+		err = redisdump.DumpServerContext(dumpCtx, s, db, opts, checkpoint, logger, serializer, progressNotifs)
+	}
+	// The RDB file and the -out files are only completed if all keys could
+	// be read, or some keys failed without interrupting the dump
+	completed := redisdump.DumpCompleted(err)
+	if rdbSerializer != nil {
+		if !completed {
+			rdbSerializer.Abort()
+		} else if rdbErr := rdbSerializer.Close(); rdbErr != nil && err == nil {
+			err = fmt.Errorf("failed writing RDB file: %w", rdbErr)
+		}
+	}
+	if fileSerializer != nil {
+		if fileErr := fileSerializer.Finish(err); fileErr != nil && err == nil {
+			err = fileErr
+		}
+	}
+	if outFile != nil {
+		if !completed || err != nil {
+			outFile.Abort()
+		} else {
+			err = outFile.Commit()
+		}
+	}
+	// The end of the compressed and encrypted streams is written even if
+	// the dump was interrupted, after IncompleteDumpMarker
+	if out != nil {
+		if closeErr := out.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	var dumpErrs *redisdump.DumpErrors
+	if manifestSerializer != nil && completed && (err == nil || errors.As(err, &dumpErrs)) {
+		var failed map[uint8]int
+		if dumpErrs != nil {
+			failed = dumpErrs.Failed
+		}
+		scannedMu.Lock()
+		manifestSerializer.Count(manifest, scanned, failed)
+		scannedMu.Unlock()
+		manifest.EndTime = time.Now().UTC()
+		switch {
+		case fileSerializer != nil:
+			manifest.Files = fileSerializer.Files()
+		case outFile != nil:
+			manifest.Files = []redisdump.ManifestFile{outFile.File()}
+		default:
+			manifest.Files = []redisdump.ManifestFile{stdoutSum.File("")}
+		}
+		for _, f := range manifest.Files {
+			manifest.Bytes += f.Size
+		}
+		if manifestErr := manifest.Save(c.Manifest, createManifest); manifestErr != nil && err == nil {
+			err = fmt.Errorf("failed writing manifest: %w", manifestErr)
+		}
+	}
+	targetFailed := false
+	if target != nil {
+		stats, targetErr := target.Close()
+		close(targetFailures)
+		failuresDone.Wait()
+		if !(c.Silent) {
+			fmt.Fprintf(os.Stderr, "\n%d commands sent to the target, %d failed\n", stats.Restored, stats.Failed)
+		}
+		// The dump reports it was interrupted
+		if targetErr != nil && !errors.Is(targetErr, redisdump.ErrIncompleteDump) {
+			fmt.Fprintf(os.Stderr, "\n%s\n", targetErr)
+			return 1
+		}
+		targetFailed = stats.Failed > 0
+	}
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(os.Stderr, "\ndump interrupted, the output is incomplete\n")
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n%s\n", err)
+		return 1
+	}
+	if targetFailed {
 		return 1
 	}
 
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yannh/redis-dump-go/pkg/config"
+	"github.com/yannh/redis-dump-go/pkg/redisdump"
+)
+
+func TestCheckDumpFlags(t *testing.T) {
+	for i, testCase := range []struct {
+		c           config.Config
+		compression redisdump.Compression
+		encrypted   bool
+		err         bool
+	}{
+		{config.Config{Output: "resp"}, redisdump.NoCompression, false, false},
+		{config.Config{Output: "resp"}, redisdump.ZstdCompression, true, false},
+		{config.Config{Output: "resp", Checkpoint: "dump.checkpoint"}, redisdump.NoCompression, false, false},
+		{config.Config{Output: "resp", Checkpoint: "dump.checkpoint"}, redisdump.GzipCompression, false, true},
+		{config.Config{Output: "resp", Checkpoint: "dump.checkpoint", Resume: true}, redisdump.ZstdCompression, false, true},
+		{config.Config{Output: "resp", Target: "127.0.0.1:6380"}, redisdump.GzipCompression, false, true},
+		{config.Config{Output: "resp", Checkpoint: "dump.checkpoint"}, redisdump.NoCompression, true, true},
+		{config.Config{Output: "resp", Checkpoint: "dump.checkpoint", Resume: true}, redisdump.NoCompression, true, true},
+		{config.Config{Output: "resp", Target: "127.0.0.1:6380"}, redisdump.NoCompression, true, true},
+		{config.Config{Output: "resp", Resume: true}, redisdump.NoCompression, false, true},
+	} {
+		err := checkDumpFlags(testCase.c, testCase.compression, testCase.encrypted, redisdump.MergeKeys)
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: expected error %t, got %v", i, testCase.err, err)
+		}
+	}
+}
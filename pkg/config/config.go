@@ -7,23 +7,58 @@ import (
 )
 
 type Config struct {
-	Host      string
-	Port      int
-	Db        int
-	Username  string
-	Filter    string
-	Noscan    bool
-	BatchSize int
-	NWorkers  int
-	WithTTL   bool
-	Output    string
-	Silent    bool
-	Tls       bool
-	Insecure  bool
-	CaCert    string
-	Cert      string
-	Key       string
-	Help      bool
+	Command        string
+	Host           string
+	Port           int
+	Db             int
+	Username       string
+	Filter         string
+	Noscan         bool
+	BatchSize      int
+	ChunkThreshold int
+	Atomic         bool
+	NWorkers       int
+	WithTTL        bool
+	RelativeTTL    bool
+	MaxErrors      int
+	Checkpoint     string
+	Resume         bool
+	Output         string
+	Compress       string
+	Out            string
+	MaxFileSize    int
+	Manifest       string
+	Recipient      string
+	RecipientsFile string
+	Identity       string
+	Silent         bool
+	Cluster        bool
+	Sentinel       string
+	SentinelUser   string
+	Master         string
+	Replica        bool
+	Psync          bool
+	Follow         bool
+	Watch          bool
+	Target         string
+	TargetUser     string
+	TargetTls      bool
+	TargetCaCert   string
+	TargetTlsCert  string
+	TargetTlsKey   string
+	Conflict       string
+	StripPrefix    string
+	AddPrefix      string
+	RenameKeys     string
+	RenameTo       string
+	RemapDb        string
+	Tls            bool
+	Insecure       bool
+	CaCert         string
+	Cert           string
+	Key            string
+	Input          string
+	Help           bool
 }
 
 func isFlagPassed(flags *flag.FlagSet, name string) bool {
@@ -36,9 +71,22 @@ func isFlagPassed(flags *flag.FlagSet, name string) bool {
 	return found
 }
 
+// Commands other than dumping, given as first argument
+var commands = []string{"restore", "convert", "verify"}
+
 func FromFlags(progName string, args []string) (Config, string, error) {
 	c := Config{}
 
+	if len(args) > 0 {
+		for _, command := range commands {
+			if args[0] == command {
+				c.Command = command
+				args = args[1:]
+				break
+			}
+		}
+	}
+
 	flags := flag.NewFlagSet(progName, flag.ContinueOnError)
 	var outBuf bytes.Buffer
 	flags.SetOutput(&outBuf)
@@ -49,11 +97,45 @@ func FromFlags(progName string, args []string) (Config, string, error) {
 	flags.StringVar(&c.Username, "user", "", "Username")
 	flags.StringVar(&c.Filter, "filter", "*", "Key filter to use")
 	flags.BoolVar(&c.Noscan, "noscan", false, "Use KEYS * instead of SCAN - for Redis <=2.8")
-	flags.IntVar(&c.BatchSize, "batchSize", 1000, "HSET/RPUSH/SADD/ZADD only add 'batchSize' items at a time")
+	flags.IntVar(&c.BatchSize, "batchSize", 1000, "HSET/RPUSH/SADD/ZADD only add 'batchSize' items at a time, streams are read 'batchSize' entries at a time. restore and -target: pipeline 'batchSize' commands at a time")
+	flags.IntVar(&c.ChunkThreshold, "chunkThreshold", 10000, "Read hashes, sets, sorted sets, lists and streams of more than 'chunkThreshold' elements 'batchSize' elements at a time - -1 to always read them at once")
+	flags.BoolVar(&c.Atomic, "atomic", false, "Read the type, value and TTL of each key in a single transaction, reading keys that changed type again")
 	flags.IntVar(&c.NWorkers, "n", 10, "Parallel workers")
+	flags.StringVar(&c.Input, "input", "", "restore and verify: file to read the dump from, convert: RDB file to convert - s3://bucket/key reads an S3 object (default: standard input)")
 	flags.BoolVar(&c.WithTTL, "ttl", true, "Preserve Keys TTL")
-	flags.StringVar(&c.Output, "output", "resp", "Output type - can be resp or commands")
+	flags.BoolVar(&c.RelativeTTL, "relativeTTL", false, "Dump TTLs as the time keys have left to live (PEXPIRE) rather than as the time they expire at (PEXPIREAT) - keys then expire relative to the time of the restore")
+	flags.IntVar(&c.MaxErrors, "maxErrors", -1, "Abort the dump once more than 'maxErrors' keys failed to be dumped - 0 to fail on the first error, -1 to always dump all keys")
+	flags.StringVar(&c.Checkpoint, "checkpoint", "", "Save the progress of the dump to this file, so it can be resumed")
+	flags.BoolVar(&c.Resume, "resume", false, "Resume the dump from the progress saved to the -checkpoint file - append the output to the interrupted dump")
+	flags.StringVar(&c.Output, "output", "resp", "Output type - can be resp, commands, dump (RESTORE commands built from DUMP payloads, as RESP) json (one JSON object per key and line) or rdb (an RDB file)")
+	flags.StringVar(&c.Out, "out", "", "Write the output to files rather than to the standard output - {db} in the name is replaced with the database, each written to its own file, and {n} with the number of the file. Files are only created once the dump completed. s3://bucket/prefix/dump-{n}.resp uploads the files to S3, with credentials read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	flags.IntVar(&c.MaxFileSize, "maxFileSize", 0, "With -out, continue the dump in a new file once a file exceeds 'maxFileSize' MB - the name must contain {n}")
+	flags.StringVar(&c.Manifest, "manifest", "", "Write a JSON manifest of the dump to this file once it completed, with the SHA-256 of the output and statistics of the keys dumped - verify checks a dump against it")
+	flags.StringVar(&c.Compress, "compress", "", "Compress the output with gzip or zstd, on all CPUs - restore and convert decompress their input by themselves")
+	flags.StringVar(&c.Recipient, "recipient", "", "Encrypt the output with age to these public keys (age1...), separated by commas")
+	flags.StringVar(&c.RecipientsFile, "recipientsFile", "", "Encrypt the output with age to the public keys listed in this file, one per line")
+	flags.StringVar(&c.Identity, "identity", "", "restore and convert: decrypt the input with the age secret keys of this file")
 	flags.BoolVar(&c.Silent, "s", false, "Silent mode (disable logging of progress / stats)")
+	flags.BoolVar(&c.Cluster, "cluster", false, "Dump all primaries of the Redis Cluster the server is a node of")
+	flags.StringVar(&c.Sentinel, "sentinel", "", "Comma-separated list of sentinels (host:port) to ask for the server to dump, instead of -host and -port")
+	flags.StringVar(&c.SentinelUser, "sentinelUser", "", "Username on the sentinels - their password is read from REDISDUMPGO_SENTINEL_AUTH")
+	flags.StringVar(&c.Master, "master", "mymaster", "Name of the master monitored by the sentinels")
+	flags.BoolVar(&c.Replica, "replica", false, "Dump from a replica of the master rather than from the master itself - requires -sentinel")
+	flags.BoolVar(&c.Psync, "psync", false, "Dump the snapshot the server sends to replicas (PSYNC) rather than reading keys one by one - a consistent, point-in-time dump")
+	flags.BoolVar(&c.Follow, "follow", false, "With -psync, keep writing the commands the server replicates after the snapshot, until interrupted - requires -output resp or commands")
+	flags.BoolVar(&c.Watch, "watch", false, "After the dump, keep writing the keys that change on the server, as keyspace notifications report them, until interrupted - requires notify-keyspace-events to publish key events (EA)")
+	flags.StringVar(&c.Target, "target", "", "Send the keys to this server (host:port) rather than writing them out - migrate keys from server to server")
+	flags.StringVar(&c.TargetUser, "targetUser", "", "Username on the -target server")
+	flags.BoolVar(&c.TargetTls, "targetTls", false, "Connect to the -target server with TLS, using the TLS options unless -targetCaCert, -targetTlsCert or -targetTlsKey are given")
+	flags.StringVar(&c.TargetCaCert, "targetCaCert", "", "CA Certificate file to verify the -target server with (default: -cacert)")
+	flags.StringVar(&c.TargetTlsCert, "targetTlsCert", "", "Certificate file to authenticate with on the -target server, with -targetTlsKey (default: -cert)")
+	flags.StringVar(&c.TargetTlsKey, "targetTlsKey", "", "Private key file of -targetTlsCert (default: -key)")
+	flags.StringVar(&c.Conflict, "conflict", "merge", "How keys already on the server the dump is restored to are handled - merge (elements are added to them), replace (they are deleted first) or skip-existing (they are left as they are). Applied by the dump, or by restore")
+	flags.StringVar(&c.StripPrefix, "stripPrefix", "", "Remove this prefix from the names of the keys dumped")
+	flags.StringVar(&c.AddPrefix, "addPrefix", "", "Add this prefix to the names of the keys dumped, once -stripPrefix and -renameKeys applied")
+	flags.StringVar(&c.RenameKeys, "renameKeys", "", "Replace the matches of this regular expression in the names of the keys dumped with -renameTo")
+	flags.StringVar(&c.RenameTo, "renameTo", "", "Replacement for the matches of -renameKeys - $1 refers to the first submatch")
+	flags.StringVar(&c.RemapDb, "remapDb", "", "Databases to write keys to in the dump or on the -target server, as source->target pairs separated by commas, such as 0->5,1->6 (default: the database keys were read from)")
 	flags.BoolVar(&c.Tls, "tls", false, "Establish a secure TLS connection")
 	flags.BoolVar(&c.Insecure, "insecure", false, "Allow insecure TLS connection by skipping cert validation")
 	flags.StringVar(&c.CaCert, "cacert", "", "CA Certificate file to verify with")
@@ -62,6 +144,9 @@ func FromFlags(progName string, args []string) (Config, string, error) {
 	flags.BoolVar(&c.Help, "h", false, "show help information")
 	flags.Usage = func() {
 		fmt.Fprintf(&outBuf, "Usage: %s [OPTION]...\n", progName)
+		fmt.Fprintf(&outBuf, "       %s restore [OPTION]...\n", progName)
+		fmt.Fprintf(&outBuf, "       %s convert [OPTION]...\n", progName)
+		fmt.Fprintf(&outBuf, "       %s verify -manifest FILE [OPTION]...\n", progName)
 		flags.PrintDefaults()
 	}
 
@@ -13,115 +13,386 @@ func TestFromFlags(t *testing.T) {
 		{
 			[]string{},
 			Config{
-				Db:        -1,
-				Host:      "127.0.0.1",
-				Port:      6379,
-				Filter:    "*",
-				BatchSize: 1000,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Insecure:  false,
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Insecure:       false,
 			},
 		},
 		{
 			[]string{"-db", "2"},
 			Config{
-				Db:        2,
-				Host:      "127.0.0.1",
-				Port:      6379,
-				Filter:    "*",
-				BatchSize: 1000,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Insecure:  false,
+				Db:             2,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Insecure:       false,
 			},
 		},
 		{
 			[]string{"-ttl=false"},
 			Config{
-				Db:        -1,
-				Host:      "127.0.0.1",
-				Port:      6379,
-				Filter:    "*",
-				BatchSize: 1000,
-				NWorkers:  10,
-				WithTTL:   false,
-				Output:    "resp",
-				Insecure:  false,
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        false,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Insecure:       false,
 			},
 		},
 		{
 			[]string{"-host", "redis", "-port", "1234", "-batchSize", "10", "-n", "5", "-output", "commands"},
 			Config{
-				Db:        -1,
-				Host:      "redis",
-				Port:      1234,
-				Filter:    "*",
-				BatchSize: 10,
-				NWorkers:  5,
-				WithTTL:   true,
-				Output:    "commands",
-				Insecure:  false,
+				Db:             -1,
+				Host:           "redis",
+				Port:           1234,
+				Filter:         "*",
+				BatchSize:      10,
+				ChunkThreshold: 10000,
+				NWorkers:       5,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "commands",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Insecure:       false,
 			},
 		},
 		{
 			[]string{"-host", "redis", "-port", "1234", "-batchSize", "10", "-user", "test", "-insecure"},
 			Config{
-				Db:        -1,
-				Host:      "redis",
-				Port:      1234,
-				Filter:    "*",
-				BatchSize: 10,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Username:  "test",
-				Insecure:  true,
+				Db:             -1,
+				Host:           "redis",
+				Port:           1234,
+				Filter:         "*",
+				BatchSize:      10,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Username:       "test",
+				Insecure:       true,
 			},
 		},
 		{
 			[]string{"-host", "redis", "-port", "1234", "-batchSize", "10", "-user", "test"},
 			Config{
-				Db:        -1,
-				Host:      "redis",
-				Port:      1234,
-				Filter:    "*",
-				BatchSize: 10,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Username:  "test",
+				Db:             -1,
+				Host:           "redis",
+				Port:           1234,
+				Filter:         "*",
+				BatchSize:      10,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Username:       "test",
 			},
 		},
 		{
 			[]string{"-db", "1"},
 			Config{
-				Db:        1,
-				Host:      "127.0.0.1",
-				Port:      6379,
-				Filter:    "*",
-				BatchSize: 1000,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Insecure:  false,
+				Db:             1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Insecure:       false,
+			},
+		},
+		{
+			[]string{"restore", "-port", "1234", "-input", "dump.resp"},
+			Config{
+				Command:        "restore",
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           1234,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Input:          "dump.resp",
+			},
+		},
+		{
+			[]string{"restore", "-conflict", "skip-existing", "-identity", "key.txt"},
+			Config{
+				Command:        "restore",
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "skip-existing",
+				Identity:       "key.txt",
+			},
+		},
+		{
+			[]string{"-stripPrefix", "prod:", "-addPrefix", "staging:", "-renameKeys", "^user:([0-9]+)$", "-renameTo", "u:$1", "-remapDb", "0->5,1->6"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				StripPrefix:    "prod:",
+				AddPrefix:      "staging:",
+				RenameKeys:     "^user:([0-9]+)$",
+				RenameTo:       "u:$1",
+				RemapDb:        "0->5,1->6",
+			},
+		},
+		{
+			[]string{"-compress", "zstd", "-output", "commands", "-out", "dump-db{db}-{n}.txt.zst", "-maxFileSize", "100", "-recipient", "age1a,age1b", "-recipientsFile", "recipients.txt"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "commands",
+				Compress:       "zstd",
+				Out:            "dump-db{db}-{n}.txt.zst",
+				MaxFileSize:    100,
+				Recipient:      "age1a,age1b",
+				RecipientsFile: "recipients.txt",
+				Master:         "mymaster",
+				Conflict:       "merge",
+			},
+		},
+		{
+			[]string{"convert", "-input", "dump.rdb", "-filter", "user:*", "-output", "json"},
+			Config{
+				Command:        "convert",
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "user:*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "json",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Input:          "dump.rdb",
+			},
+		},
+		{
+			[]string{"verify", "-manifest", "dump.json", "-input", "dump.resp.zst"},
+			Config{
+				Command:        "verify",
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Manifest:       "dump.json",
+				Input:          "dump.resp.zst",
+			},
+		},
+		{
+			[]string{"-cluster", "-port", "7000"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           7000,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Cluster:        true,
+			},
+		},
+		{
+			[]string{"-sentinel", "10.0.0.1:26379,10.0.0.2", "-sentinelUser", "sentinel", "-master", "redis1", "-replica"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Sentinel:       "10.0.0.1:26379,10.0.0.2",
+				SentinelUser:   "sentinel",
+				Master:         "redis1",
+				Conflict:       "merge",
+				Replica:        true,
+			},
+		},
+		{
+			[]string{"-psync", "-follow", "-db", "0"},
+			Config{
+				Db:             0,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Psync:          true,
+				Follow:         true,
+			},
+		},
+		{
+			[]string{"-watch", "-output", "commands"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "commands",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Watch:          true,
+			},
+		},
+		{
+			[]string{"-target", "10.0.0.1:6380", "-targetUser", "migrator", "-targetTls", "-targetCaCert", "target-ca.pem", "-targetTlsCert", "target.pem", "-targetTlsKey", "target.key", "-remapDb", "0->1", "-output", "dump"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "dump",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Target:         "10.0.0.1:6380",
+				TargetUser:     "migrator",
+				TargetTls:      true,
+				TargetCaCert:   "target-ca.pem",
+				TargetTlsCert:  "target.pem",
+				TargetTlsKey:   "target.key",
+				RemapDb:        "0->1",
+			},
+		},
+		{
+			[]string{"-checkpoint", "dump.checkpoint", "-resume"},
+			Config{
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Checkpoint:     "dump.checkpoint",
+				Resume:         true,
 			},
 		},
 		{
 			[]string{"-h"},
 			Config{
-				Db:        -1,
-				Host:      "127.0.0.1",
-				Port:      6379,
-				Filter:    "*",
-				BatchSize: 1000,
-				NWorkers:  10,
-				WithTTL:   true,
-				Output:    "resp",
-				Help:      true,
-				Insecure:  false,
+				Db:             -1,
+				Host:           "127.0.0.1",
+				Port:           6379,
+				Filter:         "*",
+				BatchSize:      1000,
+				ChunkThreshold: 10000,
+				NWorkers:       10,
+				WithTTL:        true,
+				MaxErrors:      -1,
+				Output:         "resp",
+				Master:         "mymaster",
+				Conflict:       "merge",
+				Help:           true,
+				Insecure:       false,
 			},
 		},
 	}
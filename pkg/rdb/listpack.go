@@ -0,0 +1,176 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+var errInvalidListpack = errors.New("invalid listpack")
+
+// listpack builds a listpack, the compact list of strings and integers
+// Redis stores the entries of streams in
+type listpack struct {
+	entries []byte
+	n       int
+}
+
+// appendEntry appends an encoded element, followed by its length encoded
+// backwards, so the listpack can be walked from its end
+func (lp *listpack) appendEntry(enc []byte) {
+	lp.entries = append(lp.entries, enc...)
+
+	l := uint64(len(enc))
+	switch listpackBacklenSize(len(enc)) {
+	case 1:
+		lp.entries = append(lp.entries, byte(l))
+	case 2:
+		lp.entries = append(lp.entries, byte(l>>7), byte(l&127)|128)
+	case 3:
+		lp.entries = append(lp.entries, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case 4:
+		lp.entries = append(lp.entries, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	default:
+		lp.entries = append(lp.entries, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+	lp.n++
+}
+
+// appendInt appends v with the smallest integer encoding it fits in
+func (lp *listpack) appendInt(v int64) {
+	var enc []byte
+	switch {
+	case v >= 0 && v <= 127:
+		enc = []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & (1<<13 - 1)
+		enc = []byte{0xC0 | byte(u>>8), byte(u)}
+	case v >= math.MinInt16 && v <= math.MaxInt16:
+		enc = []byte{0xF1, byte(v), byte(v >> 8)}
+	case v >= -1<<23 && v < 1<<23:
+		enc = []byte{0xF2, byte(v), byte(v >> 8), byte(v >> 16)}
+	case v >= math.MinInt32 && v <= math.MaxInt32:
+		enc = []byte{0xF3, byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+	default:
+		enc = make([]byte, 9)
+		enc[0] = 0xF4
+		binary.LittleEndian.PutUint64(enc[1:], uint64(v))
+	}
+	lp.appendEntry(enc)
+}
+
+// appendString appends s as a string
+func (lp *listpack) appendString(s string) {
+	var enc []byte
+	switch l := len(s); {
+	case l < 64:
+		enc = append([]byte{0x80 | byte(l)}, s...)
+	case l < 4096:
+		enc = append([]byte{0xE0 | byte(l>>8), byte(l)}, s...)
+	default:
+		enc = make([]byte, 5, 5+l)
+		enc[0] = 0xF0
+		binary.LittleEndian.PutUint32(enc[1:], uint32(l))
+		enc = append(enc, s...)
+	}
+	lp.appendEntry(enc)
+}
+
+// bytes returns the listpack: its total size and number of elements,
+// the elements, and a terminator
+func (lp *listpack) bytes() []byte {
+	b := make([]byte, 6, 6+len(lp.entries)+1)
+	binary.LittleEndian.PutUint32(b, uint32(6+len(lp.entries)+1))
+	n := lp.n
+	if n > math.MaxUint16 {
+		// The number of elements is unknown, and must be counted
+		n = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(b[4:], uint16(n))
+	b = append(b, lp.entries...)
+	return append(b, 0xFF)
+}
+
+// listpackBacklenSize returns the number of bytes the length l of an
+// element is encoded on, after the element
+func listpackBacklenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// parseListpack returns the elements of a listpack, integers formatted as
+// strings
+func parseListpack(lp []byte) ([]string, error) {
+	if len(lp) < 7 || int(binary.LittleEndian.Uint32(lp)) != len(lp) || lp[len(lp)-1] != 0xFF {
+		return nil, errInvalidListpack
+	}
+
+	var elements []string
+	for p := 6; lp[p] != 0xFF; {
+		start := p
+		b := lp[p]
+		var strLen, strStart int
+		isInt := false
+		var v int64
+		switch {
+		case b&0x80 == 0:
+			v, isInt, p = int64(b), true, p+1
+		case b&0xC0 == 0x80:
+			strLen, strStart = int(b&0x3F), p+1
+		case b&0xE0 == 0xC0:
+			if p+1 >= len(lp) {
+				return nil, errInvalidListpack
+			}
+			v = int64(b&0x1F)<<8 | int64(lp[p+1])
+			if v >= 1<<12 {
+				v -= 1 << 13
+			}
+			isInt, p = true, p+2
+		case b&0xF0 == 0xE0:
+			if p+1 >= len(lp) {
+				return nil, errInvalidListpack
+			}
+			strLen, strStart = int(b&0x0F)<<8|int(lp[p+1]), p+2
+		case b == 0xF0:
+			if p+5 >= len(lp) {
+				return nil, errInvalidListpack
+			}
+			strLen, strStart = int(binary.LittleEndian.Uint32(lp[p+1:])), p+5
+		case b >= 0xF1 && b <= 0xF4:
+			size := []int{2, 3, 4, 8}[b-0xF1]
+			if p+size >= len(lp) {
+				return nil, errInvalidListpack
+			}
+			v, isInt, p = littleEndianInt(lp[p+1:p+1+size]), true, p+1+size
+		default:
+			return nil, errInvalidListpack
+		}
+
+		if isInt {
+			elements = append(elements, strconv.FormatInt(v, 10))
+		} else {
+			if strStart+strLen >= len(lp) {
+				return nil, errInvalidListpack
+			}
+			elements = append(elements, string(lp[strStart:strStart+strLen]))
+			p = strStart + strLen
+		}
+
+		p += listpackBacklenSize(p - start)
+		if p >= len(lp) {
+			return nil, errInvalidListpack
+		}
+	}
+
+	return elements, nil
+}
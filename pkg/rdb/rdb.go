@@ -0,0 +1,155 @@
+// Package rdb reads and writes files in the RDB format Redis persists its
+// datasets with, so they can be loaded by Redis or other RDB tooling as
+// they are, or read without a server.
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of the RDB format written, supported by Redis 5.0
+// and later
+const Version = 9
+
+// Opcodes of the RDB format, written before the keys of a database, or
+// before a key
+const (
+	opSlotInfo      = 0xF4
+	opFunction2     = 0xF5
+	opFunctionPreGA = 0xF6
+	opModuleAux     = 0xF7
+	opIdle          = 0xF8
+	opFreq          = 0xF9
+	opAux           = 0xFA
+	opResizeDB      = 0xFB
+	opExpireTimeMs  = 0xFC
+	opExpireTime    = 0xFD
+	opSelectDB      = 0xFE
+	opEOF           = 0xFF
+)
+
+// Types of the values of keys, as written in RDB files. Only the types
+// without a compact encoding are written, older Redis versions and the
+// compact encodings are read.
+const (
+	typeString           = 0
+	typeList             = 1
+	typeSet              = 2
+	typeZSet             = 3
+	typeHash             = 4
+	typeZSet2            = 5
+	typeModule           = 6
+	typeModule2          = 7
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZSetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZSetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+)
+
+// crcTable is the table of CRC-64/Jones, the checksum of RDB files
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc updates the checksum crc of an RDB file with p. Unlike the crc64
+// package, Redis does not invert the checksum before and after each update.
+func crc(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+// StreamID is the ID of an entry of a stream
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// ParseStreamID parses a stream ID written as ms-seq
+func ParseStreamID(s string) (StreamID, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return StreamID{}, fmt.Errorf("invalid stream ID %s", s)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID %s", s)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("invalid stream ID %s", s)
+	}
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// bytes returns the 128 bits big endian encoding of id, used by Redis to
+// index stream entries
+func (id StreamID) bytes() []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint64(b, id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+	return b
+}
+
+// StreamEntry is an entry of a stream. Fields is a list of field/value
+// pairs.
+type StreamEntry struct {
+	ID     StreamID
+	Fields []string
+}
+
+// StreamPendingEntry is an entry delivered to a consumer of a group but
+// not acknowledged yet. DeliveryTime is the Unix time in milliseconds it
+// was last delivered at.
+type StreamPendingEntry struct {
+	ID            StreamID
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+// StreamConsumer is a consumer of a group, with the IDs of the entries
+// pending for it. SeenTime is the Unix time in milliseconds it was last
+// active at.
+type StreamConsumer struct {
+	Name     string
+	SeenTime int64
+	Pending  []StreamID
+}
+
+// StreamGroup is a consumer group. The entries pending for its consumers
+// must be part of Pending. EntriesRead is the number of entries the group
+// read, -1 if unknown: it is only stored by Redis 7.0 and later, and is
+// not written.
+type StreamGroup struct {
+	Name        string
+	LastID      StreamID
+	EntriesRead int64
+	Pending     []StreamPendingEntry
+	Consumers   []StreamConsumer
+}
+
+// Stream is the value of a stream key
+type Stream struct {
+	Entries []StreamEntry
+	LastID  StreamID
+	Groups  []StreamGroup
+}
+
+// ZMember is a member of a sorted set
+type ZMember struct {
+	Member string
+	Score  float64
+}
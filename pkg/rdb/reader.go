@@ -0,0 +1,561 @@
+package rdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+var (
+	errNotRDB            = errors.New("not an RDB file")
+	errInvalidChecksum   = errors.New("invalid checksum, the file is corrupted")
+	errInvalidLength     = errors.New("invalid length")
+	errInvalidStreamNode = errors.New("invalid stream node")
+)
+
+// Key is a key read from an RDB file
+type Key struct {
+	Db   uint64
+	Name string
+	// Type is the type of the key, as returned by TYPE: string, list, set,
+	// hash, zset or stream. Keys of modules are of type module, and hold
+	// no value.
+	Type string
+	// Value is a string for strings, a []string for lists and sets, a
+	// map[string]string for hashes, a []ZMember for sorted sets and a
+	// *Stream for streams
+	Value interface{}
+	// ExpireAt is the Unix time in milliseconds the key expires at, 0 if
+	// it does not expire
+	ExpireAt int64
+}
+
+// Reader reads the keys of an RDB file, of any version and with any of
+// the encodings Redis uses for small values. Once a read failed, all
+// reads fail with the same error.
+type Reader struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	db      uint64
+	err     error
+}
+
+// NewReader reads the header of the RDB file r
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{r: bufio.NewReader(r)}
+	header := rd.readFixed(9)
+	if rd.err != nil || string(header[:5]) != "REDIS" {
+		return nil, errNotRDB
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return nil, errNotRDB
+	}
+	rd.version = version
+	return rd, nil
+}
+
+func (r *Reader) fail(err error) {
+	if r.err == nil && err != nil {
+		r.err = err
+	}
+}
+
+func (r *Reader) readFull(b []byte) {
+	if r.err != nil {
+		return
+	}
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		r.fail(err)
+		return
+	}
+	r.crc = crc(r.crc, b)
+}
+
+// readFixed reads n bytes, n being small. The bytes are zeroes once a read
+// failed.
+func (r *Reader) readFixed(n int) []byte {
+	b := make([]byte, n)
+	r.readFull(b)
+	return b
+}
+
+// readBytes reads n bytes, without allocating them all at once, so a
+// corrupted length fails on the end of the file rather than on memory
+func (r *Reader) readBytes(n uint64) []byte {
+	const chunkSize = 1 << 20
+	if n <= chunkSize {
+		return r.readFixed(int(n))
+	}
+
+	var buf bytes.Buffer
+	for n > 0 && r.err == nil {
+		chunk := r.readFixed(int(min(n, chunkSize)))
+		buf.Write(chunk)
+		n -= uint64(len(chunk))
+	}
+	return buf.Bytes()
+}
+
+func (r *Reader) readByte() byte {
+	return r.readFixed(1)[0]
+}
+
+// readLenEnc reads a length, or the encoding of a string if encoded is
+// true
+func (r *Reader) readLenEnc() (n uint64, encoded bool) {
+	b := r.readByte()
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false
+	case 1:
+		return uint64(b&0x3F)<<8 | uint64(r.readByte()), false
+	case 3:
+		return uint64(b & 0x3F), true
+	}
+
+	switch b {
+	case 0x80:
+		return uint64(binary.BigEndian.Uint32(r.readFixed(4))), false
+	case 0x81:
+		return binary.BigEndian.Uint64(r.readFixed(8)), false
+	}
+	r.fail(errInvalidLength)
+	return 0, false
+}
+
+func (r *Reader) readLen() uint64 {
+	n, encoded := r.readLenEnc()
+	if encoded {
+		r.fail(errInvalidLength)
+	}
+	return n
+}
+
+// readString reads a string, stored as is, as an integer, or compressed
+func (r *Reader) readString() string {
+	n, encoded := r.readLenEnc()
+	if !encoded {
+		return string(r.readBytes(n))
+	}
+
+	switch n {
+	case 0:
+		return strconv.FormatInt(littleEndianInt(r.readFixed(1)), 10)
+	case 1:
+		return strconv.FormatInt(littleEndianInt(r.readFixed(2)), 10)
+	case 2:
+		return strconv.FormatInt(littleEndianInt(r.readFixed(4)), 10)
+	case 3:
+		clen := r.readLen()
+		ulen := r.readLen()
+		compressed := r.readBytes(clen)
+		if r.err != nil {
+			return ""
+		}
+		s, err := lzfDecompress(compressed, int(ulen))
+		r.fail(err)
+		return string(s)
+	}
+	r.fail(fmt.Errorf("invalid string encoding %d", n))
+	return ""
+}
+
+// readMillis reads a Unix time in milliseconds
+func (r *Reader) readMillis() int64 {
+	return int64(binary.LittleEndian.Uint64(r.readFixed(8)))
+}
+
+// readScore reads the score of a sorted set member, stored as a string by
+// Redis versions before 3.0
+func (r *Reader) readScore() float64 {
+	switch l := r.readByte(); l {
+	case 253:
+		return math.NaN()
+	case 254:
+		return math.Inf(1)
+	case 255:
+		return math.Inf(-1)
+	default:
+		s := string(r.readFixed(int(l)))
+		score, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			r.fail(fmt.Errorf("invalid score %s", s))
+		}
+		return score
+	}
+}
+
+func (r *Reader) readID() StreamID {
+	b := r.readFixed(16)
+	return StreamID{Ms: binary.BigEndian.Uint64(b), Seq: binary.BigEndian.Uint64(b[8:])}
+}
+
+// readEncoded reads a string holding elements in one of the compact
+// encodings, and returns the elements
+func (r *Reader) readEncoded(parse func([]byte) ([]string, error)) []string {
+	s := r.readString()
+	if r.err != nil {
+		return nil
+	}
+	elements, err := parse([]byte(s))
+	r.fail(err)
+	return elements
+}
+
+// skipModuleValue skips a value of a module, or data of a module stored
+// outside of keys. Module values are a list of typed values, ended by 0.
+func (r *Reader) skipModuleValue() {
+	for r.err == nil {
+		switch op := r.readLen(); op {
+		case 0:
+			return
+		case 1, 2:
+			r.readLen()
+		case 3:
+			r.readFixed(4)
+		case 4:
+			r.readFixed(8)
+		case 5:
+			r.readString()
+		default:
+			r.fail(fmt.Errorf("invalid module value opcode %d", op))
+		}
+	}
+}
+
+// zMembers returns the members of a sorted set stored as a list of
+// members each followed by its score
+func zMembers(elements []string) ([]ZMember, error) {
+	if len(elements)%2 != 0 {
+		return nil, errors.New("invalid sorted set, members without score")
+	}
+	members := make([]ZMember, 0, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		score, err := strconv.ParseFloat(elements[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score %s", elements[i+1])
+		}
+		members = append(members, ZMember{Member: elements[i], Score: score})
+	}
+	return members, nil
+}
+
+// hashFields returns the fields of a hash stored as a list of fields each
+// followed by its value
+func hashFields(elements []string) (map[string]string, error) {
+	if len(elements)%2 != 0 {
+		return nil, errors.New("invalid hash, fields without value")
+	}
+	val := make(map[string]string, len(elements)/2)
+	for i := 0; i < len(elements); i += 2 {
+		val[elements[i]] = elements[i+1]
+	}
+	return val, nil
+}
+
+// streamNodeCursor walks the elements of the listpack of a stream node
+type streamNodeCursor struct {
+	elements []string
+	p        int
+	err      error
+}
+
+func (c *streamNodeCursor) next() string {
+	if c.p >= len(c.elements) {
+		c.err = errInvalidStreamNode
+		return ""
+	}
+	c.p++
+	return c.elements[c.p-1]
+}
+
+func (c *streamNodeCursor) nextInt() int64 {
+	v, err := strconv.ParseInt(c.next(), 10, 64)
+	if err != nil && c.err == nil {
+		c.err = errInvalidStreamNode
+	}
+	return v
+}
+
+// streamNodeEntries returns the entries of a stream node, as encoded by
+// streamNode, without the entries marked as deleted
+func streamNodeEntries(master StreamID, elements []string) ([]StreamEntry, error) {
+	c := streamNodeCursor{elements: elements}
+	count := c.nextInt() + c.nextInt()
+	nMasterFields := c.nextInt()
+	var masterFields []string
+	for i := int64(0); i < nMasterFields && c.err == nil; i++ {
+		masterFields = append(masterFields, c.next())
+	}
+	c.next() // end of the master entry
+
+	var entries []StreamEntry
+	for i := int64(0); i < count && c.err == nil; i++ {
+		flags := c.nextInt()
+		entry := StreamEntry{ID: StreamID{Ms: master.Ms + uint64(c.nextInt()), Seq: master.Seq + uint64(c.nextInt())}}
+		if flags&2 != 0 {
+			for _, f := range masterFields {
+				entry.Fields = append(entry.Fields, f, c.next())
+			}
+		} else {
+			nFields := c.nextInt()
+			for j := int64(0); j < 2*nFields && c.err == nil; j++ {
+				entry.Fields = append(entry.Fields, c.next())
+			}
+		}
+		c.next() // lp-count
+
+		if flags&1 == 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, c.err
+}
+
+// readStream reads a stream of type t. Streams of Redis 7.0 and later
+// hold the number of entries read by each group, which is kept, and
+// other counters and times which are not.
+func (r *Reader) readStream(t byte) *Stream {
+	s := &Stream{}
+	nNodes := r.readLen()
+	for i := uint64(0); i < nNodes && r.err == nil; i++ {
+		nodeKey := r.readString()
+		if len(nodeKey) != 16 {
+			r.fail(errInvalidStreamNode)
+			break
+		}
+		master := StreamID{Ms: binary.BigEndian.Uint64([]byte(nodeKey)), Seq: binary.BigEndian.Uint64([]byte(nodeKey[8:]))}
+		elements := r.readEncoded(parseListpack)
+		if r.err != nil {
+			break
+		}
+		entries, err := streamNodeEntries(master, elements)
+		r.fail(err)
+		s.Entries = append(s.Entries, entries...)
+	}
+
+	r.readLen() // number of entries
+	s.LastID = StreamID{Ms: r.readLen(), Seq: r.readLen()}
+	if t != typeStreamListpacks {
+		// first ID, maximum deleted ID and number of entries added
+		for i := 0; i < 5; i++ {
+			r.readLen()
+		}
+	}
+
+	nGroups := r.readLen()
+	for i := uint64(0); i < nGroups && r.err == nil; i++ {
+		g := StreamGroup{Name: r.readString(), LastID: StreamID{Ms: r.readLen(), Seq: r.readLen()}, EntriesRead: -1}
+		if t != typeStreamListpacks {
+			g.EntriesRead = int64(r.readLen())
+		}
+
+		nPending := r.readLen()
+		for j := uint64(0); j < nPending && r.err == nil; j++ {
+			g.Pending = append(g.Pending, StreamPendingEntry{ID: r.readID(), DeliveryTime: r.readMillis(), DeliveryCount: r.readLen()})
+		}
+
+		nConsumers := r.readLen()
+		for j := uint64(0); j < nConsumers && r.err == nil; j++ {
+			c := StreamConsumer{Name: r.readString(), SeenTime: r.readMillis()}
+			if t == typeStreamListpacks3 {
+				r.readMillis() // time the consumer was last active at
+			}
+			nPending := r.readLen()
+			for k := uint64(0); k < nPending && r.err == nil; k++ {
+				c.Pending = append(c.Pending, r.readID())
+			}
+			g.Consumers = append(g.Consumers, c)
+		}
+		s.Groups = append(s.Groups, g)
+	}
+
+	return s
+}
+
+// readValue reads the value of k, of type t
+func (r *Reader) readValue(t byte, k *Key) {
+	switch t {
+	case typeString:
+		k.Type, k.Value = "string", r.readString()
+
+	case typeList, typeSet:
+		n := r.readLen()
+		val := make([]string, 0, min(n, 1024))
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			val = append(val, r.readString())
+		}
+		k.Type, k.Value = "list", val
+		if t == typeSet {
+			k.Type = "set"
+		}
+
+	case typeZSet, typeZSet2:
+		n := r.readLen()
+		val := make([]ZMember, 0, min(n, 1024))
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			m := ZMember{Member: r.readString()}
+			if t == typeZSet2 {
+				m.Score = math.Float64frombits(binary.LittleEndian.Uint64(r.readFixed(8)))
+			} else {
+				m.Score = r.readScore()
+			}
+			val = append(val, m)
+		}
+		k.Type, k.Value = "zset", val
+
+	case typeHash:
+		n := r.readLen()
+		val := make(map[string]string, min(n, 1024))
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			f := r.readString()
+			val[f] = r.readString()
+		}
+		k.Type, k.Value = "hash", val
+
+	case typeModule2:
+		r.readLen() // ID of the module
+		r.skipModuleValue()
+		k.Type = "module"
+
+	case typeHashZipmap:
+		zm := r.readString()
+		if r.err == nil {
+			val, err := parseZipmap([]byte(zm))
+			r.fail(err)
+			k.Value = val
+		}
+		k.Type = "hash"
+
+	case typeListZiplist:
+		k.Type, k.Value = "list", r.readEncoded(parseZiplist)
+
+	case typeSetIntset:
+		k.Type, k.Value = "set", r.readEncoded(parseIntset)
+
+	case typeSetListpack:
+		k.Type, k.Value = "set", r.readEncoded(parseListpack)
+
+	case typeZSetZiplist, typeZSetListpack:
+		parse := parseZiplist
+		if t == typeZSetListpack {
+			parse = parseListpack
+		}
+		elements := r.readEncoded(parse)
+		val, err := zMembers(elements)
+		r.fail(err)
+		k.Type, k.Value = "zset", val
+
+	case typeHashZiplist, typeHashListpack:
+		parse := parseZiplist
+		if t == typeHashListpack {
+			parse = parseListpack
+		}
+		elements := r.readEncoded(parse)
+		val, err := hashFields(elements)
+		r.fail(err)
+		k.Type, k.Value = "hash", val
+
+	case typeListQuicklist:
+		var val []string
+		n := r.readLen()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			val = append(val, r.readEncoded(parseZiplist)...)
+		}
+		k.Type, k.Value = "list", val
+
+	case typeListQuicklist2:
+		var val []string
+		n := r.readLen()
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			// Large elements are stored in nodes of their own, as is
+			switch container := r.readLen(); container {
+			case 1:
+				val = append(val, r.readString())
+			case 2:
+				val = append(val, r.readEncoded(parseListpack)...)
+			default:
+				r.fail(fmt.Errorf("invalid quicklist container %d", container))
+			}
+		}
+		k.Type, k.Value = "list", val
+
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		k.Type, k.Value = "stream", r.readStream(t)
+
+	default:
+		r.fail(fmt.Errorf("unsupported type %d", t))
+	}
+}
+
+// Next returns the next key of the file, and io.EOF once all keys were
+// read and the checksum of the file was verified
+func (r *Reader) Next() (*Key, error) {
+	var expireAt int64
+	for r.err == nil {
+		switch op := r.readByte(); op {
+		case opSlotInfo:
+			// slot, and number of keys and keys with an expiration in it
+			r.readLen()
+			r.readLen()
+			r.readLen()
+		case opFunction2:
+			r.readString()
+		case opFunctionPreGA:
+			r.fail(errors.New("functions of Redis 7.0 release candidates are not supported"))
+		case opModuleAux:
+			r.readLen() // ID of the module
+			r.readLen() // when the data is loaded
+			r.readLen()
+			r.skipModuleValue()
+		case opIdle:
+			r.readLen()
+		case opFreq:
+			r.readByte()
+		case opAux:
+			r.readString()
+			r.readString()
+		case opResizeDB:
+			r.readLen()
+			r.readLen()
+		case opExpireTimeMs:
+			expireAt = r.readMillis()
+		case opExpireTime:
+			expireAt = int64(binary.LittleEndian.Uint32(r.readFixed(4))) * 1000
+		case opSelectDB:
+			r.db = r.readLen()
+
+		case opEOF:
+			// Files are written without checksum before version 5, and
+			// with a checksum of 0 if checksums are disabled
+			sum := r.crc
+			if r.version >= 5 {
+				if expected := binary.LittleEndian.Uint64(r.readFixed(8)); r.err == nil && expected != 0 && expected != sum {
+					r.fail(errInvalidChecksum)
+				}
+			}
+			r.fail(io.EOF)
+
+		default:
+			k := &Key{Db: r.db, ExpireAt: expireAt}
+			k.Name = r.readString()
+			r.readValue(op, k)
+			if r.err == nil {
+				return k, nil
+			}
+		}
+	}
+
+	return nil, r.err
+}
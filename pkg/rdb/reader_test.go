@@ -0,0 +1,359 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testZiplist builds a ziplist of encoded entries
+func testZiplist(entries ...[]byte) string {
+	var b []byte
+	prev := 0
+	for _, e := range entries {
+		b = append(b, byte(prev))
+		b = append(b, e...)
+		prev = 1 + len(e)
+	}
+	header := make([]byte, 10)
+	binary.LittleEndian.PutUint32(header, uint32(10+len(b)+1))
+	binary.LittleEndian.PutUint16(header[8:], uint16(len(entries)))
+	return string(append(append(header, b...), 0xFF))
+}
+
+// testBuildListpack builds a listpack of strings
+func testBuildListpack(elements ...string) string {
+	var lp listpack
+	for _, e := range elements {
+		lp.appendString(e)
+	}
+	return string(lp.bytes())
+}
+
+// readKeys reads back the keys of an RDB file, by name
+func readKeys(t *testing.T, b []byte) map[string]*Key {
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := map[string]*Key{}
+	for {
+		k, err := r.Next()
+		if err == io.EOF {
+			return keys
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[k.Name] = k
+	}
+}
+
+func TestParseListpack(t *testing.T) {
+	var lp listpack
+	lp.appendInt(-4096)
+	lp.appendString("field")
+	lp.appendInt(1 << 40)
+	expected := []string{"-4096", "field", "1099511627776"}
+	if elements, err := parseListpack(lp.bytes()); err != nil || !reflect.DeepEqual(elements, expected) {
+		t.Errorf("expected %q, got %q, %v", expected, elements, err)
+	}
+}
+
+func TestReaderEncodings(t *testing.T) {
+	int64Entry := []byte{0xE0, 0, 0, 0, 0, 0, 0, 0, 0x80}
+	longEntry := append([]byte{0x40, 100}, strings.Repeat("l", 100)...)
+
+	var packed listpack
+	packed.appendString("b")
+	packed.appendInt(-3)
+
+	for i, testCase := range []struct {
+		write    func(e *Encoder)
+		expected *Key
+	}{
+		{
+			func(e *Encoder) {
+				e.writeKey(typeListZiplist, "ziplist", 0)
+				e.writeString(testZiplist([]byte{0x01, 'a'}, []byte{0xFD}, []byte{0xC0, 0xE8, 0x03}, []byte{0xF0, 0xFE, 0xFF, 0xFF}, []byte{0xFE, 0xFB}, []byte{0xD0, 0, 0, 0, 0x80}, int64Entry, longEntry))
+			},
+			&Key{Name: "ziplist", Type: "list", Value: []string{"a", "12", "1000", "-2", "-5", "-2147483648", "-9223372036854775808", strings.Repeat("l", 100)}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeSetIntset, "intset", 0)
+				e.writeString(string([]byte{2, 0, 0, 0, 2, 0, 0, 0, 0xFF, 0xFF, 1, 0}))
+			},
+			&Key{Name: "intset", Type: "set", Value: []string{"-1", "1"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeHashZipmap, "zipmap", 0)
+				e.writeString(string([]byte{1, 1, 'f', 2, 1, 'v', 'v', 0, 0xFF}))
+			},
+			&Key{Name: "zipmap", Type: "hash", Value: map[string]string{"f": "vv"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeHashZiplist, "hash", 0)
+				e.writeString(testZiplist([]byte{0x01, 'f'}, []byte{0x01, 'v'}))
+			},
+			&Key{Name: "hash", Type: "hash", Value: map[string]string{"f": "v"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeZSetZiplist, "zset", 0)
+				e.writeString(testZiplist([]byte{0x01, 'a'}, []byte{0x03, '1', '.', '5'}, []byte{0x01, 'b'}, []byte{0xF3}))
+			},
+			&Key{Name: "zset", Type: "zset", Value: []ZMember{{"a", 1.5}, {"b", 2}}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeHashListpack, "hash", 0)
+				e.writeString(testBuildListpack("f", "v"))
+			},
+			&Key{Name: "hash", Type: "hash", Value: map[string]string{"f": "v"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeZSetListpack, "zset", 0)
+				e.writeString(testBuildListpack("a", "-inf"))
+			},
+			&Key{Name: "zset", Type: "zset", Value: []ZMember{{"a", math.Inf(-1)}}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeSetListpack, "set", 0)
+				e.writeString(testBuildListpack("a", "b"))
+			},
+			&Key{Name: "set", Type: "set", Value: []string{"a", "b"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeListQuicklist, "quicklist", 0)
+				e.writeLen(2)
+				e.writeString(testZiplist([]byte{0x01, 'a'}))
+				e.writeString(testZiplist([]byte{0x01, 'b'}, []byte{0xF1}))
+			},
+			&Key{Name: "quicklist", Type: "list", Value: []string{"a", "b", "0"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeListQuicklist2, "quicklist2", 0)
+				e.writeLen(2)
+				e.writeLen(1) // plain node
+				e.writeString("a")
+				e.writeLen(2) // packed node
+				e.writeString(string(packed.bytes()))
+			},
+			&Key{Name: "quicklist2", Type: "list", Value: []string{"a", "b", "-3"}},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeZSet, "oldzset", 0)
+				e.writeLen(2)
+				e.writeString("a")
+				e.write([]byte{3, '1', '.', '5'})
+				e.writeString("b")
+				e.write([]byte{254})
+			},
+			&Key{Name: "oldzset", Type: "zset", Value: []ZMember{{"a", 1.5}, {"b", math.Inf(1)}}},
+		},
+		{
+			func(e *Encoder) {
+				// The key is an integer, the value a compressed string
+				e.write([]byte{typeString, 0xC1, 0xD2, 0x04})
+				e.write([]byte{0xC3, 5, 20, 0x00, 'a', 0xE0, 0x0A, 0x00})
+			},
+			&Key{Name: "1234", Type: "string", Value: strings.Repeat("a", 20)},
+		},
+		{
+			func(e *Encoder) {
+				e.writeKey(typeModule2, "module", 0)
+				e.writeLen(12345)
+				e.writeLen(2) // unsigned integer
+				e.writeLen(10)
+				e.writeLen(5) // string
+				e.writeString("value")
+				e.writeLen(4) // double
+				e.write(make([]byte, 8))
+				e.writeLen(0)
+			},
+			&Key{Name: "module", Type: "module"},
+		},
+	} {
+		var b bytes.Buffer
+		w, _ := NewWriter(&b)
+		w.SelectDB(0, 1, 0)
+		testCase.write(w.Encoder)
+		w.Close()
+
+		keys := readKeys(t, b.Bytes())
+		if k := keys[testCase.expected.Name]; len(keys) != 1 || !reflect.DeepEqual(k, testCase.expected) {
+			t.Errorf("test %d: expected %+v, got %+v", i, testCase.expected, k)
+		}
+	}
+}
+
+func TestReaderStream(t *testing.T) {
+	// A node with a deleted entry, followed by an entry with the same
+	// fields as the master entry
+	var lp listpack
+	for _, v := range []int64{1, 1, 1} {
+		lp.appendInt(v)
+	}
+	lp.appendString("f")
+	lp.appendInt(0)
+	for _, entry := range []struct {
+		flags  int64
+		msDiff int64
+		value  string
+	}{{3, 0, "v1"}, {2, 5, "v2"}} {
+		lp.appendInt(entry.flags)
+		lp.appendInt(entry.msDiff)
+		lp.appendInt(0)
+		lp.appendString(entry.value)
+		lp.appendInt(4)
+	}
+
+	var b bytes.Buffer
+	w, _ := NewWriter(&b)
+	w.writeKey(typeStreamListpacks3, "stream", 0)
+	w.writeLen(1)
+	w.writeString(string(StreamID{Ms: 10, Seq: 0}.bytes()))
+	w.writeString(string(lp.bytes()))
+	w.writeLen(1)
+	for _, v := range []uint64{15, 0, 10, 0, 10, 0, 2} {
+		// last ID, first ID, maximum deleted ID, entries added
+		w.writeLen(v)
+	}
+	w.writeLen(1)
+	w.writeString("group")
+	w.writeLen(15)
+	w.writeLen(0)
+	w.writeLen(2) // entries read
+	w.writeLen(1)
+	w.write(StreamID{Ms: 15}.bytes())
+	w.writeMillis(1700000000000)
+	w.writeLen(3)
+	w.writeLen(1)
+	w.writeString("consumer")
+	w.writeMillis(1700000000000)
+	w.writeMillis(1700000000000)
+	w.writeLen(1)
+	w.write(StreamID{Ms: 15}.bytes())
+	w.Close()
+
+	expected := &Stream{
+		Entries: []StreamEntry{{ID: StreamID{Ms: 15}, Fields: []string{"f", "v2"}}},
+		LastID:  StreamID{Ms: 15},
+		Groups: []StreamGroup{{
+			Name:        "group",
+			LastID:      StreamID{Ms: 15},
+			EntriesRead: 2,
+			Pending:     []StreamPendingEntry{{ID: StreamID{Ms: 15}, DeliveryTime: 1700000000000, DeliveryCount: 3}},
+			Consumers:   []StreamConsumer{{Name: "consumer", SeenTime: 1700000000000, Pending: []StreamID{{Ms: 15}}}},
+		}},
+	}
+	if k := readKeys(t, b.Bytes())["stream"]; k == nil || !reflect.DeepEqual(k.Value, expected) {
+		t.Errorf("expected %+v, got %+v", expected, k)
+	}
+}
+
+func TestReaderOpcodes(t *testing.T) {
+	var b bytes.Buffer
+	w, _ := NewWriter(&b)
+	w.write([]byte{opAux})
+	w.writeString("redis-ver")
+	w.writeString("7.2.0")
+	w.write([]byte{opModuleAux})
+	w.writeLen(12345)
+	w.writeLen(2)
+	w.writeLen(0)
+	w.writeLen(0)
+	w.write([]byte{opFunction2})
+	w.writeString("#!lua name=lib")
+	w.SelectDB(3, 1, 1)
+	w.write([]byte{opSlotInfo, 1, 1, 1})
+	w.write([]byte{opExpireTime, 0x00, 0xE1, 0xF5, 0x05})
+	w.write([]byte{opIdle, 10})
+	w.write([]byte{opFreq, 5})
+	w.String("expiring", "value", 0)
+	w.String("persistent", "value", 0)
+	w.Close()
+
+	keys := readKeys(t, b.Bytes())
+	if k := keys["expiring"]; k == nil || k.Db != 3 || k.ExpireAt != 100000000000 {
+		t.Errorf("unexpected key %+v", k)
+	}
+	if k := keys["persistent"]; k == nil || k.ExpireAt != 0 {
+		t.Errorf("unexpected key %+v", k)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	var valid bytes.Buffer
+	w, _ := NewWriter(&valid)
+	w.SelectDB(0, 1, 0)
+	w.String("key", "value", 0)
+	w.Close()
+	file := valid.Bytes()
+
+	noChecksum := append(append([]byte{}, file[:len(file)-8]...), make([]byte, 8)...)
+	oldVersion := append([]byte("REDIS0003"), file[9:len(file)-8]...)
+	corrupted := append([]byte{}, file...)
+	corrupted[len(corrupted)-12] = 'x'
+
+	for i, testCase := range []struct {
+		file  []byte
+		valid bool
+	}{
+		{file, true},
+		{noChecksum, true},
+		{oldVersion, true},
+		{corrupted, false},
+		{file[:len(file)-3], false},
+		{file[:len(file)-12], false},
+		{append(append([]byte{}, file[:len(file)-9]...), 0xEE), false},
+	} {
+		r, err := NewReader(bytes.NewReader(testCase.file))
+		if err != nil {
+			t.Fatalf("test %d: %s", i, err)
+		}
+		for err == nil {
+			_, err = r.Next()
+		}
+		if (err == io.EOF) != testCase.valid {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+	}
+
+	if _, err := NewReader(strings.NewReader("*1\r\n$4\r\nPING\r\n")); err == nil {
+		t.Errorf("expected an error reading a file that is not an RDB file")
+	}
+}
+
+func TestLZFDecompress(t *testing.T) {
+	for i, testCase := range []struct {
+		in       []byte
+		ulen     int
+		expected string
+		valid    bool
+	}{
+		{[]byte{0x02, 'a', 'b', 'c'}, 3, "abc", true},
+		{[]byte{0x01, 'a', 'b', 0x20, 0x01}, 5, "ababa", true},
+		{[]byte{0x00, 'a', 0xE0, 0x0A, 0x00}, 20, strings.Repeat("a", 20), true},
+		{[]byte{0x02, 'a', 'b', 'c'}, 4, "", false},
+		{[]byte{0x05, 'a'}, 6, "", false},
+		{[]byte{0x20, 0x05}, 3, "", false},
+	} {
+		out, err := lzfDecompress(testCase.in, testCase.ulen)
+		if (err == nil) != testCase.valid || string(out) != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q (%v)", i, testCase.expected, out, err)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// streamNodeMaxEntries is the number of entries of a stream stored per
+// listpack, as with the default stream-node-max-entries of Redis
+const streamNodeMaxEntries = 100
+
+// Encoder encodes keys as they are written in RDB files, with their values
+// and expiration times. Once a write failed, all writes fail with the
+// same error.
+type Encoder struct {
+	w   io.Writer
+	err error
+}
+
+// NewEncoder returns an Encoder writing to w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (e *Encoder) write(p []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(p)
+}
+
+// writeLen writes a length, or any unsigned integer, on 1, 2, 5 or 9
+// bytes depending on its size
+func (e *Encoder) writeLen(n uint64) {
+	switch {
+	case n < 1<<6:
+		e.write([]byte{byte(n)})
+	case n < 1<<14:
+		e.write([]byte{0x40 | byte(n>>8), byte(n)})
+	case n <= math.MaxUint32:
+		b := []byte{0x80, 0, 0, 0, 0}
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		e.write(b)
+	default:
+		b := []byte{0x81, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.BigEndian.PutUint64(b[1:], n)
+		e.write(b)
+	}
+}
+
+func (e *Encoder) writeString(s string) {
+	e.writeLen(uint64(len(s)))
+	e.write([]byte(s))
+}
+
+// writeMillis writes a Unix time in milliseconds
+func (e *Encoder) writeMillis(t int64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(t))
+	e.write(b)
+}
+
+// writeKey writes the expiration time of key, if expireAt is not 0, its
+// type and its name
+func (e *Encoder) writeKey(valueType byte, key string, expireAt int64) {
+	if expireAt != 0 {
+		e.write([]byte{opExpireTimeMs})
+		e.writeMillis(expireAt)
+	}
+	e.write([]byte{valueType})
+	e.writeString(key)
+}
+
+// String writes a string key. expireAt is the Unix time in milliseconds
+// the key expires at, 0 if it does not expire.
+func (e *Encoder) String(key string, val string, expireAt int64) error {
+	e.writeKey(typeString, key, expireAt)
+	e.writeString(val)
+	return e.err
+}
+
+// List writes a list key
+func (e *Encoder) List(key string, val []string, expireAt int64) error {
+	e.writeKey(typeList, key, expireAt)
+	e.writeLen(uint64(len(val)))
+	for _, v := range val {
+		e.writeString(v)
+	}
+	return e.err
+}
+
+// Set writes a set key. Members must be unique.
+func (e *Encoder) Set(key string, val []string, expireAt int64) error {
+	e.writeKey(typeSet, key, expireAt)
+	e.writeLen(uint64(len(val)))
+	for _, v := range val {
+		e.writeString(v)
+	}
+	return e.err
+}
+
+// Hash writes a hash key
+func (e *Encoder) Hash(key string, val map[string]string, expireAt int64) error {
+	e.writeKey(typeHash, key, expireAt)
+	e.writeLen(uint64(len(val)))
+	for f, v := range val {
+		e.writeString(f)
+		e.writeString(v)
+	}
+	return e.err
+}
+
+// ZSet writes a sorted set key. Members must be unique.
+func (e *Encoder) ZSet(key string, val []ZMember, expireAt int64) error {
+	e.writeKey(typeZSet2, key, expireAt)
+	e.writeLen(uint64(len(val)))
+	b := make([]byte, 8)
+	for _, m := range val {
+		e.writeString(m.Member)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(m.Score))
+		e.write(b)
+	}
+	return e.err
+}
+
+// streamNode encodes entries in a listpack, as Redis stores them: a master
+// entry holding the number of entries and the fields of the first one,
+// followed by each entry, its ID relative to the first one. Entries with
+// the same fields as the first one only hold their values.
+func streamNode(entries []StreamEntry) []byte {
+	var lp listpack
+	master := entries[0]
+	masterFields := make([]string, 0, len(master.Fields)/2)
+	for i := 0; i+1 < len(master.Fields); i += 2 {
+		masterFields = append(masterFields, master.Fields[i])
+	}
+
+	lp.appendInt(int64(len(entries)))
+	lp.appendInt(0) // deleted entries
+	lp.appendInt(int64(len(masterFields)))
+	for _, f := range masterFields {
+		lp.appendString(f)
+	}
+	lp.appendInt(0) // end of the master entry
+
+	for _, entry := range entries {
+		nFields := len(entry.Fields) / 2
+		sameFields := nFields == len(masterFields)
+		for i := 0; sameFields && i < nFields; i++ {
+			sameFields = entry.Fields[2*i] == masterFields[i]
+		}
+
+		// flags, ms-diff, seq-diff, then fields and values, and lp-count
+		lpCount := int64(nFields + 3)
+		if sameFields {
+			lp.appendInt(2)
+		} else {
+			lp.appendInt(0)
+			lpCount += int64(nFields + 1)
+		}
+		lp.appendInt(int64(entry.ID.Ms - master.ID.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.ID.Seq))
+		if !sameFields {
+			lp.appendInt(int64(nFields))
+		}
+		for i := 0; i < nFields; i++ {
+			if !sameFields {
+				lp.appendString(entry.Fields[2*i])
+			}
+			lp.appendString(entry.Fields[2*i+1])
+		}
+		lp.appendInt(lpCount)
+	}
+
+	return lp.bytes()
+}
+
+// Stream writes a stream key. Entries must be sorted by ID, and have an
+// even number of fields.
+func (e *Encoder) Stream(key string, val *Stream, expireAt int64) error {
+	for _, entry := range val.Entries {
+		if len(entry.Fields)%2 != 0 {
+			return fmt.Errorf("stream %s: entry %s has an odd number of fields", key, entry.ID)
+		}
+	}
+
+	e.writeKey(typeStreamListpacks, key, expireAt)
+	nNodes := (len(val.Entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	e.writeLen(uint64(nNodes))
+	for i := 0; i < len(val.Entries); i += streamNodeMaxEntries {
+		entries := val.Entries[i:min(i+streamNodeMaxEntries, len(val.Entries))]
+		e.writeString(string(entries[0].ID.bytes()))
+		e.writeString(string(streamNode(entries)))
+	}
+
+	e.writeLen(uint64(len(val.Entries)))
+	e.writeLen(val.LastID.Ms)
+	e.writeLen(val.LastID.Seq)
+
+	e.writeLen(uint64(len(val.Groups)))
+	for _, g := range val.Groups {
+		e.writeString(g.Name)
+		e.writeLen(g.LastID.Ms)
+		e.writeLen(g.LastID.Seq)
+
+		e.writeLen(uint64(len(g.Pending)))
+		for _, p := range g.Pending {
+			e.write(p.ID.bytes())
+			e.writeMillis(p.DeliveryTime)
+			e.writeLen(p.DeliveryCount)
+		}
+
+		e.writeLen(uint64(len(g.Consumers)))
+		for _, c := range g.Consumers {
+			e.writeString(c.Name)
+			e.writeMillis(c.SeenTime)
+			e.writeLen(uint64(len(c.Pending)))
+			for _, id := range c.Pending {
+				e.write(id.bytes())
+			}
+		}
+	}
+
+	return e.err
+}
+
+// checksumWriter computes the checksum of the data written through it
+type checksumWriter struct {
+	w   io.Writer
+	crc uint64
+}
+
+func (c *checksumWriter) Write(p []byte) (int, error) {
+	c.crc = crc(c.crc, p)
+	return c.w.Write(p)
+}
+
+// Writer writes an RDB file: a header, the keys of each database, and a
+// checksum once it is closed. Closing a Writer does not close the
+// underlying writer.
+type Writer struct {
+	*Encoder
+	cw *checksumWriter
+}
+
+// NewWriter writes the header of an RDB file to w
+func NewWriter(w io.Writer) (*Writer, error) {
+	cw := &checksumWriter{w: w}
+	wr := &Writer{Encoder: NewEncoder(cw), cw: cw}
+	wr.write([]byte(fmt.Sprintf("REDIS%04d", Version)))
+	return wr, wr.err
+}
+
+// SelectDB starts the keys of the database db. size and expires are the
+// number of keys of the database, and of keys with an expiration: Redis
+// uses them to size its tables before loading the keys.
+func (w *Writer) SelectDB(db uint64, size uint64, expires uint64) error {
+	w.write([]byte{opSelectDB})
+	w.writeLen(db)
+	w.write([]byte{opResizeDB})
+	w.writeLen(size)
+	w.writeLen(expires)
+	return w.err
+}
+
+// ReadFrom copies keys encoded by an Encoder from r
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	var n int64
+	n, w.err = io.Copy(w.cw, r)
+	return n, w.err
+}
+
+// Close ends the file, and writes its checksum
+func (w *Writer) Close() error {
+	w.write([]byte{opEOF})
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, w.cw.crc)
+	w.write(b)
+	return w.err
+}
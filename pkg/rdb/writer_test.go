@@ -0,0 +1,373 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// testDecoder parses back the RDB files written by Writer
+type testDecoder struct {
+	b   []byte
+	pos int
+}
+
+func (d *testDecoder) read(n int) []byte {
+	b := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return b
+}
+
+func (d *testDecoder) readLen() uint64 {
+	b := d.read(1)[0]
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F)
+	case 1:
+		return uint64(b&0x3F)<<8 | uint64(d.read(1)[0])
+	}
+	if b == 0x80 {
+		return uint64(binary.BigEndian.Uint32(d.read(4)))
+	}
+	return binary.BigEndian.Uint64(d.read(8))
+}
+
+func (d *testDecoder) readString() string {
+	return string(d.read(int(d.readLen())))
+}
+
+func (d *testDecoder) readMillis() int64 {
+	return int64(binary.LittleEndian.Uint64(d.read(8)))
+}
+
+func (d *testDecoder) readID() StreamID {
+	b := d.read(16)
+	return StreamID{Ms: binary.BigEndian.Uint64(b), Seq: binary.BigEndian.Uint64(b[8:])}
+}
+
+// testListpack returns the elements of a listpack, integers formatted
+// as strings
+func testListpack(t *testing.T, lp []byte) []string {
+	if int(binary.LittleEndian.Uint32(lp)) != len(lp) || lp[len(lp)-1] != 0xFF {
+		t.Fatalf("invalid listpack header or terminator")
+	}
+	var elements []string
+	for p := 6; lp[p] != 0xFF; {
+		start := p
+		b := lp[p]
+		var v string
+		switch {
+		case b&0x80 == 0:
+			v, p = strconv.Itoa(int(b)), p+1
+		case b&0xC0 == 0x80:
+			l := int(b & 0x3F)
+			v, p = string(lp[p+1:p+1+l]), p+1+l
+		case b&0xE0 == 0xC0:
+			u := int64(b&0x1F)<<8 | int64(lp[p+1])
+			if u >= 1<<12 {
+				u -= 1 << 13
+			}
+			v, p = strconv.FormatInt(u, 10), p+2
+		case b&0xF0 == 0xE0:
+			l := int(b&0x0F)<<8 | int(lp[p+1])
+			v, p = string(lp[p+2:p+2+l]), p+2+l
+		case b == 0xF0:
+			l := int(binary.LittleEndian.Uint32(lp[p+1:]))
+			v, p = string(lp[p+5:p+5+l]), p+5+l
+		default:
+			size := map[byte]int{0xF1: 2, 0xF2: 3, 0xF3: 4, 0xF4: 8}[b]
+			var u uint64
+			for i := size - 1; i >= 0; i-- {
+				u = u<<8 | uint64(lp[p+1+i])
+			}
+			shift := 64 - 8*size
+			v, p = strconv.FormatInt(int64(u<<shift)>>shift, 10), p+1+size
+		}
+
+		// The length of the element, encoded backwards
+		switch l := p - start; {
+		case l <= 127:
+			p++
+		case l < 16383:
+			p += 2
+		default:
+			p += 3
+		}
+		elements = append(elements, v)
+	}
+	return elements
+}
+
+func (d *testDecoder) readStream(t *testing.T) Stream {
+	var s Stream
+	nNodes := d.readLen()
+	for i := uint64(0); i < nNodes; i++ {
+		master := (&testDecoder{b: []byte(d.readString())}).readID()
+		lp := testListpack(t, []byte(d.readString()))
+		atoi := func(s string) int { n, _ := strconv.Atoi(s); return n }
+
+		count, nMasterFields := atoi(lp[0]), atoi(lp[2])
+		masterFields := lp[3 : 3+nMasterFields]
+		p := 3 + nMasterFields + 1
+		for j := 0; j < count; j++ {
+			flags := atoi(lp[p])
+			entry := StreamEntry{ID: StreamID{Ms: master.Ms + uint64(atoi(lp[p+1])), Seq: master.Seq + uint64(atoi(lp[p+2]))}}
+			p += 3
+			if flags == 2 {
+				for _, f := range masterFields {
+					entry.Fields = append(entry.Fields, f, lp[p])
+					p++
+				}
+			} else {
+				n := atoi(lp[p])
+				entry.Fields = append(entry.Fields, lp[p+1:p+1+2*n]...)
+				p += 1 + 2*n
+			}
+			p++ // lp-count
+			s.Entries = append(s.Entries, entry)
+		}
+	}
+
+	d.readLen()
+	s.LastID = StreamID{Ms: d.readLen(), Seq: d.readLen()}
+	nGroups := d.readLen()
+	for i := uint64(0); i < nGroups; i++ {
+		g := StreamGroup{Name: d.readString(), LastID: StreamID{Ms: d.readLen(), Seq: d.readLen()}}
+		nPending := d.readLen()
+		for j := uint64(0); j < nPending; j++ {
+			g.Pending = append(g.Pending, StreamPendingEntry{ID: d.readID(), DeliveryTime: d.readMillis(), DeliveryCount: d.readLen()})
+		}
+		nConsumers := d.readLen()
+		for j := uint64(0); j < nConsumers; j++ {
+			c := StreamConsumer{Name: d.readString(), SeenTime: d.readMillis()}
+			nPending := d.readLen()
+			for k := uint64(0); k < nPending; k++ {
+				c.Pending = append(c.Pending, d.readID())
+			}
+			g.Consumers = append(g.Consumers, c)
+		}
+		s.Groups = append(s.Groups, g)
+	}
+	return s
+}
+
+type testKey struct {
+	db       uint64
+	expireAt int64
+	value    interface{}
+}
+
+// parse returns the keys of the file, and checks its checksum
+func (d *testDecoder) parse(t *testing.T) map[string]testKey {
+	if header := string(d.read(9)); header != "REDIS0009" {
+		t.Fatalf("invalid header %s", header)
+	}
+
+	keys := map[string]testKey{}
+	var db uint64
+	var expireAt int64
+	for {
+		op := d.read(1)[0]
+		switch op {
+		case opSelectDB:
+			db = d.readLen()
+			continue
+		case opResizeDB:
+			d.readLen()
+			d.readLen()
+			continue
+		case opExpireTimeMs:
+			expireAt = d.readMillis()
+			continue
+		case opEOF:
+			if sum := binary.LittleEndian.Uint64(d.read(8)); sum != crc(0, d.b[:d.pos-8]) {
+				t.Errorf("invalid checksum %x", sum)
+			}
+			if d.pos != len(d.b) {
+				t.Errorf("%d bytes after the checksum", len(d.b)-d.pos)
+			}
+			return keys
+		}
+
+		key := d.readString()
+		k := testKey{db: db, expireAt: expireAt}
+		expireAt = 0
+		switch op {
+		case typeString:
+			k.value = d.readString()
+		case typeList, typeSet:
+			var val []string
+			for n := d.readLen(); n > 0; n-- {
+				val = append(val, d.readString())
+			}
+			k.value = val
+		case typeHash:
+			val := map[string]string{}
+			for n := d.readLen(); n > 0; n-- {
+				f := d.readString()
+				val[f] = d.readString()
+			}
+			k.value = val
+		case typeZSet2:
+			var val []ZMember
+			for n := d.readLen(); n > 0; n-- {
+				m := d.readString()
+				val = append(val, ZMember{Member: m, Score: math.Float64frombits(binary.LittleEndian.Uint64(d.read(8)))})
+			}
+			k.value = val
+		case typeStreamListpacks:
+			k.value = d.readStream(t)
+		default:
+			t.Fatalf("unexpected type %d", op)
+		}
+		keys[key] = k
+	}
+}
+
+func TestCRC(t *testing.T) {
+	if sum := crc(0, []byte("123456789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected checksum %x", sum)
+	}
+	if sum := crc(crc(0, []byte("1234")), []byte("56789")); sum != 0xe9c6d914c4b8d9ca {
+		t.Errorf("unexpected checksum %x, updated in two steps", sum)
+	}
+}
+
+func TestListpack(t *testing.T) {
+	long := string(bytes.Repeat([]byte("a"), 5000))
+	var lp listpack
+	ints := []int64{0, 127, 128, -1, -4096, 4095, 4096, -32768, 32767, 1 << 20, -1 << 23, 1 << 30, math.MinInt32, 1 << 40, math.MinInt64}
+	var expected []string
+	for _, v := range ints {
+		lp.appendInt(v)
+		expected = append(expected, strconv.FormatInt(v, 10))
+	}
+	for _, s := range []string{"", "field", string(bytes.Repeat([]byte("b"), 100)), long} {
+		lp.appendString(s)
+		expected = append(expected, s)
+	}
+
+	b := lp.bytes()
+	if n := binary.LittleEndian.Uint16(b[4:]); int(n) != len(expected) {
+		t.Errorf("expected %d elements, header has %d", len(expected), n)
+	}
+	if elements := testListpack(t, b); !reflect.DeepEqual(elements, expected) {
+		t.Errorf("expected %q, got %q", expected, elements)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var entries []StreamEntry
+	for i := 0; i < 150; i++ {
+		fields := []string{"name", fmt.Sprint("entry", i), "n", fmt.Sprint(i)}
+		if i%7 == 0 {
+			fields = []string{"other", "1", "other", "2"}
+		}
+		entries = append(entries, StreamEntry{ID: StreamID{Ms: 1700000000000 + uint64(i/3), Seq: uint64(i % 3)}, Fields: fields})
+	}
+	stream := Stream{
+		Entries: entries,
+		LastID:  StreamID{Ms: 1700000000100, Seq: 0},
+		Groups: []StreamGroup{
+			{
+				Name:      "group1",
+				LastID:    entries[10].ID,
+				Pending:   []StreamPendingEntry{{ID: entries[9].ID, DeliveryTime: 1700000001000, DeliveryCount: 2}, {ID: entries[10].ID, DeliveryTime: 1700000002000, DeliveryCount: 1}},
+				Consumers: []StreamConsumer{{Name: "c1", SeenTime: 1700000002000, Pending: []StreamID{entries[9].ID, entries[10].ID}}},
+			},
+		},
+	}
+	long := string(bytes.Repeat([]byte("x"), 20000))
+
+	var b bytes.Buffer
+	w, err := NewWriter(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.SelectDB(0, 5, 1)
+	w.String("string", "value", 0)
+	w.String("expiring", long, 1800000000000)
+	w.List("list", []string{"a", "b", "a"}, 0)
+	w.Set("set", []string{"a", "b"}, 0)
+	w.Hash("hash", map[string]string{"f1": "v1", "f2": ""}, 0)
+	w.SelectDB(12, 2, 0)
+	w.ZSet("zset", []ZMember{{"a", 1.5}, {"b", math.Inf(-1)}}, 0)
+	w.Stream("stream", &stream, 0)
+
+	// Keys encoded separately
+	var keys bytes.Buffer
+	NewEncoder(&keys).String("copied", "value", 0)
+	w.SelectDB(300, 1, 0)
+	w.ReadFrom(&keys)
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]testKey{
+		"string":   {0, 0, "value"},
+		"expiring": {0, 1800000000000, long},
+		"list":     {0, 0, []string{"a", "b", "a"}},
+		"set":      {0, 0, []string{"a", "b"}},
+		"hash":     {0, 0, map[string]string{"f1": "v1", "f2": ""}},
+		"zset":     {12, 0, []ZMember{{"a", 1.5}, {"b", math.Inf(-1)}}},
+		"stream":   {12, 0, stream},
+		"copied":   {300, 0, "value"},
+	}
+	d := testDecoder{b: b.Bytes()}
+	if keys := d.parse(t); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %+v, got %+v", expected, keys)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	stream := Stream{
+		Entries: []StreamEntry{{ID: StreamID{Ms: 1, Seq: 1}, Fields: []string{"a", "1"}}},
+		LastID:  StreamID{Ms: 1, Seq: 1},
+		Groups: []StreamGroup{
+			{
+				Name:        "group1",
+				LastID:      StreamID{Ms: 1, Seq: 1},
+				EntriesRead: -1,
+				Pending:     []StreamPendingEntry{{ID: StreamID{Ms: 1, Seq: 1}, DeliveryTime: 1700000001000, DeliveryCount: 2}},
+				Consumers:   []StreamConsumer{{Name: "c1", SeenTime: 1700000002000, Pending: []StreamID{{Ms: 1, Seq: 1}}}, {Name: "c2", SeenTime: 1700000002000}},
+			},
+		},
+	}
+
+	var b bytes.Buffer
+	w, _ := NewWriter(&b)
+	w.SelectDB(0, 4, 1)
+	w.String("expiring", "value", 1800000000000)
+	w.List("list", []string{"a", "b", "a"}, 0)
+	w.Hash("hash", map[string]string{"f1": "v1"}, 0)
+	w.SelectDB(12, 2, 0)
+	w.ZSet("zset", []ZMember{{"a", 1.5}, {"b", math.Inf(-1)}}, 0)
+	w.Stream("stream", &stream, 0)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]*Key{
+		"expiring": {0, "expiring", "string", "value", 1800000000000},
+		"list":     {0, "list", "list", []string{"a", "b", "a"}, 0},
+		"hash":     {0, "hash", "hash", map[string]string{"f1": "v1"}, 0},
+		"zset":     {12, "zset", "zset", []ZMember{{"a", 1.5}, {"b", math.Inf(-1)}}, 0},
+		"stream":   {12, "stream", "stream", &stream, 0},
+	}
+	if keys := readKeys(t, b.Bytes()); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %+v, got %+v", expected, keys)
+	}
+}
+
+func TestWriterOddFields(t *testing.T) {
+	var b bytes.Buffer
+	w, _ := NewWriter(&b)
+	if err := w.Stream("stream", &Stream{Entries: []StreamEntry{{ID: StreamID{1, 1}, Fields: []string{"a"}}}}, 0); err == nil {
+		t.Errorf("expected an error for an entry with an odd number of fields")
+	}
+}
@@ -0,0 +1,199 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+var (
+	errInvalidZiplist = errors.New("invalid ziplist")
+	errInvalidZipmap  = errors.New("invalid zipmap")
+	errInvalidIntset  = errors.New("invalid intset")
+	errInvalidLZF     = errors.New("invalid LZF compressed string")
+)
+
+// parseZiplist returns the elements of a ziplist, the encoding of small
+// lists, hashes and sorted sets before Redis 7.0, integers formatted as
+// strings
+func parseZiplist(zl []byte) ([]string, error) {
+	if len(zl) < 11 || int(binary.LittleEndian.Uint32(zl)) != len(zl) || zl[len(zl)-1] != 0xFF {
+		return nil, errInvalidZiplist
+	}
+
+	var elements []string
+	for p := 10; zl[p] != 0xFF; {
+		// The length of the previous entry, on 1 or 5 bytes
+		if zl[p] == 0xFE {
+			p += 5
+		} else {
+			p++
+		}
+		if p >= len(zl) {
+			return nil, errInvalidZiplist
+		}
+
+		b := zl[p]
+		var strLen, strStart, intSize int
+		switch {
+		case b>>6 == 0:
+			strLen, strStart = int(b&0x3F), p+1
+		case b>>6 == 1:
+			if p+1 >= len(zl) {
+				return nil, errInvalidZiplist
+			}
+			strLen, strStart = int(b&0x3F)<<8|int(zl[p+1]), p+2
+		case b == 0x80:
+			if p+5 >= len(zl) {
+				return nil, errInvalidZiplist
+			}
+			strLen, strStart = int(binary.BigEndian.Uint32(zl[p+1:])), p+5
+		case b == 0xC0:
+			intSize = 2
+		case b == 0xD0:
+			intSize = 4
+		case b == 0xE0:
+			intSize = 8
+		case b == 0xF0:
+			intSize = 3
+		case b == 0xFE:
+			intSize = 1
+		case b >= 0xF1 && b <= 0xFD:
+			// 4 bits immediate integer, from 0 to 12
+			elements = append(elements, strconv.Itoa(int(b&0x0F)-1))
+			p++
+			continue
+		default:
+			return nil, errInvalidZiplist
+		}
+
+		if intSize > 0 {
+			if p+intSize >= len(zl) {
+				return nil, errInvalidZiplist
+			}
+			elements = append(elements, strconv.FormatInt(littleEndianInt(zl[p+1:p+1+intSize]), 10))
+			p += 1 + intSize
+			continue
+		}
+		if strStart+strLen >= len(zl) {
+			return nil, errInvalidZiplist
+		}
+		elements = append(elements, string(zl[strStart:strStart+strLen]))
+		p = strStart + strLen
+	}
+
+	return elements, nil
+}
+
+// littleEndianInt decodes a signed little endian integer of 1 to 8 bytes
+func littleEndianInt(b []byte) int64 {
+	var u uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		u = u<<8 | uint64(b[i])
+	}
+	shift := 64 - 8*len(b)
+	return int64(u<<shift) >> shift
+}
+
+// parseZipmap returns the fields and values of a zipmap, the encoding of
+// small hashes before Redis 2.6
+func parseZipmap(zm []byte) (map[string]string, error) {
+	readLen := func(p int) (int, int, error) {
+		if p >= len(zm) {
+			return 0, 0, errInvalidZipmap
+		}
+		switch b := zm[p]; {
+		case b < 254:
+			return int(b), p + 1, nil
+		case b == 254 && p+5 <= len(zm):
+			return int(binary.LittleEndian.Uint32(zm[p+1:])), p + 5, nil
+		}
+		return 0, 0, errInvalidZipmap
+	}
+
+	val := map[string]string{}
+	for p := 1; p < len(zm) && zm[p] != 0xFF; {
+		l, p2, err := readLen(p)
+		if err != nil || p2+l > len(zm) {
+			return nil, errInvalidZipmap
+		}
+		field := string(zm[p2 : p2+l])
+
+		l, p2, err = readLen(p2 + l)
+		if err != nil || p2+1+l > len(zm) {
+			return nil, errInvalidZipmap
+		}
+		// Values are followed by free bytes, their number before the value
+		free := int(zm[p2])
+		val[field] = string(zm[p2+1 : p2+1+l])
+		p = p2 + 1 + l + free
+	}
+
+	return val, nil
+}
+
+// parseIntset returns the members of an intset, the encoding of small sets
+// of integers
+func parseIntset(is []byte) ([]string, error) {
+	if len(is) < 8 {
+		return nil, errInvalidIntset
+	}
+	size := int(binary.LittleEndian.Uint32(is))
+	n := int(binary.LittleEndian.Uint32(is[4:]))
+	if (size != 2 && size != 4 && size != 8) || len(is) != 8+n*size {
+		return nil, errInvalidIntset
+	}
+
+	members := make([]string, 0, n)
+	for p := 8; p < len(is); p += size {
+		members = append(members, strconv.FormatInt(littleEndianInt(is[p:p+size]), 10))
+	}
+	return members, nil
+}
+
+// lzfDecompress decompresses a string compressed with LZF, ulen bytes long
+// once decompressed
+func lzfDecompress(in []byte, ulen int) ([]byte, error) {
+	out := make([]byte, 0, ulen)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < 1<<5 {
+			// Literal run of ctrl+1 bytes
+			if ip+ctrl+1 > len(in) {
+				return nil, errInvalidLZF
+			}
+			out = append(out, in[ip:ip+ctrl+1]...)
+			ip += ctrl + 1
+			continue
+		}
+
+		// Back reference of length+2 bytes
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errInvalidLZF
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errInvalidLZF
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[ip]) - 1
+		ip++
+		if ref < 0 {
+			return nil, errInvalidLZF
+		}
+		// The reference can overlap with the bytes it produces
+		for i := 0; i < length+2; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != ulen {
+		return nil, errInvalidLZF
+	}
+	return out, nil
+}
@@ -0,0 +1,226 @@
+package redisdump
+
+import (
+	"fmt"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// batchKey is a key read together with the other keys of a batch
+type batchKey struct {
+	key     string
+	keyType string
+	// txType is the type read in the same transaction as the value
+	txType string
+	length int
+	val    interface{}
+	pttl   int64
+
+	// dumped is the key to write out. Keys that could not be read with
+	// the batch are marked single, to be dumped on their own.
+	dumped *Key
+	single bool
+	err    error
+}
+
+// batchPipeline gathers the commands sent for the keys of a batch, so
+// they are sent in a single round trip. The errors Redis returns are
+// attributed to the key each command was sent for.
+type batchPipeline struct {
+	actions []radix.CmdAction
+	replies []*cmdReply
+	keys    []*batchKey
+}
+
+// add queues a command for k, reading its reply into rcv. The reply is
+// returned; its error is not attributed to k if k is nil.
+func (p *batchPipeline) add(k *batchKey, cmd radixCmder, rcv interface{}, command string, args ...string) *cmdReply {
+	reply := &cmdReply{rcv: rcv}
+	p.actions = append(p.actions, cmd(reply, command, args...))
+	p.replies = append(p.replies, reply)
+	p.keys = append(p.keys, k)
+	return reply
+}
+
+// run sends the queued commands. The error returned is the one preventing
+// the pipeline from running, errors returned for keys are set on them.
+func (p *batchPipeline) run(client radix.Client) error {
+	if len(p.actions) == 0 {
+		return nil
+	}
+	if err := client.Do(radix.Pipeline(p.actions...)); err != nil {
+		return err
+	}
+
+	for i, reply := range p.replies {
+		if k := p.keys[i]; k != nil && reply.err != nil && k.err == nil {
+			k.err = reply.err
+		}
+	}
+	return nil
+}
+
+// readBatch reads keys with three pipelines: their types first, then the
+// lengths of their values if chunkThreshold is not negative, and finally
+// their values along with their TTLs - in a MULTI/EXEC transaction per key,
+// which also reads the type again if atomic is set. Large values, streams
+// and keys that changed type are marked single.
+func readBatch(client radix.Client, cmd radixCmder, keys []string, ttlMode TTLMode, batchSize int, chunkThreshold int, atomic bool) ([]*batchKey, error) {
+	batch := make([]*batchKey, len(keys))
+	var p batchPipeline
+	for i, key := range keys {
+		batch[i] = &batchKey{key: key}
+		p.add(batch[i], cmd, &batch[i].keyType, "TYPE", key)
+	}
+	if err := p.run(client); err != nil {
+		return nil, err
+	}
+
+	if chunkThreshold >= 0 {
+		p = batchPipeline{}
+		for _, k := range batch {
+			if lenCmd, ok := lengthCmds[k.keyType]; ok && k.err == nil {
+				p.add(k, cmd, &k.length, lenCmd, k.key)
+			}
+		}
+		if err := p.run(client); err != nil {
+			return nil, err
+		}
+	}
+
+	p = batchPipeline{}
+	for _, k := range batch {
+		if k.err != nil || k.keyType == "none" {
+			continue
+		}
+		val, readCmd := valueReader(k.keyType, k.key)
+		if val == nil || (chunkThreshold >= 0 && k.length > chunkThreshold) {
+			k.single = true
+			continue
+		}
+
+		k.val = val
+		if ttlMode == NoTTL && !atomic {
+			p.add(k, cmd, val, readCmd[0], readCmd[1:]...)
+			continue
+		}
+
+		var rcvs execReply
+		p.add(k, cmd, nil, "MULTI")
+		if atomic {
+			p.add(k, cmd, nil, "TYPE", k.key)
+			rcvs = append(rcvs, &k.txType)
+		}
+		p.add(k, cmd, nil, readCmd[0], readCmd[1:]...)
+		rcvs = append(rcvs, val)
+		if ttlMode != NoTTL {
+			p.add(k, cmd, nil, "PTTL", k.key)
+			rcvs = append(rcvs, &k.pttl)
+		}
+		p.add(k, cmd, rcvs, "EXEC")
+	}
+	if err := p.run(client); err != nil {
+		return nil, err
+	}
+
+	for _, k := range batch {
+		if k.val == nil {
+			continue
+		}
+		// The value was read with the wrong command, the error is expected
+		if atomic && k.txType != "" && k.txType != k.keyType {
+			k.single = true
+			k.err = nil
+			continue
+		}
+		if k.err != nil {
+			continue
+		}
+
+		val := valueOf(k.val)
+		k.dumped = &Key{
+			Name:  k.key,
+			Type:  k.keyType,
+			Value: val,
+			Cmds:  valueToRedisCmds(k.keyType, k.key, val, batchSize),
+		}
+		if k.pttl > 0 {
+			k.dumped.setTTL(k.pttl, ttlMode)
+		}
+	}
+
+	return batch, nil
+}
+
+// payloadToRedisCmd returns the RESTORE command recreating key from its
+// DUMP payload, with pttl milliseconds left to live
+func payloadToRedisCmd(key string, payload string, pttl int64, ttlMode TTLMode, hints []string) []string {
+	switch {
+	case ttlMode == NoTTL || pttl <= 0:
+		return restoreToRedisCmd(key, payload, 0, true, hints)
+	case ttlMode == RelativeTTL:
+		return restoreToRedisCmd(key, payload, pttl, false, hints)
+	default:
+		return restoreToRedisCmd(key, payload, time.Now().UnixMilli()+pttl, true, hints)
+	}
+}
+
+// readPayloadBatch reads keys with DUMP in a single pipeline, along with
+// their TTLs and LRU/LFU hints. Keys that do not exist anymore are not
+// dumped.
+func readPayloadBatch(client radix.Client, cmd radixCmder, keys []string, ttlMode TTLMode) ([]*batchKey, error) {
+	batch := make([]*batchKey, len(keys))
+	payloads := make([]string, len(keys))
+	idles := make([]int64, len(keys))
+	freqs := make([]int64, len(keys))
+	idleReplies := make([]*cmdReply, len(keys))
+	freqReplies := make([]*cmdReply, len(keys))
+
+	// OBJECT IDLETIME fails when an LFU maxmemory policy is used, and
+	// OBJECT FREQ otherwise: both are sent, the one that fails is ignored
+	var p batchPipeline
+	for i, key := range keys {
+		k := &batchKey{key: key}
+		batch[i] = k
+		idleReplies[i] = p.add(nil, cmd, &idles[i], "OBJECT", "IDLETIME", key)
+		freqReplies[i] = p.add(nil, cmd, &freqs[i], "OBJECT", "FREQ", key)
+
+		if ttlMode == NoTTL {
+			p.add(k, cmd, &payloads[i], "DUMP", key)
+			continue
+		}
+		p.add(k, cmd, nil, "MULTI")
+		p.add(k, cmd, nil, "DUMP", key)
+		p.add(k, cmd, nil, "PTTL", key)
+		p.add(k, cmd, execReply{&payloads[i], &k.pttl}, "EXEC")
+	}
+	if err := p.run(client); err != nil {
+		return nil, err
+	}
+
+	for i, k := range batch {
+		if k.err != nil || payloads[i] == "" {
+			continue
+		}
+
+		var hints []string
+		if idleReplies[i].err == nil {
+			if idles[i] > 0 {
+				hints = []string{"IDLETIME", fmt.Sprint(idles[i])}
+			}
+		} else if freqReplies[i].err == nil {
+			hints = []string{"FREQ", fmt.Sprint(freqs[i])}
+		}
+		k.dumped = &Key{
+			Name: k.key,
+			Cmds: [][]string{payloadToRedisCmd(k.key, payloads[i], k.pttl, ttlMode, hints)},
+		}
+		if ttlMode != NoTTL && k.pttl > 0 {
+			k.dumped.PTTL = k.pttl
+			k.dumped.ExpireAt = time.Now().UnixMilli() + k.pttl
+		}
+	}
+
+	return batch, nil
+}
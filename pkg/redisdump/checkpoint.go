@@ -0,0 +1,222 @@
+package redisdump
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is the minimum delay between two writes of a
+// checkpoint file
+var checkpointInterval = time.Second
+
+// DbCheckpoint is the progress of the dump of a database. All keys
+// returned by SCAN up to Cursor were written to the dump, except Failed,
+// which failed to be dumped and are dumped again on resume. Done is set
+// once the whole database was scanned.
+type DbCheckpoint struct {
+	Cursor string   `json:"cursor"`
+	Done   bool     `json:"done"`
+	Failed []string `json:"failed,omitempty"`
+}
+
+// Checkpoint records the progress of a dump in a file, so an interrupted
+// dump can be resumed.
+type Checkpoint struct {
+	Databases map[uint8]*DbCheckpoint `json:"databases"`
+
+	path    string
+	mu      sync.Mutex
+	savedAt time.Time
+	err     error
+}
+
+// NewCheckpoint returns an empty Checkpoint, saved to the file path
+func NewCheckpoint(path string) *Checkpoint {
+	return &Checkpoint{
+		Databases: map[uint8]*DbCheckpoint{},
+		path:      path,
+	}
+}
+
+// LoadCheckpoint reads the Checkpoint saved to the file path by a
+// previous dump
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCheckpoint(path)
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	if c.Databases == nil {
+		c.Databases = map[uint8]*DbCheckpoint{}
+	}
+
+	return c, nil
+}
+
+// cursor returns the SCAN cursor to resume the dump of db from, and
+// whether db was already fully dumped
+func (c *Checkpoint) cursor(db uint8) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dbc, ok := c.Databases[db]
+	if !ok || dbc.Cursor == "" {
+		return "0", false
+	}
+	return dbc.Cursor, dbc.Done
+}
+
+// failed returns the keys of db that failed to be dumped
+func (c *Checkpoint) failed(db uint8) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if dbc, ok := c.Databases[db]; ok {
+		return append([]string{}, dbc.Failed...)
+	}
+	return nil
+}
+
+// database returns the progress of db. c.mu must be held.
+func (c *Checkpoint) database(db uint8) *DbCheckpoint {
+	if _, ok := c.Databases[db]; !ok {
+		c.Databases[db] = &DbCheckpoint{}
+	}
+	return c.Databases[db]
+}
+
+// update records the progress of the dump of db. The file is written at
+// most once every checkpointInterval, unless db is done.
+func (c *Checkpoint) update(db uint8, cursor string, done bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dbc := c.database(db)
+	dbc.Cursor, dbc.Done = cursor, done
+	if done || time.Since(c.savedAt) >= checkpointInterval {
+		c.save()
+	}
+}
+
+// retry records the keys of db that failed to be dumped, and forgets the
+// keys that failed before and were dumped again. It is written to the
+// file with the next update.
+func (c *Checkpoint) retry(db uint8, retried []string, failed []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dbc := c.database(db)
+	keys := map[string]bool{}
+	for _, key := range dbc.Failed {
+		keys[key] = true
+	}
+	for _, key := range retried {
+		delete(keys, key)
+	}
+	for _, key := range failed {
+		keys[key] = true
+	}
+
+	dbc.Failed = dbc.Failed[:0]
+	for key := range keys {
+		dbc.Failed = append(dbc.Failed, key)
+	}
+	sort.Strings(dbc.Failed)
+}
+
+// Flush writes the checkpoint file, and returns the first error
+// encountered writing it
+func (c *Checkpoint) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.save()
+	return c.err
+}
+
+// save writes the checkpoint to a temporary file first, so the file is
+// never left half-written. c.mu must be held.
+func (c *Checkpoint) save() {
+	c.savedAt = time.Now()
+
+	data, err := json.Marshal(c)
+	if err == nil {
+		tmp := filepath.Join(filepath.Dir(c.path), "."+filepath.Base(c.path)+".tmp")
+		if err = os.WriteFile(tmp, data, 0644); err == nil {
+			err = os.Rename(tmp, c.path)
+		}
+	}
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// cursorTracker follows the batches of keys returned by SCAN while they
+// are dumped by concurrent workers. Once all batches up to a cursor are
+// dumped, that cursor is passed to onDumped. The keys of a batch that
+// failed to be dumped are passed to onFailed, if set, before the batch is
+// marked as dumped.
+type cursorTracker struct {
+	mu       sync.Mutex
+	cursors  map[int]string
+	dumped   map[int]bool
+	nBatches int
+	next     int
+	onDumped func(cursor string)
+	onFailed func(retried []string, failed []string)
+}
+
+func newCursorTracker(onDumped func(cursor string)) *cursorTracker {
+	return &cursorTracker{
+		cursors:  map[int]string{},
+		dumped:   map[int]bool{},
+		onDumped: onDumped,
+	}
+}
+
+// add registers a batch of keys, followed by cursor in the SCAN, and
+// returns its sequence number
+func (t *cursorTracker) add(cursor string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	seq := t.nBatches
+	t.cursors[seq] = cursor
+	t.nBatches++
+	return seq
+}
+
+// done marks the batch seq as dumped
+func (t *cursorTracker) done(seq int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.dumped[seq] = true
+	cursor := ""
+	for t.dumped[t.next] {
+		cursor = t.cursors[t.next]
+		delete(t.dumped, t.next)
+		delete(t.cursors, t.next)
+		t.next++
+	}
+
+	if cursor != "" {
+		t.onDumped(cursor)
+	}
+}
+
+// failed records the keys of a batch that failed to be dumped. retried are
+// the keys of a batch of keys that failed before, dumped again.
+func (t *cursorTracker) failed(retried []string, failed []string) {
+	if t.onFailed != nil {
+		t.onFailed(retried, failed)
+	}
+}
@@ -0,0 +1,114 @@
+package redisdump
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCursorTracker(t *testing.T) {
+	for i, testCase := range []struct {
+		cursors  []string
+		dumped   []int
+		expected []string
+	}{
+		{[]string{"10", "20", "0"}, []int{0, 1, 2}, []string{"10", "20", "0"}},
+		{[]string{"10", "20", "0"}, []int{2, 1, 0}, []string{"0"}},
+		{[]string{"10", "20", "0"}, []int{1, 0, 2}, []string{"20", "0"}},
+		// A batch that was never dumped holds back the following cursors
+		{[]string{"10", "20", "0"}, []int{0, 2}, []string{"10"}},
+	} {
+		var cursors []string
+		tracker := newCursorTracker(func(cursor string) {
+			cursors = append(cursors, cursor)
+		})
+
+		for j, cursor := range testCase.cursors {
+			if seq := tracker.add(cursor); seq != j {
+				t.Errorf("test %d: expected sequence number %d, got %d", i, j, seq)
+			}
+		}
+		for _, seq := range testCase.dumped {
+			tracker.done(seq)
+		}
+
+		if !testEqString(cursors, testCase.expected) {
+			t.Errorf("test %d: expected cursors %q, got %q", i, testCase.expected, cursors)
+		}
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c := NewCheckpoint(path)
+	if cursor, done := c.cursor(0); cursor != "0" || done {
+		t.Errorf("expected a new checkpoint to start from cursor 0, got %s, %t", cursor, done)
+	}
+	c.update(0, "0", true)
+	c.update(3, "1234", false)
+	if err := c.Flush(); err != nil {
+		t.Fatalf("failed saving checkpoint: %s", err)
+	}
+
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("failed loading checkpoint: %s", err)
+	}
+	if cursor, done := loaded.cursor(0); cursor != "0" || !done {
+		t.Errorf("expected database 0 to be done, got %s, %t", cursor, done)
+	}
+	if cursor, done := loaded.cursor(3); cursor != "1234" || done {
+		t.Errorf("expected database 3 to resume from 1234, got %s, %t", cursor, done)
+	}
+
+	if _, err := LoadCheckpoint(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("expected an error loading a missing checkpoint")
+	}
+}
+
+func TestCheckpointFailedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	c := NewCheckpoint(path)
+	tracker := newCursorTracker(func(cursor string) {
+		c.update(0, cursor, cursor == "0")
+	})
+	tracker.onFailed = func(retried []string, failed []string) {
+		c.retry(0, retried, failed)
+	}
+
+	// The cursor advances past a batch with errors, its failed keys are
+	// recorded first
+	tracker.failed(nil, []string{"b", "a"})
+	tracker.done(tracker.add("10"))
+	if cursor, done := c.cursor(0); cursor != "10" || done {
+		t.Errorf("expected database 0 to resume from 10, got %s, %t", cursor, done)
+	}
+	if failed := c.failed(0); !testEqString(failed, []string{"a", "b"}) {
+		t.Errorf("expected keys a and b to have failed, got %q", failed)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatalf("failed saving checkpoint: %s", err)
+	}
+
+	loaded, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("failed loading checkpoint: %s", err)
+	}
+	if failed := loaded.failed(0); !testEqString(failed, []string{"a", "b"}) {
+		t.Errorf("expected keys a and b to be retried, got %q", failed)
+	}
+
+	// Keys dumped again are forgotten, unless they failed again
+	loaded.retry(0, []string{"a", "b"}, []string{"b", "c"})
+	if failed := loaded.failed(0); !testEqString(failed, []string{"b", "c"}) {
+		t.Errorf("expected keys b and c to have failed, got %q", failed)
+	}
+	loaded.update(0, "0", true)
+	if cursor, done := loaded.cursor(0); cursor != "0" || !done {
+		t.Errorf("expected database 0 to be done, got %s, %t", cursor, done)
+	}
+	if failed := loaded.failed(0); len(failed) != 2 {
+		t.Errorf("expected the failed keys to be kept once the database is done, got %q", failed)
+	}
+}
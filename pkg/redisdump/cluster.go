@@ -0,0 +1,109 @@
+package redisdump
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// sumProgress returns one progress channel for each of n concurrent dumps
+// of the database db. Their progress is forwarded to progress as a single
+// total. The returned WaitGroup is done once all channels are closed.
+func sumProgress(db uint8, n int, progress chan<- ProgressNotification) ([]chan ProgressNotification, *sync.WaitGroup) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := make([]int, n)
+	chans := make([]chan ProgressNotification, n)
+
+	for i := range chans {
+		chans[i] = make(chan ProgressNotification)
+		wg.Add(1)
+		go func(i int) {
+			for p := range chans[i] {
+				mu.Lock()
+				done[i] = p.Done
+				total := 0
+				for _, d := range done {
+					total += d
+				}
+				if progress != nil {
+					progress <- ProgressNotification{Db: db, Done: total}
+				}
+				mu.Unlock()
+			}
+			wg.Done()
+		}(i)
+	}
+
+	return chans, &wg
+}
+
+// DumpCluster dumps all Keys from the Redis Cluster s is a node of. The
+// cluster topology is read from s, and all primaries are dumped in parallel,
+// each with its own pool of opts.NWorkers connections. Keys are written to
+// the Logger logger as a single dump of database 0. Errors are handled
+// as by DumpServer.
+func DumpCluster(s Host, opts DumpOptions, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	return DumpClusterContext(context.Background(), s, opts, logger, serializer, progress)
+}
+
+// DumpClusterContext is DumpCluster, interrupted when ctx is done - as
+// DumpServerContext is.
+func DumpClusterContext(ctx context.Context, s Host, opts DumpOptions, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	poolFunc := func(network, addr string) (radix.Client, error) {
+		return radix.NewPool(network, addr, opts.NWorkers, radix.PoolConnFunc(getConnFunc(s, nil)))
+	}
+
+	cluster, err := radix.NewCluster([]string{net.JoinHostPort(s.Host, fmt.Sprint(s.Port))}, radix.ClusterPoolFunc(poolFunc))
+	if err != nil {
+		return err
+	}
+	defer cluster.Close()
+
+	var clients []radix.Client
+	for _, node := range cluster.Topo().Primaries() {
+		client, err := cluster.Client(node.Addr)
+		if err != nil {
+			return err
+		}
+		clients = append(clients, client)
+	}
+
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	collector := newErrorCollector(opts.MaxErrors, cancel)
+
+	db := uint8(0)
+	writeCmd(logger, serializer, []string{"SELECT", fmt.Sprint(db)})
+
+	nodeProgress, progressDone := sumProgress(db, len(clients), progress)
+	errors := make(chan error, len(clients))
+	for i, client := range clients {
+		go func(client radix.Client, progress chan ProgressNotification) {
+			errors <- dumpDB(dumpCtx, client, &db, opts, logger, serializer, nil, collector, progress)
+			close(progress)
+		}(client, nodeProgress[i])
+	}
+
+	for range clients {
+		if nodeErr := <-errors; nodeErr != nil && err == nil {
+			err = nodeErr
+		}
+	}
+	progressDone.Wait()
+
+	if err != nil || dumpCtx.Err() != nil {
+		writeCmd(logger, serializer, IncompleteDumpMarker)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	return collector.err()
+}
@@ -0,0 +1,40 @@
+package redisdump
+
+import (
+	"testing"
+)
+
+func TestSumProgress(t *testing.T) {
+	progress := make(chan ProgressNotification)
+	nodes, wg := sumProgress(0, 3, progress)
+
+	last := 0
+	done := make(chan bool)
+	go func() {
+		for p := range progress {
+			if p.Done < last {
+				t.Errorf("progress went backwards: %d after %d", p.Done, last)
+			}
+			last = p.Done
+		}
+		done <- true
+	}()
+
+	for _, n := range []int{100, 200} {
+		for _, node := range nodes {
+			node <- ProgressNotification{Db: 0, Done: n}
+		}
+	}
+	nodes[0] <- ProgressNotification{Db: 0, Done: 250}
+
+	for _, node := range nodes {
+		close(node)
+	}
+	wg.Wait()
+	close(progress)
+	<-done
+
+	if last != 650 {
+		t.Errorf("expected a total of 650 keys, got %d", last)
+	}
+}
@@ -0,0 +1,105 @@
+package redisdump
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+// Compression is the format dumps are compressed with
+type Compression int
+
+const (
+	// NoCompression writes dumps as they are
+	NoCompression Compression = iota
+	// GzipCompression compresses dumps with gzip
+	GzipCompression
+	// ZstdCompression compresses dumps with Zstandard
+	ZstdCompression
+)
+
+// compressionBlockSize is the size of the blocks of a dump compressed in
+// parallel with gzip
+const compressionBlockSize = 1 << 20
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ParseCompression parses a Compression given as gzip or zstd, or as an
+// empty string for none
+func ParseCompression(compression string) (Compression, error) {
+	switch compression {
+	case "":
+		return NoCompression, nil
+	case "gzip":
+		return GzipCompression, nil
+	case "zstd":
+		return ZstdCompression, nil
+	}
+	return NoCompression, fmt.Errorf("invalid compression %q, can only be gzip or zstd", compression)
+}
+
+// nopWriteCloser does not close the writer it wraps
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// NewCompressedWriter compresses what is written to it to w, with
+// compression, using up to nWorkers goroutines. Close writes the end of
+// the compressed stream, but does not close w.
+func NewCompressedWriter(w io.Writer, compression Compression, nWorkers int) (io.WriteCloser, error) {
+	switch compression {
+	case GzipCompression:
+		gw := pgzip.NewWriter(w)
+		if err := gw.SetConcurrency(compressionBlockSize, nWorkers); err != nil {
+			return nil, err
+		}
+		return gw, nil
+	case ZstdCompression:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(nWorkers))
+	}
+	return nopWriteCloser{w}, nil
+}
+
+// zstdReadCloser releases the resources of the decoder once closed
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return nil
+}
+
+// NewDecompressedReader reads r, decompressing it if it starts as a gzip or
+// Zstandard stream does - it is read as it is otherwise. Close does not
+// close r.
+func NewDecompressedReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return pgzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zstdReadCloser{zr}, nil
+	}
+	return io.NopCloser(br), nil
+}
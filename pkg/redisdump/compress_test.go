@@ -0,0 +1,78 @@
+package redisdump
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseCompression(t *testing.T) {
+	for i, testCase := range []struct {
+		compression string
+		expected    Compression
+		err         bool
+	}{
+		{"", NoCompression, false},
+		{"gzip", GzipCompression, false},
+		{"zstd", ZstdCompression, false},
+		{"lz4", NoCompression, true},
+	} {
+		compression, err := ParseCompression(testCase.compression)
+		if (err != nil) != testCase.err || compression != testCase.expected {
+			t.Errorf("test %d: expected %v (error %t), got %v (%v)", i, testCase.expected, testCase.err, compression, err)
+		}
+	}
+}
+
+func TestCompressedWriter(t *testing.T) {
+	dump := strings.Repeat(RESPSerializer([]string{"SET", "key", "value"}), 100000)
+	for i, testCase := range []struct {
+		compression Compression
+		// dumps appended to a dump are written as a new stream
+		streams int
+	}{
+		{NoCompression, 1},
+		{GzipCompression, 1},
+		{GzipCompression, 2},
+		{ZstdCompression, 1},
+		{ZstdCompression, 2},
+	} {
+		var b bytes.Buffer
+		for j := 0; j < testCase.streams; j++ {
+			w, err := NewCompressedWriter(&b, testCase.compression, 4)
+			if err != nil {
+				t.Fatalf("test %d: received error %+v", i, err)
+			}
+			io.WriteString(w, dump)
+			if err := w.Close(); err != nil {
+				t.Fatalf("test %d: received error closing %+v", i, err)
+			}
+		}
+		if testCase.compression != NoCompression && b.Len() >= len(dump)/10 {
+			t.Errorf("test %d: expected the dump to be compressed, got %d bytes", i, b.Len())
+		}
+
+		r, err := NewDecompressedReader(&b)
+		if err != nil {
+			t.Fatalf("test %d: received error %+v", i, err)
+		}
+		decompressed, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Errorf("test %d: received error decompressing %+v", i, err)
+		}
+		if string(decompressed) != strings.Repeat(dump, testCase.streams) {
+			t.Errorf("test %d: the decompressed dump differs, %d bytes instead of %d", i, len(decompressed), len(dump)*testCase.streams)
+		}
+	}
+
+	// Inputs shorter than the magic numbers are read as they are
+	r, err := NewDecompressedReader(strings.NewReader("*1"))
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "*1" {
+		t.Errorf("expected the input to be left as it is, got %q", b)
+	}
+}
@@ -0,0 +1,318 @@
+package redisdump
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ConflictPolicy is how keys already on the server a dump is restored to
+// are handled
+type ConflictPolicy int
+
+const (
+	// MergeKeys applies the commands of the dump to existing keys: elements
+	// are added to lists, sets, hashes and sorted sets
+	MergeKeys ConflictPolicy = iota
+	// ReplaceKeys deletes existing keys before recreating them
+	ReplaceKeys
+	// SkipExistingKeys leaves existing keys as they are
+	SkipExistingKeys
+)
+
+// ParseConflictPolicy parses a ConflictPolicy given as merge, replace or
+// skip-existing
+func ParseConflictPolicy(policy string) (ConflictPolicy, error) {
+	switch policy {
+	case "merge":
+		return MergeKeys, nil
+	case "replace":
+		return ReplaceKeys, nil
+	case "skip-existing":
+		return SkipExistingKeys, nil
+	}
+	return MergeKeys, fmt.Errorf("invalid conflict policy %q, can only be merge, replace or skip-existing", policy)
+}
+
+// cmdKeyIndex returns the position of the key a command of a dump applies
+// to, or -1 for commands that do not apply to a key
+func cmdKeyIndex(cmd []string) int {
+	switch {
+	case len(cmd) < 2 || strings.ToUpper(cmd[0]) == "SCRIPT":
+		return -1
+	case strings.ToUpper(cmd[0]) == "XGROUP":
+		if len(cmd) > 2 {
+			return 2
+		}
+		return -1
+	case strings.ToUpper(cmd[0]) == "EVAL" || strings.ToUpper(cmd[0]) == "EVALSHA":
+		if len(cmd) > 3 && cmd[2] != "0" {
+			return 3
+		}
+		return -1
+	}
+	return 1
+}
+
+// cmdKey returns the key a command of a dump applies to, or an empty
+// string for commands that do not apply to a key
+func cmdKey(cmd []string) string {
+	if i := cmdKeyIndex(cmd); i >= 0 {
+		return cmd[i]
+	}
+	return ""
+}
+
+// skipExistingScript runs the commands of a key, passed as ARGV after the
+// mode, each preceded by its number of arguments, unless the key existed
+// before it was restored. ARGV[1] is "key" for keys read at once, and
+// "first", "chunk" and "last" for the chunks of values read in chunks and
+// the TTL that follows them: once the first chunk found the key, the
+// marker KEYS[2] skips the following chunks. The marker is deleted with
+// the TTL, or by ConflictSerializer if the dump is interrupted before, and
+// expires after a day otherwise. The script is loaded once by
+// scriptLoadCmd, and run by EVALSHA. It is written on a single line, so
+// dumps written as commands keep it as a single argument.
+const skipExistingScript = "local mode = ARGV[1] " +
+	"if mode == 'key' or mode == 'first' then " +
+	"  if redis.call('EXISTS', KEYS[1]) == 1 then " +
+	"    if mode == 'first' then " +
+	"      redis.call('SET', KEYS[2], '1', 'PX', 86400000) " +
+	"    end " +
+	"    return 0 " +
+	"  end " +
+	"elseif redis.call('EXISTS', KEYS[2]) == 1 then " +
+	"  if mode == 'last' then " +
+	"    redis.call('DEL', KEYS[2]) " +
+	"  end " +
+	"  return 0 " +
+	"end " +
+	"local i = 2 " +
+	"while i <= #ARGV do " +
+	"  local n = tonumber(ARGV[i]) " +
+	"  redis.call(unpack(ARGV, i + 1, i + n)) " +
+	"  i = i + n + 1 " +
+	"end " +
+	"return 1"
+
+// skipExistingSHA is the SHA1 digest skipExistingScript is run with by
+// EVALSHA, once loaded by scriptLoadCmd
+var skipExistingSHA = func() string {
+	sum := sha1.Sum([]byte(skipExistingScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// scriptLoadCmd loads skipExistingScript into the script cache of the
+// server the dump is restored to
+var scriptLoadCmd = []string{"SCRIPT", "LOAD", skipExistingScript}
+
+// isScriptLoad returns whether cmd loads a script. Scripts are cached by
+// the server, but must be loaded before the commands running them, on
+// each connection commands are sent by in parallel.
+func isScriptLoad(cmd []string) bool {
+	return len(cmd) == 3 && strings.ToUpper(cmd[0]) == "SCRIPT" && strings.ToUpper(cmd[1]) == "LOAD"
+}
+
+// maxSkipExistingArgs bounds the number of arguments of the commands run by
+// a single EVALSHA: the commands of keys with more arguments are run by
+// several, as the chunks of values read in chunks are
+const maxSkipExistingArgs = 10000
+
+// skipMarker returns the key marking a key skipped while its chunks are
+// restored. It shares the hash tag of the key, so they are in the same
+// slot of a Redis Cluster.
+func skipMarker(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key + ":redis-dump-go-skipped"
+		}
+	}
+	return "{" + key + "}:redis-dump-go-skipped"
+}
+
+// skipExistingCmd returns the command running cmds unless key exists
+func skipExistingCmd(key string, mode string, cmds [][]string) []string {
+	eval := []string{"EVALSHA", skipExistingSHA, "2", key, skipMarker(key), mode}
+	for _, cmd := range cmds {
+		eval = append(eval, fmt.Sprint(len(cmd)))
+		eval = append(eval, cmd...)
+	}
+	return eval
+}
+
+// skipExistingCmds returns the commands running cmds unless key exists,
+// each with up to maxSkipExistingArgs arguments. Commands split across
+// several EVALSHA are run as chunks: the first one is run with mode, or
+// "first" for a key read at once, the following ones as "chunk", and the
+// last one with mode if it is "last", or as "last" for a key read at once.
+func skipExistingCmds(key string, mode string, cmds [][]string) [][]string {
+	var batches [][][]string
+	nArgs := 0
+	for _, cmd := range cmds {
+		if len(batches) == 0 || nArgs > 0 && nArgs+len(cmd) > maxSkipExistingArgs {
+			batches = append(batches, nil)
+			nArgs = 0
+		}
+		batches[len(batches)-1] = append(batches[len(batches)-1], cmd)
+		nArgs += len(cmd)
+	}
+	if len(batches) <= 1 {
+		return [][]string{skipExistingCmd(key, mode, cmds)}
+	}
+
+	evals := make([][]string, 0, len(batches))
+	for i, batch := range batches {
+		batchMode := "chunk"
+		switch {
+		case i == 0 && mode == "key":
+			batchMode = "first"
+		case i == 0 && mode == "first":
+			batchMode = mode
+		case i == len(batches)-1 && (mode == "key" || mode == "last"):
+			batchMode = "last"
+		}
+		evals = append(evals, skipExistingCmd(key, batchMode, batch))
+	}
+	return evals
+}
+
+type conflictKey struct {
+	db   uint8
+	name string
+}
+
+// ConflictSerializer applies a ConflictPolicy to the keys of a dump
+// serialized with Serializer: with ReplaceKeys, keys are deleted before
+// their first command, and with SkipExistingKeys, their commands are run
+// by a Lua script checking the key did not exist. With either policy, the
+// chunks of values read in chunks are framed by chunk markers, so restore
+// can apply its own policy to them. Commands other than keys are
+// serialized as they are.
+type ConflictSerializer struct {
+	Serializer
+	policy ConflictPolicy
+
+	mu sync.Mutex
+	// chunked are the keys whose first chunks were serialized, but not
+	// their TTL
+	chunked map[conflictKey]bool
+	// db is the database selected by the last SELECT
+	db uint8
+	// loaded is set once the script of SkipExistingKeys was loaded
+	loaded bool
+}
+
+// NewConflictSerializer applies policy to the keys serialized with s
+func NewConflictSerializer(s Serializer, policy ConflictPolicy) *ConflictSerializer {
+	return &ConflictSerializer{
+		Serializer: s,
+		policy:     policy,
+		chunked:    map[conflictKey]bool{},
+	}
+}
+
+// keyMode returns whether k is a key read at once, or the first chunk,
+// a following chunk or the TTL of a value read in chunks
+func (c *ConflictSerializer) keyMode(k *Key) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ck := conflictKey{db: k.Db, name: k.Name}
+	switch {
+	case k.Partial && !c.chunked[ck]:
+		c.chunked[ck] = true
+		return "first"
+	case k.Partial:
+		return "chunk"
+	case c.chunked[ck]:
+		delete(c.chunked, ck)
+		return "last"
+	}
+	return "key"
+}
+
+// Key implements the Serializer interface.
+func (c *ConflictSerializer) Key(k *Key) string {
+	mode := c.keyMode(k)
+	guarded := *k
+	switch c.policy {
+	case ReplaceKeys:
+		if mode == "key" || mode == "first" {
+			guarded.Cmds = append([][]string{{"DEL", k.Name}}, k.Cmds...)
+		}
+	case SkipExistingKeys:
+		// The TTL of a value read in chunks clears the marker, even if the
+		// key does not expire
+		if len(k.Cmds) > 0 || mode == "last" {
+			guarded.Cmds = skipExistingCmds(k.Name, mode, k.Cmds)
+		}
+	}
+	switch {
+	case c.policy == MergeKeys:
+	case k.Partial:
+		guarded.Cmds = append([][]string{chunksMarker(k.Name, false)}, guarded.Cmds...)
+	case mode == "last":
+		guarded.Cmds = append(guarded.Cmds, chunksMarker(k.Name, true))
+	}
+	return c.Serializer.Key(&guarded)
+}
+
+// Cmd implements the Serializer interface. With SkipExistingKeys, the
+// script keys are run by is loaded after the first SELECT, which precedes
+// all keys, and the markers of the keys whose TTL was not serialized are
+// deleted before IncompleteDumpMarker, so interrupted dumps leave none
+// behind.
+func (c *ConflictSerializer) Cmd(cmd []string) string {
+	if c.policy != SkipExistingKeys {
+		return c.Serializer.Cmd(cmd)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(cmd) == 2 && strings.ToUpper(cmd[0]) == "SELECT" {
+		if db, err := strconv.ParseUint(cmd[1], 10, 8); err == nil {
+			c.db = uint8(db)
+		}
+		if !c.loaded {
+			c.loaded = true
+			return joinCmds(c.Serializer.Cmd(cmd), c.Serializer.Cmd(scriptLoadCmd))
+		}
+	}
+	if !isIncompleteDumpMarker(cmd) || len(c.chunked) == 0 {
+		return c.Serializer.Cmd(cmd)
+	}
+
+	keys := make([]conflictKey, 0, len(c.chunked))
+	for k := range c.chunked {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].db < keys[j].db || keys[i].db == keys[j].db && keys[i].name < keys[j].name
+	})
+
+	var out []string
+	for _, k := range keys {
+		out = append(out, c.Serializer.Cmd([]string{"SELECT", fmt.Sprint(k.db)}))
+		out = append(out, c.Serializer.Cmd([]string{"DEL", skipMarker(k.name)}))
+	}
+	out = append(out, c.Serializer.Cmd([]string{"SELECT", fmt.Sprint(c.db)}))
+	out = append(out, c.Serializer.Cmd(cmd))
+	c.chunked = map[conflictKey]bool{}
+	return joinCmds(out...)
+}
+
+// joinCmds joins serialized commands on separate lines, leaving out those
+// serialized as empty strings
+func joinCmds(cmds ...string) string {
+	var out []string
+	for _, cmd := range cmds {
+		if cmd != "" {
+			out = append(out, cmd)
+		}
+	}
+	return strings.Join(out, "\n")
+}
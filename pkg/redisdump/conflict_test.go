@@ -0,0 +1,319 @@
+package redisdump
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	for i, testCase := range []struct {
+		policy   string
+		expected ConflictPolicy
+		err      bool
+	}{
+		{"merge", MergeKeys, false},
+		{"replace", ReplaceKeys, false},
+		{"skip-existing", SkipExistingKeys, false},
+		{"skip", MergeKeys, true},
+		{"", MergeKeys, true},
+	} {
+		policy, err := ParseConflictPolicy(testCase.policy)
+		if (err != nil) != testCase.err || policy != testCase.expected {
+			t.Errorf("test %d: expected %v (error %t), got %v (%v)", i, testCase.expected, testCase.err, policy, err)
+		}
+	}
+}
+
+func TestCmdKey(t *testing.T) {
+	for i, testCase := range []struct {
+		cmd      []string
+		expected string
+	}{
+		{[]string{"SET", "k", "v"}, "k"},
+		{[]string{"XGROUP", "CREATE", "stream", "group", "0"}, "stream"},
+		{[]string{"EVAL", "return 1", "1", "k"}, "k"},
+		{[]string{"EVAL", "return 1", "0"}, ""},
+		{[]string{"SCRIPT", "LOAD", "return 1"}, ""},
+		{[]string{"SELECT"}, ""},
+		{[]string{}, ""},
+	} {
+		if key := cmdKey(testCase.cmd); key != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, key)
+		}
+	}
+}
+
+func TestSkipMarker(t *testing.T) {
+	for i, testCase := range []struct {
+		key      string
+		expected string
+	}{
+		{"user:1", "{user:1}:redis-dump-go-skipped"},
+		{"{user}:1", "{user}:1:redis-dump-go-skipped"},
+		{"{}:1", "{{}:1}:redis-dump-go-skipped"},
+	} {
+		if marker := skipMarker(testCase.key); marker != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, marker)
+		}
+	}
+}
+
+type keyRecorder struct {
+	keys []Key
+}
+
+func (r *keyRecorder) Key(k *Key) string {
+	r.keys = append(r.keys, *k)
+	return ""
+}
+
+func (r *keyRecorder) Cmd(cmd []string) string {
+	return ""
+}
+
+func TestConflictSerializer(t *testing.T) {
+	keys := []*Key{
+		{Db: 0, Name: "s", Cmds: [][]string{{"SET", "s", "v"}}},
+		{Db: 0, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "a"}}},
+		{Db: 0, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "b"}}},
+		{Db: 0, Name: "l"},
+	}
+
+	for i, testCase := range []struct {
+		policy   ConflictPolicy
+		expected [][][]string
+	}{
+		{MergeKeys, [][][]string{
+			{{"SET", "s", "v"}},
+			{{"RPUSH", "l", "a"}},
+			{{"RPUSH", "l", "b"}},
+			nil,
+		}},
+		{ReplaceKeys, [][][]string{
+			{{"DEL", "s"}, {"SET", "s", "v"}},
+			{chunksMarker("l", false), {"DEL", "l"}, {"RPUSH", "l", "a"}},
+			{chunksMarker("l", false), {"RPUSH", "l", "b"}},
+			{chunksMarker("l", true)},
+		}},
+		{SkipExistingKeys, [][][]string{
+			{skipExistingCmd("s", "key", [][]string{{"SET", "s", "v"}})},
+			{chunksMarker("l", false), skipExistingCmd("l", "first", [][]string{{"RPUSH", "l", "a"}})},
+			{chunksMarker("l", false), skipExistingCmd("l", "chunk", [][]string{{"RPUSH", "l", "b"}})},
+			{skipExistingCmd("l", "last", nil), chunksMarker("l", true)},
+		}},
+	} {
+		var recorder keyRecorder
+		s := NewConflictSerializer(&recorder, testCase.policy)
+		for _, k := range keys {
+			s.Key(k)
+		}
+
+		if len(recorder.keys) != len(keys) {
+			t.Errorf("test %d: expected %d keys, got %d", i, len(keys), len(recorder.keys))
+			continue
+		}
+		for j, k := range recorder.keys {
+			if !reflect.DeepEqual(k.Cmds, testCase.expected[j]) {
+				t.Errorf("test %d, key %d: expected %q, got %q", i, j, testCase.expected[j], k.Cmds)
+			}
+		}
+	}
+
+	if expected := []string{"EVALSHA", skipExistingSHA, "2", "k", "{k}:redis-dump-go-skipped", "key", "3", "SET", "k", "v", "2", "DEL", "j"}; !reflect.DeepEqual(skipExistingCmd("k", "key", [][]string{{"SET", "k", "v"}, {"DEL", "j"}}), expected) {
+		t.Errorf("unexpected skip-existing command")
+	}
+}
+
+func TestSkipExistingCmds(t *testing.T) {
+	big := make([]string, maxSkipExistingArgs*2/3)
+	for i, testCase := range []struct {
+		mode     string
+		expected []string
+	}{
+		{"key", []string{"first", "chunk", "last"}},
+		{"first", []string{"first", "chunk", "chunk"}},
+		{"chunk", []string{"chunk", "chunk", "chunk"}},
+		{"last", []string{"chunk", "chunk", "last"}},
+	} {
+		evals := skipExistingCmds("k", testCase.mode, [][]string{big, big, big})
+		var modes []string
+		for _, eval := range evals {
+			modes = append(modes, eval[5])
+		}
+		if !reflect.DeepEqual(modes, testCase.expected) {
+			t.Errorf("test %d: expected modes %q, got %q", i, testCase.expected, modes)
+		}
+	}
+
+	// Commands within the bound are run by a single EVALSHA
+	if evals := skipExistingCmds("k", "key", [][]string{{"SET", "k", "v"}, {"PEXPIRE", "k", "10"}}); len(evals) != 1 || evals[0][5] != "key" {
+		t.Errorf("expected a single EVALSHA, got %q", evals)
+	}
+}
+
+func TestConflictSerializerScriptLoad(t *testing.T) {
+	s := NewConflictSerializer(CmdSerializer(RedisCmdSerializer), SkipExistingKeys)
+
+	// The script is loaded once, after the first SELECT
+	expected := "SELECT 0\n" + RedisCmdSerializer(scriptLoadCmd)
+	if out := s.Cmd([]string{"SELECT", "0"}); out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+	if out := s.Cmd([]string{"SELECT", "1"}); out != "SELECT 1" {
+		t.Errorf("expected the script to be loaded once, got %q", out)
+	}
+
+	s = NewConflictSerializer(CmdSerializer(RedisCmdSerializer), ReplaceKeys)
+	if out := s.Cmd([]string{"SELECT", "0"}); out != "SELECT 0" {
+		t.Errorf("expected no script to be loaded replacing keys, got %q", out)
+	}
+}
+
+func TestConflictSerializerIncomplete(t *testing.T) {
+	s := NewConflictSerializer(CmdSerializer(RedisCmdSerializer), SkipExistingKeys)
+	s.Cmd([]string{"SELECT", "1"})
+	s.Key(&Key{Db: 1, Name: "done", Partial: true, Cmds: [][]string{{"RPUSH", "done", "a"}}})
+	s.Key(&Key{Db: 1, Name: "done", Type: "list"})
+	s.Key(&Key{Db: 1, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "a"}}})
+	s.Cmd([]string{"SELECT", "0"})
+
+	// The markers of the keys whose TTL was not dumped are deleted
+	expected := "SELECT 1\nDEL {l}:redis-dump-go-skipped\nSELECT 0\n" + RedisCmdSerializer(IncompleteDumpMarker)
+	if out := s.Cmd(IncompleteDumpMarker); out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+	if out := s.Cmd(IncompleteDumpMarker); out != RedisCmdSerializer(IncompleteDumpMarker) {
+		t.Errorf("expected the markers to be deleted once, got %q", out)
+	}
+
+	s = NewConflictSerializer(CmdSerializer(RedisCmdSerializer), ReplaceKeys)
+	s.Key(&Key{Db: 0, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "a"}}})
+	if out := s.Cmd(IncompleteDumpMarker); out != RedisCmdSerializer(IncompleteDumpMarker) {
+		t.Errorf("expected no marker to be deleted replacing keys, got %q", out)
+	}
+}
+
+func TestRestoreConflicts(t *testing.T) {
+	batch := []restoreCmd{
+		{db: 0, cmd: []string{"RPUSH", "new", "a"}},
+		{db: 0, cmd: []string{"RPUSH", "existing", "a"}},
+		{db: 1, cmd: []string{"RPUSH", "new", "b"}},
+		{db: 0, cmd: []string{"RPUSH", "new", "c"}},
+		{db: 0, cmd: []string{"FLUSHDB"}},
+	}
+	exists := func(cmds []restoreCmd) error {
+		for _, c := range cmds {
+			n := 0
+			if c.cmd[1] == "existing" {
+				n = 1
+			}
+			*c.rcv.(*int) = n
+		}
+		return nil
+	}
+
+	for i, testCase := range []struct {
+		policy   ConflictPolicy
+		expected [][]string
+	}{
+		{MergeKeys, [][]string{
+			{"RPUSH", "new", "a"}, {"RPUSH", "existing", "a"}, {"RPUSH", "new", "b"}, {"RPUSH", "new", "c"}, {"FLUSHDB"},
+		}},
+		{ReplaceKeys, [][]string{
+			{"DEL", "new"}, {"RPUSH", "new", "a"}, {"DEL", "existing"}, {"RPUSH", "existing", "a"},
+			{"DEL", "new"}, {"RPUSH", "new", "b"}, {"DEL", "new"}, {"RPUSH", "new", "c"}, {"FLUSHDB"},
+		}},
+		{SkipExistingKeys, [][]string{
+			{"RPUSH", "new", "a"}, {"RPUSH", "new", "b"}, {"RPUSH", "new", "c"}, {"FLUSHDB"},
+		}},
+	} {
+		r := newRestoreConflicts(testCase.policy)
+		resolved, err := r.resolve(batch, exists)
+		if err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+			continue
+		}
+		var cmds [][]string
+		for _, c := range resolved {
+			cmds = append(cmds, c.cmd)
+		}
+		if !reflect.DeepEqual(cmds, testCase.expected) {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, cmds)
+		}
+
+		// Keys are only checked or deleted before the first of the commands
+		// following each other, across batches
+		resolved, _ = r.resolve([]restoreCmd{{db: 0, cmd: []string{"RPUSH", "new", "d"}}}, exists)
+		if len(resolved) != 1 {
+			t.Errorf("test %d: unexpected commands for the key of the last command %v", i, resolved)
+		}
+	}
+
+	// Failing to check keys fails the batch
+	r := newRestoreConflicts(SkipExistingKeys)
+	if _, err := r.resolve(batch, func([]restoreCmd) error { return errors.New("connection lost") }); err == nil {
+		t.Errorf("expected the error checking keys to be returned")
+	}
+}
+
+func TestRestoreConflictsChunks(t *testing.T) {
+	batches := [][]restoreCmd{
+		{
+			{db: 0, cmd: chunksMarker("big", false)},
+			{db: 0, cmd: []string{"RPUSH", "big", "a"}},
+			{db: 0, cmd: []string{"SET", "s", "v"}},
+		},
+		{
+			{db: 0, cmd: chunksMarker("big", false)},
+			{db: 0, cmd: []string{"RPUSH", "big", "b"}},
+			{db: 0, cmd: []string{"PEXPIRE", "big", "5000"}},
+			{db: 0, cmd: chunksMarker("big", true)},
+		},
+	}
+	existing := map[string]bool{"big": true}
+	exists := func(cmds []restoreCmd) error {
+		for _, c := range cmds {
+			n := 0
+			if existing[c.cmd[1]] {
+				n = 1
+			}
+			*c.rcv.(*int) = n
+		}
+		return nil
+	}
+
+	for i, testCase := range []struct {
+		policy   ConflictPolicy
+		expected [][]string
+	}{
+		{MergeKeys, [][]string{
+			{"RPUSH", "big", "a"}, {"SET", "s", "v"}, {"RPUSH", "big", "b"}, {"PEXPIRE", "big", "5000"},
+		}},
+		{ReplaceKeys, [][]string{
+			{"DEL", "big"}, {"RPUSH", "big", "a"}, {"DEL", "s"}, {"SET", "s", "v"}, {"RPUSH", "big", "b"}, {"PEXPIRE", "big", "5000"},
+		}},
+		{SkipExistingKeys, [][]string{
+			{"SET", "s", "v"},
+		}},
+	} {
+		r := newRestoreConflicts(testCase.policy)
+		var cmds [][]string
+		for _, batch := range batches {
+			resolved, err := r.resolve(batch, exists)
+			if err != nil {
+				t.Fatalf("test %d: received error %+v", i, err)
+			}
+			for _, c := range resolved {
+				cmds = append(cmds, c.cmd)
+			}
+		}
+		if !reflect.DeepEqual(cmds, testCase.expected) {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, cmds)
+		}
+		// Keys are forgotten once their last chunk was restored
+		if len(r.chunked) != 0 {
+			t.Errorf("test %d: expected no chunked key left, got %v", i, r.chunked)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package redisdump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/yannh/redis-dump-go/pkg/rdb"
+)
+
+// matchPattern reports whether s matches the glob-style pattern, as the
+// MATCH option of SCAN: * matches any sequence of characters, ? any
+// character, [abc], [^abc] and [a-z] sets of characters, and \ escapes
+// the character that follows it.
+func matchPattern(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchPattern(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			s = s[1:]
+
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			pattern = pattern[1:]
+			not := len(pattern) > 0 && pattern[0] == '^'
+			if not {
+				pattern = pattern[1:]
+			}
+			match := false
+			for len(pattern) > 0 && pattern[0] != ']' {
+				switch {
+				case pattern[0] == '\\' && len(pattern) >= 2:
+					pattern = pattern[1:]
+					match = match || pattern[0] == s[0]
+				case len(pattern) >= 3 && pattern[1] == '-':
+					start, end := pattern[0], pattern[2]
+					if start > end {
+						start, end = end, start
+					}
+					match = match || (s[0] >= start && s[0] <= end)
+					pattern = pattern[2:]
+				default:
+					match = match || pattern[0] == s[0]
+				}
+				pattern = pattern[1:]
+			}
+			if match == not {
+				return false
+			}
+			s = s[1:]
+			if len(pattern) == 0 {
+				// An unterminated set ends the pattern
+				return len(s) == 0
+			}
+
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+			s = s[1:]
+		}
+		pattern = pattern[1:]
+	}
+
+	return len(s) == 0
+}
+
+// formatScore formats the score of a sorted set member as ZRANGE does,
+// without exponent unless it is very large or very small
+func formatScore(score float64) string {
+	switch abs := math.Abs(score); {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	case abs == 0 || (abs >= 1e-4 && abs < 1e17):
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// streamFromRDB converts a stream read from an RDB file. Entries are
+// pending for the consumers holding them in their list of pending entries,
+// and their idle time is derived from the time they were delivered at.
+func streamFromRDB(val *rdb.Stream, now int64) Stream {
+	s := Stream{LastID: val.LastID.String()}
+	for _, e := range val.Entries {
+		s.Entries = append(s.Entries, StreamEntry{ID: e.ID.String(), Fields: e.Fields})
+	}
+
+	for _, g := range val.Groups {
+		group := StreamGroup{Name: g.Name, LastDeliveredID: g.LastID.String()}
+		if g.EntriesRead >= 0 {
+			group.EntriesRead = fmt.Sprint(g.EntriesRead)
+		}
+
+		consumers := map[rdb.StreamID]string{}
+		for _, c := range g.Consumers {
+			group.Consumers = append(group.Consumers, c.Name)
+			for _, id := range c.Pending {
+				consumers[id] = c.Name
+			}
+		}
+		for _, p := range g.Pending {
+			group.Pending = append(group.Pending, StreamPendingEntry{
+				ID:         p.ID.String(),
+				Consumer:   consumers[p.ID],
+				Idle:       fmt.Sprint(max(now-p.DeliveryTime, 0)),
+				Deliveries: fmt.Sprint(p.DeliveryCount),
+			})
+		}
+		s.Groups = append(s.Groups, group)
+	}
+
+	return s
+}
+
+// keyFromRDB converts a key read from an RDB file to a Key, as if it
+// were read from a server at the time now
+func keyFromRDB(rk *rdb.Key, ttlMode TTLMode, batchSize int, now int64) (*Key, error) {
+	if rk.Db > math.MaxUint8 {
+		return nil, fmt.Errorf("database %d is not supported", rk.Db)
+	}
+	k := &Key{Db: uint8(rk.Db), Name: rk.Name, Type: rk.Type}
+
+	switch v := rk.Value.(type) {
+	case string, []string, map[string]string:
+		k.Value = v
+	case []rdb.ZMember:
+		val := make([]string, 0, 2*len(v))
+		for _, m := range v {
+			val = append(val, m.Member, formatScore(m.Score))
+		}
+		k.Value = val
+	case *rdb.Stream:
+		k.Value = streamFromRDB(v, now)
+	default:
+		return nil, fmt.Errorf("keys of type %s can not be converted", rk.Type)
+	}
+	k.Cmds = valueToRedisCmds(k.Type, k.Name, k.Value, batchSize)
+
+	if rk.ExpireAt != 0 && ttlMode != NoTTL {
+		k.PTTL, k.ExpireAt = rk.ExpireAt-now, rk.ExpireAt
+		if ttlMode == RelativeTTL {
+			k.Cmds = append(k.Cmds, ttlToRedisCmd(k.Name, k.PTTL, ttlMode))
+		} else {
+			k.Cmds = append(k.Cmds, []string{"PEXPIREAT", k.Name, fmt.Sprint(k.ExpireAt)})
+		}
+	}
+	return k, nil
+}
+
+// ConvertRDB writes the keys of the RDB file r to logger, serialized with
+// serializer, as DumpServer writes the keys of a server: the keys of db,
+// or of all databases if db is AllDBs, matching filter. Keys are read
+// from the file, not from a server, so dumps can be extracted from
+// backups. Keys that had expired by the time of the conversion are left
+// out, unless TTLs are dropped.
+// Keys that can not be converted, such as keys of modules, are returned
+// as DumpErrors; the conversion is interrupted once more than maxErrors
+// keys failed, it is never interrupted if maxErrors is negative. If the
+// file is corrupted or ctx is done, the conversion stops, and
+// IncompleteDumpMarker ends the output.
+func ConvertRDB(ctx context.Context, r io.Reader, db *uint8, filter string, ttlMode TTLMode, batchSize int, maxErrors int, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	convertCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	collector := newErrorCollector(maxErrors, cancel)
+
+	reader, err := rdb.NewReader(r)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	selected, nConverted := -1, 0
+	notify := func() {
+		if progress != nil && nConverted > 0 {
+			progress <- ProgressNotification{Db: uint8(selected), Done: nConverted}
+		}
+	}
+	for convertCtx.Err() == nil {
+		var rk *rdb.Key
+		if rk, err = reader.Next(); err != nil {
+			break
+		}
+		if (db != AllDBs && rk.Db != uint64(*db)) || !matchPattern(filter, rk.Name) {
+			continue
+		}
+		if rk.ExpireAt != 0 && rk.ExpireAt <= now && ttlMode != NoTTL {
+			continue
+		}
+
+		k, keyErr := keyFromRDB(rk, ttlMode, batchSize, now)
+		if keyErr != nil {
+			keyErr := KeyError{Db: uint8(rk.Db), Key: rk.Name, Err: keyErr}
+			fmt.Fprintln(os.Stderr, "Error: "+keyErr.Error())
+			collector.add(keyErr)
+			continue
+		}
+
+		if int(k.Db) != selected {
+			notify()
+			selected, nConverted = int(k.Db), 0
+			writeCmd(logger, serializer, []string{"SELECT", fmt.Sprint(k.Db)})
+		}
+		writeKey(logger, serializer, k)
+
+		if nConverted++; nConverted%100 == 0 {
+			notify()
+		}
+	}
+	if nConverted%100 != 0 {
+		notify()
+	}
+
+	if err == io.EOF {
+		return collector.err()
+	}
+	writeCmd(logger, serializer, IncompleteDumpMarker)
+	if err != nil {
+		return fmt.Errorf("failed reading RDB file: %w", err)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return collector.err()
+}
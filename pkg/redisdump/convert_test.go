@@ -0,0 +1,229 @@
+package redisdump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/redis-dump-go/pkg/rdb"
+)
+
+func TestMatchPattern(t *testing.T) {
+	for i, testCase := range []struct {
+		pattern  string
+		s        string
+		expected bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"user:*", "user:1", true},
+		{"user:*", "session:1", false},
+		{"*:1", "user:1", true},
+		{"*:1", "user:12", false},
+		{"a**b", "axxb", true},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-b]llo", "hbllo", true},
+		{"h[b-a]llo", "hbllo", true},
+		{"h[a-b]llo", "hcllo", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h[\]]llo`, "h]llo", true},
+		{"key", "key", true},
+		{"key", "keys", false},
+		{"h[ab", "ha", true},
+		{`key\`, `key\`, true},
+	} {
+		if got := matchPattern(testCase.pattern, testCase.s); got != testCase.expected {
+			t.Errorf("test %d: expected matchPattern(%q, %q) to be %t", i, testCase.pattern, testCase.s, testCase.expected)
+		}
+	}
+}
+
+func TestFormatScore(t *testing.T) {
+	for _, testCase := range []struct {
+		score    float64
+		expected string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{-8589934592, "-8589934592"},
+		{1e20, "1e+20"},
+		{0.00001, "1e-05"},
+		{math.Inf(1), "inf"},
+		{math.Inf(-1), "-inf"},
+	} {
+		if got := formatScore(testCase.score); got != testCase.expected {
+			t.Errorf("expected %s, got %s", testCase.expected, got)
+		}
+	}
+}
+
+func TestStreamFromRDB(t *testing.T) {
+	s := streamFromRDB(&rdb.Stream{
+		Entries: []rdb.StreamEntry{{ID: rdb.StreamID{Ms: 1, Seq: 1}, Fields: []string{"a", "1"}}},
+		LastID:  rdb.StreamID{Ms: 2},
+		Groups: []rdb.StreamGroup{
+			{
+				Name:        "g1",
+				LastID:      rdb.StreamID{Ms: 1, Seq: 1},
+				EntriesRead: 1,
+				Pending:     []rdb.StreamPendingEntry{{ID: rdb.StreamID{Ms: 1, Seq: 1}, DeliveryTime: 1000, DeliveryCount: 2}},
+				Consumers:   []rdb.StreamConsumer{{Name: "c1"}, {Name: "c2", Pending: []rdb.StreamID{{Ms: 1, Seq: 1}}}},
+			},
+			{Name: "g2", EntriesRead: -1},
+		},
+	}, 1500)
+
+	expected := Stream{
+		Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1"}}},
+		LastID:  "2-0",
+		Groups: []StreamGroup{
+			{Name: "g1", LastDeliveredID: "1-1", EntriesRead: "1", Consumers: []string{"c1", "c2"}, Pending: []StreamPendingEntry{{ID: "1-1", Consumer: "c2", Idle: "500", Deliveries: "2"}}},
+			{Name: "g2", LastDeliveredID: "0-0"},
+		},
+	}
+	if !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v, got %+v", expected, s)
+	}
+}
+
+// testRDBFile returns an RDB file with keys in databases 0 and 2, user:2
+// expiring at expireAt
+func testRDBFile(expireAt int64) []byte {
+	var b bytes.Buffer
+	w, _ := rdb.NewWriter(&b)
+	w.SelectDB(0, 5, 2)
+	w.String("user:1", "alice", 0)
+	w.String("user:2", "bob", expireAt)
+	w.String("expired", "value", 1000)
+	w.List("list", []string{"a", "b"}, 0)
+	w.ZSet("zset", []rdb.ZMember{{Member: "a", Score: 1.5}, {Member: "b", Score: math.Inf(1)}}, 0)
+	w.SelectDB(2, 2, 0)
+	w.Hash("user:3", map[string]string{"name": "carol"}, 0)
+	w.Stream("stream", &rdb.Stream{Entries: []rdb.StreamEntry{{ID: rdb.StreamID{Ms: 1, Seq: 1}, Fields: []string{"f", "v"}}}, LastID: rdb.StreamID{Ms: 1, Seq: 1}}, 0)
+	w.Close()
+	return b.Bytes()
+}
+
+func TestConvertRDB(t *testing.T) {
+	expireAt := time.Now().Add(time.Hour).UnixMilli()
+	file := testRDBFile(expireAt)
+	db2 := uint8(2)
+
+	for i, testCase := range []struct {
+		db       *uint8
+		filter   string
+		ttlMode  TTLMode
+		expected []string
+	}{
+		{
+			AllDBs, "*", RelativeTTL,
+			[]string{
+				"SELECT 0",
+				"SET user:1 alice",
+				"SET user:2 bob",
+				"PEXPIRE user:2 <ttl>",
+				"RPUSH list a b",
+				"ZADD zset 1.5 a inf b",
+				"SELECT 2",
+				"HSET user:3 name carol",
+				"XADD stream 1-1 f v",
+			},
+		},
+		{
+			AllDBs, "user:*", NoTTL,
+			[]string{
+				"SELECT 0",
+				"SET user:1 alice",
+				"SET user:2 bob",
+				"SELECT 2",
+				"HSET user:3 name carol",
+			},
+		},
+		{
+			&db2, "user:*", AbsoluteTTL,
+			[]string{
+				"SELECT 2",
+				"HSET user:3 name carol",
+			},
+		},
+		{
+			AllDBs, "user:2", AbsoluteTTL,
+			[]string{
+				"SELECT 0",
+				"SET user:2 bob",
+				fmt.Sprintf("PEXPIREAT user:2 %d", expireAt),
+			},
+		},
+		{
+			AllDBs, "expired", NoTTL,
+			[]string{
+				"SELECT 0",
+				"SET expired value",
+			},
+		},
+		{
+			AllDBs, "expired", AbsoluteTTL,
+			nil,
+		},
+	} {
+		var b bytes.Buffer
+		l := log.New(&b, "", 0)
+		if err := ConvertRDB(context.Background(), bytes.NewReader(file), testCase.db, testCase.filter, testCase.ttlMode, 10, -1, l, CmdSerializer(RedisCmdSerializer), nil); err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+			continue
+		}
+
+		var lines []string
+		for _, line := range strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n") {
+			if strings.HasPrefix(line, "PEXPIRE ") {
+				// The time left to live depends on the time of the test
+				line = line[:strings.LastIndex(line, " ")] + " <ttl>"
+			}
+			if line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if !reflect.DeepEqual(lines, testCase.expected) {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, lines)
+		}
+	}
+}
+
+func TestConvertRDBErrors(t *testing.T) {
+	file := testRDBFile(0)
+
+	// A truncated file is converted up to the key it ends in
+	var b bytes.Buffer
+	err := ConvertRDB(context.Background(), bytes.NewReader(file[:len(file)-40]), AllDBs, "*", NoTTL, 10, -1, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil)
+	if err == nil {
+		t.Errorf("expected an error converting a truncated file")
+	}
+	if out := b.String(); !strings.Contains(out, "SET user:1 alice") || !strings.HasSuffix(out, RedisCmdSerializer(IncompleteDumpMarker)+"\n") {
+		t.Errorf("expected the keys before the end of the file, and the incomplete dump marker, got %q", out)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Reset()
+	err = ConvertRDB(ctx, bytes.NewReader(file), AllDBs, "*", NoTTL, 10, -1, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil)
+	if !errors.Is(err, context.Canceled) || !strings.HasSuffix(b.String(), RedisCmdSerializer(IncompleteDumpMarker)+"\n") {
+		t.Errorf("expected the conversion to be interrupted, got %v: %q", err, b.String())
+	}
+
+	if err := ConvertRDB(context.Background(), strings.NewReader("SET a b\n"), AllDBs, "*", NoTTL, 10, -1, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil); err == nil {
+		t.Errorf("expected an error converting a file that is not an RDB file")
+	}
+}
@@ -0,0 +1,97 @@
+package redisdump
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// ageHeader starts the files encrypted with age
+var ageHeader = []byte("age-encryption.org/v1\n")
+
+// ErrEncryptedDump is returned when reading an encrypted dump without
+// identity to decrypt it
+var ErrEncryptedDump = errors.New("the dump is encrypted, an identity is required to decrypt it")
+
+// ParseRecipients parses the age recipients a dump is encrypted to, given
+// as public keys separated by commas, and as a file listing one public key
+// per line, as age -R reads them
+func ParseRecipients(recipients string, file string) ([]age.Recipient, error) {
+	var parsed []age.Recipient
+	for _, recipient := range strings.Split(recipients, ",") {
+		if recipient = strings.TrimSpace(recipient); recipient == "" {
+			continue
+		}
+		r, err := age.ParseX25519Recipient(recipient)
+		if err != nil {
+			return nil, err
+		}
+		parsed = append(parsed, r)
+	}
+
+	if file != "" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fileRecipients, err := age.ParseRecipients(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading recipients from %s: %w", file, err)
+		}
+		parsed = append(parsed, fileRecipients...)
+	}
+	return parsed, nil
+}
+
+// LoadIdentities reads the age identities dumps are decrypted with from
+// file, one secret key per line, as age -i reads them
+func LoadIdentities(file string) ([]age.Identity, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading identities from %s: %w", file, err)
+	}
+	return identities, nil
+}
+
+// NewEncryptedWriter encrypts what is written to it to w, as an age file
+// any of recipients can decrypt: the dump is encrypted and authenticated
+// in chunks of 64KiB, as it is written. Close writes the last chunk, but
+// does not close w.
+func NewEncryptedWriter(w io.Writer, recipients []age.Recipient) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nopWriteCloser{w}, nil
+	}
+	return age.Encrypt(w, recipients...)
+}
+
+// NewDecryptedReader reads r, decrypting it with identities if it is an age
+// file - it is read as it is otherwise. Reading fails once a chunk was
+// modified, or if the file was truncated.
+func NewDecryptedReader(r io.Reader, identities []age.Identity) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(ageHeader))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if !bytes.Equal(header, ageHeader) {
+		return br, nil
+	}
+
+	if len(identities) == 0 {
+		return nil, ErrEncryptedDump
+	}
+	return age.Decrypt(br, identities...)
+}
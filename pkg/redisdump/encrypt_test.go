@@ -0,0 +1,98 @@
+package redisdump
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestParseRecipients(t *testing.T) {
+	id1, _ := age.GenerateX25519Identity()
+	id2, _ := age.GenerateX25519Identity()
+	file := filepath.Join(t.TempDir(), "recipients.txt")
+	os.WriteFile(file, []byte("# backups\n"+id2.Recipient().String()+"\n"), 0600)
+
+	for i, testCase := range []struct {
+		recipients string
+		file       string
+		expected   int
+		err        bool
+	}{
+		{"", "", 0, false},
+		{id1.Recipient().String(), "", 1, false},
+		{id1.Recipient().String() + ", " + id2.Recipient().String(), "", 2, false},
+		{id1.Recipient().String(), file, 2, false},
+		{"", file, 1, false},
+		{"age1invalid", "", 0, true},
+		{"", filepath.Join(t.TempDir(), "missing.txt"), 0, true},
+	} {
+		recipients, err := ParseRecipients(testCase.recipients, testCase.file)
+		if (err != nil) != testCase.err || len(recipients) != testCase.expected {
+			t.Errorf("test %d: expected %d recipients (error %t), got %d (%v)", i, testCase.expected, testCase.err, len(recipients), err)
+		}
+	}
+}
+
+func TestEncryptedWriter(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	// The dump spans several chunks
+	dump := strings.Repeat(RESPSerializer([]string{"SET", "key", "value"}), 10000)
+
+	var b bytes.Buffer
+	w, err := NewEncryptedWriter(&b, []age.Recipient{other.Recipient(), id.Recipient()})
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	io.WriteString(w, dump)
+	if err := w.Close(); err != nil {
+		t.Fatalf("received error closing %+v", err)
+	}
+	encrypted := b.Bytes()
+	if bytes.Contains(encrypted, []byte(RESPSerializer([]string{"SET", "key", "value"}))) {
+		t.Errorf("expected the dump to be encrypted")
+	}
+
+	tampered := append([]byte{}, encrypted...)
+	tampered[len(tampered)-100] ^= 1
+
+	for i, testCase := range []struct {
+		input      []byte
+		identities []age.Identity
+		err        bool
+	}{
+		{encrypted, []age.Identity{id}, false},
+		{encrypted, []age.Identity{other}, false},
+		{encrypted, nil, true},
+		{tampered, []age.Identity{id}, true},
+		{encrypted[:len(encrypted)-100], []age.Identity{id}, true},
+		{[]byte(dump), nil, false},
+	} {
+		r, err := NewDecryptedReader(bytes.NewReader(testCase.input), testCase.identities)
+		var decrypted []byte
+		if err == nil {
+			decrypted, err = io.ReadAll(r)
+		}
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if err == nil && string(decrypted) != dump {
+			t.Errorf("test %d: the decrypted dump differs, %d bytes instead of %d", i, len(decrypted), len(dump))
+		}
+	}
+
+	if _, err := NewDecryptedReader(bytes.NewReader(encrypted), nil); !errors.Is(err, ErrEncryptedDump) {
+		t.Errorf("expected ErrEncryptedDump, got %v", err)
+	}
+	unknown, _ := age.GenerateX25519Identity()
+	if _, err := NewDecryptedReader(bytes.NewReader(encrypted), []age.Identity{unknown}); err == nil {
+		t.Errorf("expected an error decrypting with an unknown identity")
+	}
+}
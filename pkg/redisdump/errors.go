@@ -0,0 +1,156 @@
+package redisdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// KeyError is the error encountered when dumping a key
+type KeyError struct {
+	Db  uint8
+	Key string
+	Err error
+}
+
+func (e KeyError) Error() string {
+	return fmt.Sprintf("db %d: key %s: %s", e.Db, e.Key, e.Err)
+}
+
+func (e KeyError) Unwrap() error {
+	return e.Err
+}
+
+// maxKeyErrors is the number of failed keys the errors of a dump list:
+// the following ones are only counted
+const maxKeyErrors = 1000
+
+// DumpErrors lists the keys that could not be dumped. Failed counts them
+// by database, including those Keys does not list once it holds
+// maxKeyErrors keys. Aborted is set when the dump was interrupted after
+// too many errors.
+type DumpErrors struct {
+	Keys    []KeyError
+	Failed  map[uint8]int
+	Aborted bool
+}
+
+// add counts keyErr, and lists it in Keys unless max keys are listed
+// already. A negative max lists all keys.
+func (e *DumpErrors) add(keyErr KeyError, max int) {
+	if e.Failed == nil {
+		e.Failed = map[uint8]int{}
+	}
+	e.Failed[keyErr.Db]++
+	if max < 0 || len(e.Keys) < max {
+		e.Keys = append(e.Keys, keyErr)
+	}
+}
+
+// Total returns the number of keys that could not be dumped
+func (e *DumpErrors) Total() int {
+	n := 0
+	for _, failed := range e.Failed {
+		n += failed
+	}
+	return n
+}
+
+func (e *DumpErrors) Error() string {
+	msg := fmt.Sprintf("failed dumping %d keys", e.Total())
+	if e.Aborted {
+		msg += ", dump aborted after too many errors"
+	}
+
+	var errs []string
+	for i, keyErr := range e.Keys {
+		if i == 3 {
+			errs = append(errs, "...")
+			break
+		}
+		errs = append(errs, keyErr.Error())
+	}
+
+	return msg + ": " + strings.Join(errs, "; ")
+}
+
+// DumpCompleted returns whether a dump that returned err went through all
+// keys: either all keys were dumped, or some failed without the dump being
+// aborted
+func DumpCompleted(err error) bool {
+	var dumpErrs *DumpErrors
+	return err == nil || (errors.As(err, &dumpErrs) && !dumpErrs.Aborted)
+}
+
+// errorCollector gathers the keys that failed to be dumped, listing the
+// first maxKeyErrors of them. Once more than maxErrors keys failed, the
+// dump is cancelled. A negative maxErrors never cancels the dump.
+type errorCollector struct {
+	mu        sync.Mutex
+	maxErrors int
+	errs      DumpErrors
+	cancel    context.CancelFunc
+}
+
+func newErrorCollector(maxErrors int, cancel context.CancelFunc) *errorCollector {
+	return &errorCollector{
+		maxErrors: maxErrors,
+		cancel:    cancel,
+	}
+}
+
+func (c *errorCollector) add(keyErr KeyError) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.errs.add(keyErr, maxKeyErrors)
+	c.checkAbort()
+}
+
+// addErrors adds the keys that failed to be dumped before, as listed and
+// counted by errs
+func (c *errorCollector) addErrors(errs *DumpErrors) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, keyErr := range errs.Keys {
+		if len(c.errs.Keys) < maxKeyErrors {
+			c.errs.Keys = append(c.errs.Keys, keyErr)
+		}
+	}
+	if c.errs.Failed == nil {
+		c.errs.Failed = map[uint8]int{}
+	}
+	for db, failed := range errs.Failed {
+		c.errs.Failed[db] += failed
+	}
+	c.checkAbort()
+}
+
+// checkAbort cancels the dump once more than maxErrors keys failed. c.mu
+// must be held.
+func (c *errorCollector) checkAbort() {
+	if c.maxErrors >= 0 && c.errs.Total() > c.maxErrors && !c.errs.Aborted {
+		c.errs.Aborted = true
+		c.cancel()
+	}
+}
+
+// err returns the DumpErrors collected, or nil if all keys were dumped
+func (c *errorCollector) err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.errs.Total() == 0 {
+		return nil
+	}
+	errs := c.errs
+	errs.Keys = append([]KeyError{}, c.errs.Keys...)
+	errs.Failed = make(map[uint8]int, len(c.errs.Failed))
+	for db, failed := range c.errs.Failed {
+		errs.Failed[db] = failed
+	}
+	return &errs
+}
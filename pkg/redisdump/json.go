@@ -0,0 +1,179 @@
+package redisdump
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// JSONSerializer writes each key as a JSON object, on a line of its own
+// (JSON Lines). Keys holding strings that are not valid UTF-8 have their
+// name and all the strings of their value encoded in base64, and their
+// encoding set to "base64". Values read in chunks are written as several
+// objects, with "partial" set. Database switches are not written, the database
+// of each key is part of its object.
+type JSONSerializer struct{}
+
+// jsonKey is the JSON object written for a key
+type jsonKey struct {
+	Db       uint8       `json:"db"`
+	Key      string      `json:"key"`
+	Type     string      `json:"type"`
+	Partial  bool        `json:"partial,omitempty"`
+	Encoding string      `json:"encoding,omitempty"`
+	Value    interface{} `json:"value,omitempty"`
+	TTL      int64       `json:"ttl,omitempty"`
+	ExpireAt int64       `json:"expireAt,omitempty"`
+}
+
+// jsonScore is the score of a member of a sorted set. Scores are written
+// as JSON numbers, except for infinite scores, written as "inf" and "-inf".
+type jsonScore string
+
+// MarshalJSON implements the json.Marshaler interface.
+func (s jsonScore) MarshalJSON() ([]byte, error) {
+	f, err := strconv.ParseFloat(string(s), 64)
+	if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+		return json.Marshal(string(s))
+	}
+	return []byte(strconv.FormatFloat(f, 'g', -1, 64)), nil
+}
+
+type jsonZMember struct {
+	Member string    `json:"member"`
+	Score  jsonScore `json:"score"`
+}
+
+type jsonStreamEntry struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+type jsonStreamPendingEntry struct {
+	ID         string `json:"id"`
+	Consumer   string `json:"consumer"`
+	Idle       string `json:"idle"`
+	Deliveries string `json:"deliveries"`
+}
+
+type jsonStreamGroup struct {
+	Name            string                   `json:"name"`
+	LastDeliveredID string                   `json:"lastDeliveredId"`
+	EntriesRead     string                   `json:"entriesRead,omitempty"`
+	Consumers       []string                 `json:"consumers"`
+	Pending         []jsonStreamPendingEntry `json:"pending"`
+}
+
+type jsonStream struct {
+	Entries []jsonStreamEntry `json:"entries"`
+	LastID  string            `json:"lastId"`
+	Groups  []jsonStreamGroup `json:"groups"`
+}
+
+// jsonValue returns the JSON structure of the value of a key of type
+// keyType, with all strings passed through enc
+func jsonValue(keyType string, val interface{}, enc func(string) string) interface{} {
+	switch v := val.(type) {
+	case string:
+		return enc(v)
+
+	case []string:
+		if keyType == "zset" {
+			members := make([]jsonZMember, 0, len(v)/2)
+			for i := 0; i+1 < len(v); i += 2 {
+				members = append(members, jsonZMember{Member: enc(v[i]), Score: jsonScore(v[i+1])})
+			}
+			return members
+		}
+		elements := make([]string, 0, len(v))
+		for _, e := range v {
+			elements = append(elements, enc(e))
+		}
+		return elements
+
+	case map[string]string:
+		fields := make(map[string]string, len(v))
+		for f, fv := range v {
+			fields[enc(f)] = enc(fv)
+		}
+		return fields
+
+	case Stream:
+		s := jsonStream{LastID: v.LastID, Entries: []jsonStreamEntry{}, Groups: []jsonStreamGroup{}}
+		for _, e := range v.Entries {
+			fields := make([]string, 0, len(e.Fields))
+			for _, f := range e.Fields {
+				fields = append(fields, enc(f))
+			}
+			s.Entries = append(s.Entries, jsonStreamEntry{ID: e.ID, Fields: fields})
+		}
+		for _, g := range v.Groups {
+			group := jsonStreamGroup{Name: enc(g.Name), LastDeliveredID: g.LastDeliveredID, EntriesRead: g.EntriesRead, Consumers: []string{}, Pending: []jsonStreamPendingEntry{}}
+			for _, c := range g.Consumers {
+				group.Consumers = append(group.Consumers, enc(c))
+			}
+			for _, p := range g.Pending {
+				group.Pending = append(group.Pending, jsonStreamPendingEntry{ID: p.ID, Consumer: enc(p.Consumer), Idle: p.Idle, Deliveries: p.Deliveries})
+			}
+			s.Groups = append(s.Groups, group)
+		}
+		return s
+	}
+
+	return nil
+}
+
+// Key implements the Serializer interface. Keys holding neither a value
+// nor a TTL are not written. The chunks of values read in chunks, and the
+// TTL written after them, are written with "partial" set: their objects
+// are merged by db and key.
+func (s JSONSerializer) Key(k *Key) string {
+	if k.Value == nil && k.PTTL <= 0 {
+		return ""
+	}
+
+	valid := true
+	checkUTF8 := func(s string) string {
+		if !utf8.ValidString(s) {
+			valid = false
+		}
+		return s
+	}
+	obj := jsonKey{
+		Db:       k.Db,
+		Key:      checkUTF8(k.Name),
+		Type:     k.Type,
+		Partial:  k.Partial || k.endsChunks(),
+		Value:    jsonValue(k.Type, k.Value, checkUTF8),
+		TTL:      k.PTTL,
+		ExpireAt: k.ExpireAt,
+	}
+	if !valid {
+		obj.Key = base64.StdEncoding.EncodeToString([]byte(k.Name))
+		obj.Value = jsonValue(k.Type, k.Value, func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		})
+		obj.Encoding = "base64"
+	}
+
+	// Values are written as they are, without escaping HTML characters
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(obj); err != nil {
+		return ""
+	}
+	return string(bytes.TrimSuffix(buf.Bytes(), []byte("\n")))
+}
+
+// Cmd implements the Serializer interface. Only IncompleteDumpMarker is
+// written, as {"incomplete":true}.
+func (s JSONSerializer) Cmd(cmd []string) string {
+	if isIncompleteDumpMarker(cmd) {
+		return `{"incomplete":true}`
+	}
+	return ""
+}
@@ -0,0 +1,113 @@
+package redisdump
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"regexp"
+	"testing"
+)
+
+func TestJSONSerializer(t *testing.T) {
+	for i, testCase := range []struct {
+		key      Key
+		expected string
+	}{
+		{
+			Key{Db: 1, Name: "k", Type: "string", Value: "v"},
+			`{"db":1,"key":"k","type":"string","value":"v"}`,
+		},
+		{
+			Key{Name: "k", Type: "string", Value: "<a&b>", PTTL: 5000, ExpireAt: 1700000005000},
+			`{"db":0,"key":"k","type":"string","value":"<a&b>","ttl":5000,"expireAt":1700000005000}`,
+		},
+		{
+			Key{Name: "k", Type: "string", Value: ""},
+			`{"db":0,"key":"k","type":"string","value":""}`,
+		},
+		{
+			Key{Name: "k", Type: "string", Value: "\xff\xfe"},
+			`{"db":0,"key":"aw==","type":"string","encoding":"base64","value":"//4="}`,
+		},
+		{
+			Key{Name: "l", Type: "list", Value: []string{"a", "b", "a"}},
+			`{"db":0,"key":"l","type":"list","value":["a","b","a"]}`,
+		},
+		{
+			Key{Name: "s", Type: "set", Value: []string{"a", "\xff"}},
+			`{"db":0,"key":"cw==","type":"set","encoding":"base64","value":["YQ==","/w=="]}`,
+		},
+		{
+			Key{Name: "h", Type: "hash", Value: map[string]string{"f2": "v2", "f1": "v1"}},
+			`{"db":0,"key":"h","type":"hash","value":{"f1":"v1","f2":"v2"}}`,
+		},
+		{
+			Key{Name: "z", Type: "zset", Value: []string{"a", "1", "b", "2.5", "c", "inf", "d", "-inf"}},
+			`{"db":0,"key":"z","type":"zset","value":[{"member":"a","score":1},{"member":"b","score":2.5},{"member":"c","score":"inf"},{"member":"d","score":"-inf"}]}`,
+		},
+		{
+			Key{Name: "x", Type: "stream", Value: Stream{
+				Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1", "a", "2"}}},
+				LastID:  "1-1",
+				Groups: []StreamGroup{
+					{Name: "g", LastDeliveredID: "1-1", EntriesRead: "1", Consumers: []string{"c", "idle"}, Pending: []StreamPendingEntry{{ID: "1-1", Consumer: "c", Idle: "10", Deliveries: "1"}}},
+				},
+			}},
+			`{"db":0,"key":"x","type":"stream","value":{"entries":[{"id":"1-1","fields":["a","1","a","2"]}],"lastId":"1-1","groups":[{"name":"g","lastDeliveredId":"1-1","entriesRead":"1","consumers":["c","idle"],"pending":[{"id":"1-1","consumer":"c","idle":"10","deliveries":"1"}]}]}}`,
+		},
+		{
+			Key{Name: "x", Type: "stream", Value: Stream{LastID: "5-0"}},
+			`{"db":0,"key":"x","type":"stream","value":{"entries":[],"lastId":"5-0","groups":[]}}`,
+		},
+		{
+			// The chunks of a value read in chunks, and its TTL
+			Key{Name: "l", Type: "list", Value: []string{"a", "b"}, Partial: true},
+			`{"db":0,"key":"l","type":"list","partial":true,"value":["a","b"]}`,
+		},
+		{
+			Key{Name: "h", Type: "hash", Value: map[string]string{"f": "\xff"}, Partial: true},
+			`{"db":0,"key":"aA==","type":"hash","partial":true,"encoding":"base64","value":{"Zg==":"/w=="}}`,
+		},
+		{
+			Key{Name: "l", Type: "list", PTTL: 5000, ExpireAt: 1700000005000},
+			`{"db":0,"key":"l","type":"list","partial":true,"ttl":5000,"expireAt":1700000005000}`,
+		},
+		{
+			Key{Name: "l", Type: "list"},
+			``,
+		},
+	} {
+		if s := (JSONSerializer{}).Key(&testCase.key); s != testCase.expected {
+			t.Errorf("test %d: expected %s, got %s", i, testCase.expected, s)
+		}
+	}
+}
+
+func TestJSONSerializerCmd(t *testing.T) {
+	s := JSONSerializer{}
+	if out := s.Cmd([]string{"SELECT", "1"}); out != "" {
+		t.Errorf("expected SELECT not to be written, got %s", out)
+	}
+	if out := s.Cmd(IncompleteDumpMarker); out != `{"incomplete":true}` {
+		t.Errorf("unexpected incomplete dump marker %s", out)
+	}
+}
+
+func TestDumpKeysJSON(t *testing.T) {
+	var m mockRadixClient
+	var b bytes.Buffer
+	l := log.New(&b, "", 0)
+	err := dumpKeys(context.Background(), &m, getMockRadixAction, 3, []string{"somestring", "somehugezset"}, DumpOptions{TTLMode: RelativeTTL, BatchSize: 5, ChunkThreshold: 2}, l, JSONSerializer{})
+	if err != nil {
+		t.Errorf("received error %+v", err)
+	}
+
+	expectMatch := `^{"db":3,"key":"somestring","type":"string","value":"stringvalue","ttl":5000,"expireAt":[0-9]{13}}
+{"db":3,"key":"somehugezset","type":"zset","partial":true,"value":\[{"member":"member1","score":1},{"member":"member2","score":2}\]}
+{"db":3,"key":"somehugezset","type":"zset","partial":true,"value":\[{"member":"member3","score":3}\]}
+{"db":3,"key":"somehugezset","type":"zset","partial":true,"ttl":5000,"expireAt":[0-9]{13}}
+$`
+	if match, _ := regexp.MatchString(expectMatch, b.String()); !match {
+		t.Errorf("expected to match %s, got %s", expectMatch, b.String())
+	}
+}
@@ -0,0 +1,224 @@
+package redisdump
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ManifestFile is a file of a dump, as it was written: compressed and
+// encrypted, if it was
+type ManifestFile struct {
+	// Name is the name of the file, empty for a dump written to the
+	// standard output
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// DbManifest counts the keys dumped from a database
+type DbManifest struct {
+	Keys int `json:"keys"`
+	// Types counts the keys by type. Keys dumped as DUMP payloads are
+	// counted in Keys only, their type is not read.
+	Types map[string]int `json:"types"`
+	// Skipped is the number of keys found by the scan of the database,
+	// but not dumped: they were deleted or expired before being read, or
+	// the dump was aborted after too many errors
+	Skipped int `json:"skipped"`
+	Errors  int `json:"errors"`
+}
+
+// Manifest describes a dump, so it can be verified before it is restored
+type Manifest struct {
+	Version string `json:"version"`
+	// Source is the server dumped, as host:port, or the RDB file converted
+	Source       string                `json:"source"`
+	RedisVersion string                `json:"redisVersion,omitempty"`
+	StartTime    time.Time             `json:"startTime"`
+	EndTime      time.Time             `json:"endTime"`
+	Output       string                `json:"output"`
+	Filter       string                `json:"filter"`
+	Databases    map[uint8]*DbManifest `json:"databases"`
+	Keys         int                   `json:"keys"`
+	Skipped      int                   `json:"skipped"`
+	Errors       int                   `json:"errors"`
+	Bytes        int64                 `json:"bytes"`
+	Files        []ManifestFile        `json:"files"`
+}
+
+// LoadManifest reads a manifest written by Manifest.Save
+func LoadManifest(r io.Reader) (*Manifest, error) {
+	m := &Manifest{}
+	if err := json.NewDecoder(r).Decode(m); err != nil {
+		return nil, fmt.Errorf("failed reading manifest: %w", err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to the file name, created with create
+func (m *Manifest) Save(name string, create func(name string) (Output, error)) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	out, err := create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(data, '\n')); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
+
+// ChecksumWriter computes the size and SHA-256 of what is written to it,
+// and writes it to w
+type ChecksumWriter struct {
+	w    io.Writer
+	hash hash.Hash
+	size int64
+}
+
+// NewChecksumWriter returns a ChecksumWriter writing to w
+func NewChecksumWriter(w io.Writer) *ChecksumWriter {
+	return &ChecksumWriter{w: w, hash: sha256.New()}
+}
+
+func (c *ChecksumWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.size += int64(n)
+	return n, err
+}
+
+// File returns the ManifestFile of the file name, once written
+func (c *ChecksumWriter) File(name string) ManifestFile {
+	return ManifestFile{Name: name, Size: c.size, SHA256: hex.EncodeToString(c.hash.Sum(nil))}
+}
+
+// VerifyFile checks that the file r reads has the size and the SHA-256 of
+// f
+func VerifyFile(r io.Reader, f ManifestFile) error {
+	c := NewChecksumWriter(io.Discard)
+	if _, err := io.Copy(c, r); err != nil {
+		return err
+	}
+	read := c.File(f.Name)
+	if read.Size != f.Size {
+		return fmt.Errorf("expected %d bytes, read %d", f.Size, read.Size)
+	}
+	if read.SHA256 != f.SHA256 {
+		return fmt.Errorf("SHA-256 mismatch, expected %s, got %s", f.SHA256, read.SHA256)
+	}
+	return nil
+}
+
+// ManifestSerializer counts the keys serialized with Serializer, by
+// database and by type
+type ManifestSerializer struct {
+	Serializer
+
+	mu  sync.Mutex
+	dbs map[uint8]*DbManifest
+}
+
+// NewManifestSerializer counts the keys serialized with s
+func NewManifestSerializer(s Serializer) *ManifestSerializer {
+	return &ManifestSerializer{Serializer: s, dbs: map[uint8]*DbManifest{}}
+}
+
+// db returns the counts of db. s.mu must be held.
+func (s *ManifestSerializer) db(db uint8) *DbManifest {
+	if _, ok := s.dbs[db]; !ok {
+		s.dbs[db] = &DbManifest{Types: map[string]int{}}
+	}
+	return s.dbs[db]
+}
+
+// Key implements the Serializer interface. Values read in chunks are
+// counted once, with their last Key.
+func (s *ManifestSerializer) Key(k *Key) string {
+	if !k.Partial {
+		s.mu.Lock()
+		db := s.db(k.Db)
+		db.Keys++
+		if k.Type != "" {
+			db.Types[k.Type]++
+		}
+		s.mu.Unlock()
+	}
+	return s.Serializer.Key(k)
+}
+
+// Cmd implements the Serializer interface. SELECT lists the database in
+// the manifest, even if it holds no key.
+func (s *ManifestSerializer) Cmd(cmd []string) string {
+	if len(cmd) == 2 && strings.ToUpper(cmd[0]) == "SELECT" {
+		var db uint8
+		if _, err := fmt.Sscan(cmd[1], &db); err == nil {
+			s.mu.Lock()
+			s.db(db)
+			s.mu.Unlock()
+		}
+	}
+	return s.Serializer.Cmd(cmd)
+}
+
+// Count sets the keys serialized in m, by database. scanned is the number
+// of keys the scan of each database found, and failed the number of keys
+// that failed to be dumped, as counted by DumpErrors.
+func (s *ManifestSerializer) Count(m *Manifest, scanned map[uint8]int, failed map[uint8]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for db, n := range failed {
+		s.db(db).Errors += n
+	}
+	m.Databases = s.dbs
+	for n, db := range s.dbs {
+		if skipped := scanned[n] - db.Keys - db.Errors; skipped > 0 {
+			db.Skipped = skipped
+		}
+		m.Keys += db.Keys
+		m.Skipped += db.Skipped
+		m.Errors += db.Errors
+	}
+}
+
+// parseServerVersion returns the redis_version of the reply to INFO
+// server
+func parseServerVersion(info string) string {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		if version, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "redis_version:"); ok {
+			return version
+		}
+	}
+	return ""
+}
+
+// ServerVersion returns the version of the server s
+func ServerVersion(s Host) (string, error) {
+	conn, err := getConnFunc(s, nil)("tcp", RedisURL(s.Host, fmt.Sprint(s.Port)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var info string
+	if err := conn.Do(radix.Cmd(&info, "INFO", "server")); err != nil {
+		return "", err
+	}
+	return parseServerVersion(info), nil
+}
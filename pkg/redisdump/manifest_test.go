@@ -0,0 +1,132 @@
+package redisdump
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyFile(t *testing.T) {
+	dump := strings.Repeat(RESPSerializer([]string{"SET", "key", "value"}), 100)
+	c := NewChecksumWriter(&strings.Builder{})
+	c.Write([]byte(dump))
+	f := c.File("dump.resp")
+	if f.Size != int64(len(dump)) || len(f.SHA256) != 64 {
+		t.Fatalf("unexpected file %+v", f)
+	}
+
+	for i, testCase := range []struct {
+		content string
+		err     bool
+	}{
+		{dump, false},
+		{dump[:len(dump)-1], true},
+		{dump + "\n", true},
+		{strings.Replace(dump, "value", "VALUE", 1), true},
+	} {
+		if err := VerifyFile(strings.NewReader(testCase.content), f); (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+	}
+}
+
+func TestManifestSerializer(t *testing.T) {
+	s := NewManifestSerializer(CmdSerializer(RedisCmdSerializer))
+	s.Cmd([]string{"SELECT", "0"})
+	s.Key(&Key{Db: 0, Name: "a", Type: "string", Cmds: [][]string{{"SET", "a", "1"}}})
+	s.Key(&Key{Db: 0, Name: "l", Type: "list", Partial: true, Cmds: [][]string{{"RPUSH", "l", "x"}}})
+	s.Key(&Key{Db: 0, Name: "l", Type: "list", Partial: true, Cmds: [][]string{{"RPUSH", "l", "y"}}})
+	s.Key(&Key{Db: 0, Name: "l", Type: "list"})
+	s.Cmd([]string{"SELECT", "1"})
+	// DUMP payloads have no type
+	s.Key(&Key{Db: 1, Name: "b", Cmds: [][]string{{"RESTORE", "b", "0", "payload"}}})
+	s.Cmd([]string{"SELECT", "2"})
+
+	m := &Manifest{}
+	s.Count(m, map[uint8]int{0: 5, 1: 1}, map[uint8]int{0: 1})
+	expected := map[uint8]*DbManifest{
+		0: {Keys: 2, Types: map[string]int{"string": 1, "list": 1}, Skipped: 2, Errors: 1},
+		1: {Keys: 1, Types: map[string]int{}},
+		2: {Types: map[string]int{}},
+	}
+	if !reflect.DeepEqual(m.Databases, expected) {
+		t.Errorf("expected %+v, got %+v", expected, m.Databases)
+	}
+	if m.Keys != 3 || m.Skipped != 2 || m.Errors != 1 {
+		t.Errorf("expected 3 keys, 2 skipped, 1 error, got %d, %d, %d", m.Keys, m.Skipped, m.Errors)
+	}
+}
+
+func TestManifestSave(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSerializer(NewManifestSerializer(CmdSerializer(RedisCmdSerializer)), filepath.Join(dir, "dump-db{db}.txt"), 0, CreateLocalFile, plainWriter)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	s.Key(&Key{Db: 1, Name: "b", Type: "string", Cmds: [][]string{{"SET", "b", "2"}}})
+	s.Key(&Key{Db: 0, Name: "a", Type: "string", Cmds: [][]string{{"SET", "a", "1"}}})
+	if err := s.Close(); err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+
+	m := &Manifest{
+		Version:   "v1.0.0",
+		Source:    "127.0.0.1:6379",
+		StartTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndTime:   time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC),
+		Output:    "commands",
+		Filter:    "*",
+		Databases: map[uint8]*DbManifest{0: {Keys: 1, Types: map[string]int{"string": 1}}},
+		Keys:      1,
+		Files:     s.Files(),
+	}
+	if len(m.Files) != 2 || m.Files[0].Name != filepath.Join(dir, "dump-db0.txt") || m.Files[1].Name != filepath.Join(dir, "dump-db1.txt") {
+		t.Fatalf("unexpected files %+v", m.Files)
+	}
+	path := filepath.Join(dir, "manifest.json")
+	if err := m.Save(path, CreateLocalFile); err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	defer f.Close()
+	loaded, err := LoadManifest(f)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	if !reflect.DeepEqual(loaded, m) {
+		t.Errorf("expected %+v, got %+v", m, loaded)
+	}
+	for _, file := range loaded.Files {
+		in, _ := os.Open(file.Name)
+		if err := VerifyFile(in, file); err != nil {
+			t.Errorf("%s: %v", file.Name, err)
+		}
+		in.Close()
+	}
+
+	if _, err := LoadManifest(strings.NewReader("SET a 1\n")); err == nil {
+		t.Errorf("expected an error reading an invalid manifest")
+	}
+}
+
+func TestParseServerVersion(t *testing.T) {
+	for i, testCase := range []struct {
+		info     string
+		expected string
+	}{
+		{"# Server\r\nredis_version:7.2.4\r\nredis_git_sha1:00000000\r\n", "7.2.4"},
+		{"# Server\r\nredis_git_sha1:00000000\r\n", ""},
+		{"", ""},
+	} {
+		if version := parseServerVersion(testCase.info); version != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, version)
+		}
+	}
+}
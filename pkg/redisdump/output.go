@@ -0,0 +1,446 @@
+package redisdump
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+)
+
+// NewOutputWriter compresses what is written to it with compression, using
+// up to nWorkers goroutines, then encrypts it to recipients, if any, and
+// writes it to w. Close ends the compressed and encrypted streams, but does
+// not close w.
+func NewOutputWriter(w io.Writer, compression Compression, nWorkers int, recipients []age.Recipient) (io.WriteCloser, error) {
+	encrypted, err := NewEncryptedWriter(w, recipients)
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := NewCompressedWriter(encrypted, compression, nWorkers)
+	if err != nil {
+		return nil, err
+	}
+	return &outputWriter{Writer: compressed, compressed: compressed, encrypted: encrypted}, nil
+}
+
+type outputWriter struct {
+	io.Writer
+	compressed io.Closer
+	encrypted  io.Closer
+}
+
+func (o *outputWriter) Close() error {
+	if err := o.compressed.Close(); err != nil {
+		return fmt.Errorf("failed compressing output: %w", err)
+	}
+	if err := o.encrypted.Close(); err != nil {
+		return fmt.Errorf("failed encrypting output: %w", err)
+	}
+	return nil
+}
+
+// Output is where a file of a dump is written to. It is only visible once
+// committed: a failed dump never leaves a file that looks complete.
+type Output interface {
+	io.Writer
+	// Commit completes the file
+	Commit() error
+	// Abort discards what was written
+	Abort()
+	// Remove deletes the file once committed
+	Remove() error
+}
+
+// localFile is written to a temporary file next to it, renamed once
+// committed
+type localFile struct {
+	path string
+	tmp  *os.File
+}
+
+// CreateLocalFile creates the file path on the local filesystem
+func CreateLocalFile(path string) (Output, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{path: path, tmp: tmp}, nil
+}
+
+func (f *localFile) Write(p []byte) (int, error) {
+	return f.tmp.Write(p)
+}
+
+// Commit implements the Output interface.
+func (f *localFile) Commit() error {
+	err := f.tmp.Sync()
+	if closeErr := f.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.tmp.Name(), f.path)
+	}
+	if err != nil {
+		os.Remove(f.tmp.Name())
+	}
+	return err
+}
+
+// Abort implements the Output interface.
+func (f *localFile) Abort() {
+	f.tmp.Close()
+	os.Remove(f.tmp.Name())
+}
+
+// Remove implements the Output interface.
+func (f *localFile) Remove() error {
+	return os.Remove(f.path)
+}
+
+// OutputFile is a file a dump is written to, through a writer that can
+// compress or encrypt it
+type OutputFile struct {
+	name string
+	out  Output
+	w    io.WriteCloser
+	// sum counts the bytes written to out
+	sum      *ChecksumWriter
+	finished bool
+}
+
+// CreateOutputFile creates the file name with create, written through the
+// writer newWriter returns
+func CreateOutputFile(name string, create func(name string) (Output, error), newWriter func(io.Writer) (io.WriteCloser, error)) (*OutputFile, error) {
+	out, err := create(name)
+	if err != nil {
+		return nil, err
+	}
+	f := &OutputFile{name: name, out: out, sum: NewChecksumWriter(out)}
+	if f.w, err = newWriter(f.sum); err != nil {
+		out.Abort()
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *OutputFile) Write(p []byte) (int, error) {
+	return f.w.Write(p)
+}
+
+// finish ends what is written to the file, such as its compressed stream
+func (f *OutputFile) finish() error {
+	if f.finished {
+		return nil
+	}
+	f.finished = true
+	if err := f.w.Close(); err != nil {
+		return fmt.Errorf("failed writing %s: %w", f.name, err)
+	}
+	return nil
+}
+
+// Commit completes the file
+func (f *OutputFile) Commit() error {
+	if err := f.finish(); err != nil {
+		f.out.Abort()
+		return err
+	}
+	if err := f.out.Commit(); err != nil {
+		return fmt.Errorf("failed writing %s: %w", f.name, err)
+	}
+	return nil
+}
+
+// Abort discards the file
+func (f *OutputFile) Abort() {
+	f.out.Abort()
+}
+
+// Remove deletes the file once committed
+func (f *OutputFile) Remove() error {
+	if err := f.out.Remove(); err != nil {
+		return fmt.Errorf("failed removing %s: %w", f.name, err)
+	}
+	return nil
+}
+
+// File returns the size and SHA-256 of the file, once committed
+func (f *OutputFile) File() ManifestFile {
+	return f.sum.File(f.name)
+}
+
+// outputFile is a file of a FileSerializer
+type outputFile struct {
+	*OutputFile
+	// db is the database the file belongs to, or -1 if the template does
+	// not split the dump by database
+	db int
+	// selected is the database selected in the file, -1 before the first
+	// SELECT
+	selected int
+}
+
+// FileSerializer writes a dump serialized with Serializer to files, rather
+// than to the logger: the name of the files is a template, where {db} is
+// replaced with the database the keys were read from - each database is
+// written to its own file - and {n} with the number of the file, starting
+// at 0, once files reach their maximum size. Each file selects its
+// database, so files can be restored in any order, or in parallel. Key
+// and Cmd always return an empty string.
+type FileSerializer struct {
+	Serializer
+	template  string
+	maxSize   int64
+	create    func(name string) (Output, error)
+	newWriter func(io.Writer) (io.WriteCloser, error)
+
+	mu sync.Mutex
+	// files are the files written to, by database, or -1 if the template
+	// does not split the dump by database
+	files map[int]*outputFile
+	// complete are the files that reached their maximum size
+	complete []*outputFile
+	n        map[int]int
+	// db is the database selected by the last SELECT passed to Cmd
+	db uint8
+	// partial are the keys whose first chunks were written, but not their
+	// TTL: files are only rotated once all chunks of a value were written
+	partial map[conflictKey]bool
+	// scripts are the scripts loaded with SCRIPT LOAD, written at the start
+	// of each file before the commands running them
+	scripts []string
+	err     error
+}
+
+// NewFileSerializer writes the dump serialized with s to files named after
+// template, created with create and written through the writer newWriter
+// returns. If maxSize is
+// more than 0, a file is completed once its size exceeds maxSize bytes,
+// and the dump continues in the next one: the template must then contain
+// {n}. Files are only committed by Close.
+func NewFileSerializer(s Serializer, template string, maxSize int64, create func(name string) (Output, error), newWriter func(io.Writer) (io.WriteCloser, error)) (*FileSerializer, error) {
+	if maxSize > 0 && !strings.Contains(template, "{n}") {
+		return nil, fmt.Errorf("files can only be split by size if their name contains {n}: %s", template)
+	}
+	return &FileSerializer{
+		Serializer: s,
+		template:   template,
+		maxSize:    maxSize,
+		create:     create,
+		newWriter:  newWriter,
+		files:      map[int]*outputFile{},
+		n:          map[int]int{},
+		partial:    map[conflictKey]bool{},
+	}, nil
+}
+
+// fileDB returns the database of the file the keys of db are written to
+func (s *FileSerializer) fileDB(db uint8) int {
+	if strings.Contains(s.template, "{db}") {
+		return int(db)
+	}
+	return -1
+}
+
+// file returns the file the keys of db are written to
+func (s *FileSerializer) file(db uint8) (*outputFile, error) {
+	fileDB := s.fileDB(db)
+	if f, ok := s.files[fileDB]; ok {
+		return f, nil
+	}
+
+	name := strings.ReplaceAll(s.template, "{db}", fmt.Sprint(db))
+	name = strings.ReplaceAll(name, "{n}", fmt.Sprint(s.n[fileDB]))
+	f, err := CreateOutputFile(name, s.create, s.newWriter)
+	if err != nil {
+		return nil, err
+	}
+	s.n[fileDB]++
+	s.files[fileDB] = &outputFile{OutputFile: f, db: fileDB, selected: -1}
+	for _, script := range s.scripts {
+		if _, err := io.WriteString(f, line(script)); err != nil {
+			return nil, fmt.Errorf("failed writing %s: %w", f.name, err)
+		}
+	}
+	return s.files[fileDB], nil
+}
+
+// write writes out to the file of db, selecting db in the file first
+func (s *FileSerializer) write(db uint8, out string) {
+	if s.err != nil || out == "" {
+		return
+	}
+	f, err := s.file(db)
+	if err != nil {
+		s.err = err
+		return
+	}
+	if f.selected != int(db) {
+		if selectCmd := s.Serializer.Cmd([]string{"SELECT", fmt.Sprint(db)}); selectCmd != "" {
+			out = line(selectCmd) + out
+		}
+		f.selected = int(db)
+	}
+	if _, err := io.WriteString(f, line(out)); err != nil {
+		s.err = fmt.Errorf("failed writing %s: %w", f.name, err)
+	}
+}
+
+// line terminates out with a newline, as the logger does
+func line(out string) string {
+	if strings.HasSuffix(out, "\n") {
+		return out
+	}
+	return out + "\n"
+}
+
+// rotate completes the file of db once it exceeds its maximum size, unless
+// the chunks of a value are still being written to it
+func (s *FileSerializer) rotate(db uint8) {
+	f, ok := s.files[s.fileDB(db)]
+	// The size only counts the bytes written past the buffers of the
+	// compression
+	if s.maxSize <= 0 || !ok || f.sum.size < s.maxSize {
+		return
+	}
+	for k := range s.partial {
+		if f.db == -1 || f.db == int(k.db) {
+			return
+		}
+	}
+
+	delete(s.files, f.db)
+	s.complete = append(s.complete, f)
+	if err := f.finish(); err != nil && s.err == nil {
+		s.err = fmt.Errorf("failed writing %s: %w", f.name, err)
+	}
+}
+
+// Key implements the Serializer interface.
+func (s *FileSerializer) Key(k *Key) string {
+	out := s.Serializer.Key(k)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ck := conflictKey{db: k.Db, name: k.Name}
+	if k.Partial {
+		s.partial[ck] = true
+	} else {
+		delete(s.partial, ck)
+	}
+	s.write(k.Db, out)
+	s.rotate(k.Db)
+	return ""
+}
+
+// Cmd implements the Serializer interface. SELECT selects the file the
+// following commands are written to, and SCRIPT LOAD is written to all
+// files, so each file can be restored on its own.
+func (s *FileSerializer) Cmd(cmd []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(cmd) == 2 && strings.ToUpper(cmd[0]) == "SELECT" {
+		if db, err := strconv.ParseUint(cmd[1], 10, 8); err == nil {
+			s.db = uint8(db)
+			return ""
+		}
+	}
+	if isScriptLoad(cmd) {
+		script := s.Serializer.Cmd(cmd)
+		s.scripts = append(s.scripts, script)
+		for _, f := range s.files {
+			if _, err := io.WriteString(f, line(script)); err != nil && s.err == nil {
+				s.err = fmt.Errorf("failed writing %s: %w", f.name, err)
+			}
+		}
+		return ""
+	}
+	s.write(s.db, s.Serializer.Cmd(cmd))
+	return ""
+}
+
+// allFiles returns the files written to
+func (s *FileSerializer) allFiles() []*outputFile {
+	files := append([]*outputFile{}, s.complete...)
+	for _, f := range s.files {
+		files = append(files, f)
+	}
+	return files
+}
+
+// Close completes all files, then commits them. The error returned is the
+// first one that occurred writing or committing the files, if any: the
+// files are then removed, those already committed included, so a dump is
+// only left complete.
+func (s *FileSerializer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	files := s.allFiles()
+	err := s.err
+	for _, f := range files {
+		if err == nil {
+			err = f.finish()
+		}
+	}
+	if err != nil {
+		for _, f := range files {
+			f.Abort()
+		}
+		return err
+	}
+
+	for i, f := range files {
+		if err := f.Commit(); err != nil {
+			for _, committed := range files[:i] {
+				committed.Remove()
+			}
+			for _, rest := range files[i+1:] {
+				rest.Abort()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Finish commits the files with Close if the dump that returned dumpErr
+// completed, and removes them with Abort otherwise: the files of a dump
+// aborted after too many errors are not left behind
+func (s *FileSerializer) Finish(dumpErr error) error {
+	if !DumpCompleted(dumpErr) {
+		s.Abort()
+		return nil
+	}
+	return s.Close()
+}
+
+// Files returns the files written, by name, once committed by Close
+func (s *FileSerializer) Files() []ManifestFile {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []ManifestFile
+	for _, f := range s.allFiles() {
+		files = append(files, f.File())
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// Abort removes the files, once the dump failed
+func (s *FileSerializer) Abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, f := range s.allFiles() {
+		f.Abort()
+	}
+}
@@ -0,0 +1,252 @@
+package redisdump
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func plainWriter(w io.Writer) (io.WriteCloser, error) {
+	return nopWriteCloser{w}, nil
+}
+
+// readDir returns the content of the files of dir, by name
+func readDir(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed reading %s: %v", dir, err)
+	}
+	files := map[string]string{}
+	for _, e := range entries {
+		b, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+func TestFileSerializer(t *testing.T) {
+	for i, testCase := range []struct {
+		template string
+		maxSize  int64
+		expected map[string]string
+	}{
+		{
+			"dump.txt",
+			0,
+			map[string]string{
+				"dump.txt": "SELECT 0\nSET a 1\nRPUSH l x\nRPUSH l y\nSELECT 1\nSET b 2\nSELECT 0\nDEL a\n",
+			},
+		},
+		{
+			"dump-db{db}.txt",
+			0,
+			map[string]string{
+				"dump-db0.txt": "SELECT 0\nSET a 1\nRPUSH l x\nRPUSH l y\nDEL a\n",
+				"dump-db1.txt": "SELECT 1\nSET b 2\n",
+			},
+		},
+		{
+			// Files are only rotated once the chunks of the list were all
+			// written, and select their database
+			"dump-{n}.txt",
+			1,
+			map[string]string{
+				"dump-0.txt": "SELECT 0\nSET a 1\n",
+				"dump-1.txt": "SELECT 0\nRPUSH l x\nRPUSH l y\n",
+				"dump-2.txt": "SELECT 1\nSET b 2\n",
+				"dump-3.txt": "SELECT 0\nDEL a\n",
+			},
+		},
+		{
+			"dump-db{db}-{n}.txt",
+			1,
+			map[string]string{
+				"dump-db0-0.txt": "SELECT 0\nSET a 1\n",
+				"dump-db0-1.txt": "SELECT 0\nRPUSH l x\nRPUSH l y\n",
+				"dump-db1-0.txt": "SELECT 1\nSET b 2\n",
+				"dump-db0-2.txt": "SELECT 0\nDEL a\n",
+			},
+		},
+	} {
+		dir := t.TempDir()
+		s, err := NewFileSerializer(CmdSerializer(RedisCmdSerializer), filepath.Join(dir, testCase.template), testCase.maxSize, CreateLocalFile, plainWriter)
+		if err != nil {
+			t.Fatalf("test %d: received error %+v", i, err)
+		}
+
+		s.Cmd([]string{"SELECT", "0"})
+		s.Key(&Key{Db: 0, Name: "a", Cmds: [][]string{{"SET", "a", "1"}}})
+		s.Key(&Key{Db: 0, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "x"}}})
+		s.Key(&Key{Db: 0, Name: "l", Partial: true, Cmds: [][]string{{"RPUSH", "l", "y"}}})
+		s.Key(&Key{Db: 0, Name: "l"})
+		s.Cmd([]string{"SELECT", "1"})
+		s.Key(&Key{Db: 1, Name: "b", Cmds: [][]string{{"SET", "b", "2"}}})
+		s.Cmd([]string{"SELECT", "0"})
+		s.Cmd([]string{"DEL", "a"})
+
+		// Files are only created once the dump completed
+		for name := range readDir(t, dir) {
+			if !strings.HasSuffix(name, ".tmp") {
+				t.Errorf("test %d: expected only temporary files before Close, found %s", i, name)
+			}
+		}
+
+		if err := s.Close(); err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+		}
+		if files := readDir(t, dir); !reflect.DeepEqual(files, testCase.expected) {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, files)
+		}
+	}
+
+	if _, err := NewFileSerializer(CmdSerializer(RedisCmdSerializer), "dump.txt", 1, CreateLocalFile, plainWriter); err == nil {
+		t.Errorf("expected an error splitting files by size without {n}")
+	}
+}
+
+func TestFileSerializerScripts(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSerializer(CmdSerializer(RedisCmdSerializer), filepath.Join(dir, "dump-db{db}.txt"), 0, CreateLocalFile, plainWriter)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	s.Cmd([]string{"SELECT", "0"})
+	s.Cmd([]string{"SCRIPT", "LOAD", "return 1"})
+	s.Key(&Key{Db: 0, Name: "a", Cmds: [][]string{{"SET", "a", "1"}}})
+	s.Cmd([]string{"SELECT", "1"})
+	s.Key(&Key{Db: 1, Name: "b", Cmds: [][]string{{"SET", "b", "2"}}})
+	if err := s.Close(); err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+
+	// Each file loads the script
+	expected := map[string]string{
+		"dump-db0.txt": "SCRIPT LOAD \"return 1\"\nSELECT 0\nSET a 1\n",
+		"dump-db1.txt": "SCRIPT LOAD \"return 1\"\nSELECT 1\nSET b 2\n",
+	}
+	if files := readDir(t, dir); !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %q, got %q", expected, files)
+	}
+}
+
+func TestFileSerializerAbort(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileSerializer(CmdSerializer(RESPSerializer), filepath.Join(dir, "dump-{n}.resp"), 1, CreateLocalFile, plainWriter)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		s.Key(&Key{Db: 0, Name: name, Cmds: [][]string{{"SET", name, "1"}}})
+	}
+	s.Abort()
+
+	if files := readDir(t, dir); len(files) != 0 {
+		t.Errorf("expected the files to be removed, found %d", len(files))
+	}
+}
+
+// failingCommit fails to commit the file name
+type failingCommit struct {
+	Output
+	name string
+}
+
+func (f *failingCommit) Commit() error {
+	f.Output.Abort()
+	return fmt.Errorf("failed committing %s", f.name)
+}
+
+func TestFileSerializerCommitError(t *testing.T) {
+	dir := t.TempDir()
+	create := func(name string) (Output, error) {
+		out, err := CreateLocalFile(name)
+		if err != nil || filepath.Base(name) != "dump-db1.txt" {
+			return out, err
+		}
+		return &failingCommit{Output: out, name: name}, nil
+	}
+	s, err := NewFileSerializer(CmdSerializer(RedisCmdSerializer), filepath.Join(dir, "dump-db{db}.txt"), 0, create, plainWriter)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	for db := uint8(0); db < 3; db++ {
+		s.Key(&Key{Db: db, Name: "a", Cmds: [][]string{{"SET", "a", "1"}}})
+	}
+
+	// The files committed before the one that failed are removed
+	if err := s.Close(); err == nil || !strings.Contains(err.Error(), "dump-db1.txt") {
+		t.Errorf("expected the error committing dump-db1.txt, got %v", err)
+	}
+	if files := readDir(t, dir); len(files) != 0 {
+		t.Errorf("expected the files to be removed, found %q", files)
+	}
+}
+
+func TestFileSerializerFinish(t *testing.T) {
+	for i, testCase := range []struct {
+		maxErrors int
+		nFiles    int
+	}{
+		// Keys failed without aborting the dump: the file is committed
+		{-1, 1},
+		// The dump was aborted after too many errors: the file is removed
+		{0, 0},
+	} {
+		dir := t.TempDir()
+		s, err := NewFileSerializer(CmdSerializer(RedisCmdSerializer), filepath.Join(dir, "dump.txt"), 0, CreateLocalFile, plainWriter)
+		if err != nil {
+			t.Fatalf("received error %+v", err)
+		}
+
+		var m mockRadixClient
+		l := log.New(io.Discard, "", 0)
+		collector := newErrorCollector(testCase.maxErrors, func() {})
+		dumpErr := dumpKeys(context.Background(), &m, getMockRadixAction, 0, []string{"somestring", "somefailingstring"}, DumpOptions{TTLMode: AbsoluteTTL, BatchSize: 5, ChunkThreshold: 10}, l, s)
+		for _, keyErr := range dumpErr.(*DumpErrors).Keys {
+			collector.add(keyErr)
+		}
+
+		if err := s.Finish(collector.err()); err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+		}
+		if files := readDir(t, dir); len(files) != testCase.nFiles {
+			t.Errorf("test %d: expected %d files, got %q", i, testCase.nFiles, files)
+		}
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.resp.zst")
+	f, err := CreateOutputFile(path, CreateLocalFile, func(w io.Writer) (io.WriteCloser, error) {
+		return NewOutputWriter(w, ZstdCompression, 2, nil)
+	})
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	io.WriteString(f, "SET a 1\n")
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to exist before Commit", path)
+	}
+	if err := f.Commit(); err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	defer in.Close()
+	r, err := NewDecompressedReader(in)
+	if err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+	if b, _ := io.ReadAll(r); string(b) != "SET a 1\n" {
+		t.Errorf("unexpected content %q", b)
+	}
+}
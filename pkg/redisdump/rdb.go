@@ -0,0 +1,350 @@
+package redisdump
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yannh/redis-dump-go/pkg/rdb"
+)
+
+// RDBSerializer writes the keys dumped to an RDB file, which Redis can load
+// as it is. Keys are written by the serializer itself, Key and Cmd always
+// return an empty string. The keys of each database are kept in a temporary
+// file until all of them were dumped, as their number precedes them in the
+// RDB file, and values read in chunks are kept in memory until they were
+// fully read.
+type RDBSerializer struct {
+	mu  sync.Mutex
+	out *bufio.Writer
+	w   *rdb.Writer
+
+	// Keys of the current database
+	tmp      *os.File
+	tmpBuf   *bufio.Writer
+	enc      *rdb.Encoder
+	db       uint8
+	nKeys    uint64
+	nExpires uint64
+
+	partial    map[string]*Key
+	incomplete bool
+	err        error
+}
+
+// NewRDBSerializer writes the header of an RDB file to w. The file is only
+// complete once the RDBSerializer is closed.
+func NewRDBSerializer(w io.Writer) (*RDBSerializer, error) {
+	out := bufio.NewWriter(w)
+	rw, err := rdb.NewWriter(out)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "redis-dump-go-*.rdb")
+	if err != nil {
+		return nil, err
+	}
+	tmpBuf := bufio.NewWriter(tmp)
+
+	return &RDBSerializer{
+		out:     out,
+		w:       rw,
+		tmp:     tmp,
+		tmpBuf:  tmpBuf,
+		enc:     rdb.NewEncoder(tmpBuf),
+		partial: map[string]*Key{},
+	}, nil
+}
+
+// mergeValue adds the chunk of a value read in chunks to the chunks read
+// before it
+func mergeValue(val interface{}, chunk interface{}) interface{} {
+	switch v := val.(type) {
+	case []string:
+		return append(v, chunk.([]string)...)
+	case map[string]string:
+		for f, fv := range chunk.(map[string]string) {
+			v[f] = fv
+		}
+		return v
+	case Stream:
+		c := chunk.(Stream)
+		v.Entries = append(v.Entries, c.Entries...)
+		if c.LastID != "" {
+			v.LastID = c.LastID
+		}
+		v.Groups = append(v.Groups, c.Groups...)
+		return v
+	}
+	return chunk
+}
+
+// uniqueStrings returns the members of a set, without the duplicates SSCAN
+// can return
+func uniqueStrings(val []string) []string {
+	seen := make(map[string]bool, len(val))
+	unique := make([]string, 0, len(val))
+	for _, v := range val {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// rdbZMembers returns the members of a sorted set, read as members each
+// followed by its score, without the duplicates ZSCAN can return
+func rdbZMembers(val []string) ([]rdb.ZMember, error) {
+	index := make(map[string]int, len(val)/2)
+	members := make([]rdb.ZMember, 0, len(val)/2)
+	for i := 0; i+1 < len(val); i += 2 {
+		score, err := strconv.ParseFloat(val[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid score %s", val[i+1])
+		}
+		if j, ok := index[val[i]]; ok {
+			members[j].Score = score
+			continue
+		}
+		index[val[i]] = len(members)
+		members = append(members, rdb.ZMember{Member: val[i], Score: score})
+	}
+	return members, nil
+}
+
+// rdbStream converts a stream to its RDB representation. The consumers of
+// each group are the ones entries are pending for, and the time entries
+// were delivered at is derived from their idle time.
+func rdbStream(val Stream) (*rdb.Stream, error) {
+	now := time.Now().UnixMilli()
+	s := &rdb.Stream{}
+	for _, e := range val.Entries {
+		id, err := rdb.ParseStreamID(e.ID)
+		if err != nil {
+			return nil, err
+		}
+		s.Entries = append(s.Entries, rdb.StreamEntry{ID: id, Fields: e.Fields})
+	}
+
+	if val.LastID != "" {
+		lastID, err := rdb.ParseStreamID(val.LastID)
+		if err != nil {
+			return nil, err
+		}
+		s.LastID = lastID
+	}
+	if n := len(s.Entries); n > 0 {
+		if last := s.Entries[n-1].ID; last.Ms > s.LastID.Ms || (last.Ms == s.LastID.Ms && last.Seq > s.LastID.Seq) {
+			s.LastID = last
+		}
+	}
+
+	for _, g := range val.Groups {
+		lastID, err := rdb.ParseStreamID(g.LastDeliveredID)
+		if err != nil {
+			return nil, err
+		}
+		group := rdb.StreamGroup{Name: g.Name, LastID: lastID}
+
+		consumers := map[string]int{}
+		consumer := func(name string) int {
+			i, ok := consumers[name]
+			if !ok {
+				i = len(group.Consumers)
+				consumers[name] = i
+				group.Consumers = append(group.Consumers, rdb.StreamConsumer{Name: name, SeenTime: now})
+			}
+			return i
+		}
+		for _, c := range g.Consumers {
+			consumer(c)
+		}
+		for _, p := range g.Pending {
+			id, err := rdb.ParseStreamID(p.ID)
+			if err != nil {
+				return nil, err
+			}
+			idle, err := strconv.ParseInt(p.Idle, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid idle time %s", p.Idle)
+			}
+			deliveries, err := strconv.ParseUint(p.Deliveries, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid delivery count %s", p.Deliveries)
+			}
+			group.Pending = append(group.Pending, rdb.StreamPendingEntry{ID: id, DeliveryTime: now - idle, DeliveryCount: deliveries})
+
+			i := consumer(p.Consumer)
+			group.Consumers[i].Pending = append(group.Consumers[i].Pending, id)
+		}
+		s.Groups = append(s.Groups, group)
+	}
+
+	return s, nil
+}
+
+// encode writes k to the keys of the current database. s.mu must be held.
+func (s *RDBSerializer) encode(k *Key) error {
+	var err error
+	switch k.Type {
+	case "string":
+		err = s.enc.String(k.Name, k.Value.(string), k.ExpireAt)
+	case "list":
+		err = s.enc.List(k.Name, k.Value.([]string), k.ExpireAt)
+	case "set":
+		err = s.enc.Set(k.Name, uniqueStrings(k.Value.([]string)), k.ExpireAt)
+	case "hash":
+		err = s.enc.Hash(k.Name, k.Value.(map[string]string), k.ExpireAt)
+	case "zset":
+		var members []rdb.ZMember
+		if members, err = rdbZMembers(k.Value.([]string)); err == nil {
+			err = s.enc.ZSet(k.Name, members, k.ExpireAt)
+		}
+	case "stream":
+		var stream *rdb.Stream
+		if stream, err = rdbStream(k.Value.(Stream)); err == nil {
+			err = s.enc.Stream(k.Name, stream, k.ExpireAt)
+		}
+	default:
+		err = fmt.Errorf("keys of type %s can not be written to RDB files", k.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.nKeys++
+	if k.ExpireAt != 0 {
+		s.nExpires++
+	}
+	return nil
+}
+
+// Key implements the Serializer interface.
+func (s *RDBSerializer) Key(k *Key) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := fmt.Sprintf("%d:%s", k.Db, k.Name)
+	if k.Partial {
+		if p, ok := s.partial[name]; ok {
+			p.Value = mergeValue(p.Value, k.Value)
+		} else {
+			chunk := *k
+			s.partial[name] = &chunk
+		}
+		return ""
+	}
+
+	// The last Key of a value read in chunks holds its TTL
+	if p, ok := s.partial[name]; ok {
+		delete(s.partial, name)
+		p.PTTL, p.ExpireAt = k.PTTL, k.ExpireAt
+		k = p
+	}
+	if k.Value == nil {
+		return ""
+	}
+
+	if err := s.encode(k); err != nil && s.err == nil {
+		s.err = fmt.Errorf("failed writing key %s: %w", k.Name, err)
+	}
+	return ""
+}
+
+// flushDB copies the keys of the current database to the RDB file. s.mu
+// must be held.
+func (s *RDBSerializer) flushDB() error {
+	if s.nKeys == 0 {
+		return nil
+	}
+
+	if err := s.tmpBuf.Flush(); err != nil {
+		return err
+	}
+	if _, err := s.tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.w.SelectDB(uint64(s.db), s.nKeys, s.nExpires); err != nil {
+		return err
+	}
+	if _, err := s.w.ReadFrom(s.tmp); err != nil {
+		return err
+	}
+
+	s.nKeys, s.nExpires = 0, 0
+	if err := s.tmp.Truncate(0); err != nil {
+		return err
+	}
+	_, err := s.tmp.Seek(0, io.SeekStart)
+	return err
+}
+
+// Cmd implements the Serializer interface. Selecting a database writes out
+// the keys of the previous one.
+func (s *RDBSerializer) Cmd(cmd []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isIncompleteDumpMarker(cmd) {
+		s.incomplete = true
+		return ""
+	}
+	if len(cmd) != 2 || cmd[0] != "SELECT" {
+		return ""
+	}
+
+	db, err := strconv.ParseUint(cmd[1], 10, 8)
+	if err == nil {
+		err = s.flushDB()
+	}
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	s.db = uint8(db)
+	return ""
+}
+
+// Close ends the RDB file with its checksum, and removes the temporary
+// file. If the dump was incomplete, the RDB file is left without checksum,
+// so it is never loaded, and ErrIncompleteDump is returned.
+func (s *RDBSerializer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defer os.Remove(s.tmp.Name())
+	if !s.incomplete && s.err == nil {
+		s.err = s.flushDB()
+	}
+	if err := s.tmp.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.incomplete {
+		if s.err != nil {
+			return s.err
+		}
+		return ErrIncompleteDump
+	}
+
+	if s.err == nil {
+		s.err = s.w.Close()
+	}
+	if s.err == nil {
+		s.err = s.out.Flush()
+	}
+	return s.err
+}
+
+// Abort marks the dump as incomplete, and closes the RDBSerializer
+func (s *RDBSerializer) Abort() error {
+	s.mu.Lock()
+	s.incomplete = true
+	s.mu.Unlock()
+
+	return s.Close()
+}
@@ -0,0 +1,148 @@
+package redisdump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/yannh/redis-dump-go/pkg/rdb"
+)
+
+func TestRDBSerializer(t *testing.T) {
+	var b bytes.Buffer
+	s, err := NewRDBSerializer(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Cmd([]string{"SELECT", "0"})
+	s.Key(&Key{Db: 0, Name: "string", Type: "string", Value: "value", PTTL: 1000, ExpireAt: 1800000000000})
+	s.Key(&Key{Db: 0, Name: "set", Type: "set", Value: []string{"a", "b"}, Partial: true})
+	s.Key(&Key{Db: 0, Name: "list", Type: "list", Value: []string{"a", "b"}})
+	s.Key(&Key{Db: 0, Name: "set", Type: "set", Value: []string{"b", "c"}, Partial: true})
+	s.Key(&Key{Db: 0, Name: "set", Type: "set"})
+	s.Cmd([]string{"SELECT", "1"})
+	s.Cmd([]string{"SELECT", "2"})
+	s.Key(&Key{Db: 2, Name: "zset", Type: "zset", Value: []string{"a", "1", "b", "-inf", "a", "2"}})
+	s.Key(&Key{Db: 2, Name: "hash", Type: "hash", Value: map[string]string{"f": "v"}})
+	s.Key(&Key{Db: 2, Name: "stream", Type: "stream", Value: Stream{Entries: []StreamEntry{{ID: "1-0", Fields: []string{"f", "1"}}}}, Partial: true})
+	s.Key(&Key{Db: 2, Name: "stream", Type: "stream", Value: Stream{Entries: []StreamEntry{{ID: "2-0", Fields: []string{"f", "2"}}}}, Partial: true})
+	s.Key(&Key{Db: 2, Name: "stream", Type: "stream", Value: Stream{LastID: "3-0"}, Partial: true})
+	s.Key(&Key{Db: 2, Name: "stream", Type: "stream"})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	w, _ := rdb.NewWriter(&expected)
+	w.SelectDB(0, 3, 1)
+	w.String("string", "value", 1800000000000)
+	w.List("list", []string{"a", "b"}, 0)
+	w.Set("set", []string{"a", "b", "c"}, 0)
+	w.SelectDB(2, 3, 0)
+	w.ZSet("zset", []rdb.ZMember{{Member: "a", Score: 2}, {Member: "b", Score: math.Inf(-1)}}, 0)
+	w.Hash("hash", map[string]string{"f": "v"}, 0)
+	w.Stream("stream", &rdb.Stream{
+		Entries: []rdb.StreamEntry{{ID: rdb.StreamID{Ms: 1}, Fields: []string{"f", "1"}}, {ID: rdb.StreamID{Ms: 2}, Fields: []string{"f", "2"}}},
+		LastID:  rdb.StreamID{Ms: 3},
+	}, 0)
+	w.Close()
+
+	if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+		t.Errorf("expected %q, got %q", expected.Bytes(), b.Bytes())
+	}
+}
+
+func TestRDBSerializerErrors(t *testing.T) {
+	var b bytes.Buffer
+	s, _ := NewRDBSerializer(&b)
+	s.Cmd([]string{"SELECT", "0"})
+	s.Key(&Key{Name: "module", Type: "MBbloom--", Value: "?"})
+	if err := s.Close(); err == nil {
+		t.Errorf("expected an error writing a key of an unknown type")
+	}
+}
+
+func TestRDBSerializerIncomplete(t *testing.T) {
+	var b bytes.Buffer
+	s, _ := NewRDBSerializer(&b)
+	s.Cmd([]string{"SELECT", "0"})
+	s.Key(&Key{Name: "string", Type: "string", Value: "value"})
+	s.Cmd(IncompleteDumpMarker)
+	if err := s.Close(); !errors.Is(err, ErrIncompleteDump) {
+		t.Errorf("expected ErrIncompleteDump, got %v", err)
+	}
+
+	// The file is left without its end and checksum
+	if bytes.Contains(b.Bytes(), []byte("value")) {
+		t.Errorf("expected the incomplete database not to be written, got %q", b.Bytes())
+	}
+
+	s, _ = NewRDBSerializer(&b)
+	if err := s.Abort(); !errors.Is(err, ErrIncompleteDump) {
+		t.Errorf("expected ErrIncompleteDump aborting, got %v", err)
+	}
+}
+
+func TestDumpKeysRDB(t *testing.T) {
+	var m mockRadixClient
+	var b bytes.Buffer
+	l := log.New(&b, "", 0)
+	s, _ := NewRDBSerializer(&b)
+	s.Cmd([]string{"SELECT", "0"})
+	if err := dumpKeys(context.Background(), &m, getMockRadixAction, 0, []string{"somehugezset", "somestring"}, DumpOptions{TTLMode: NoTTL, BatchSize: 5, ChunkThreshold: 2}, l, s); err != nil {
+		t.Errorf("received error %+v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var expected bytes.Buffer
+	w, _ := rdb.NewWriter(&expected)
+	w.SelectDB(0, 2, 0)
+	w.String("somestring", "stringvalue", 0)
+	w.ZSet("somehugezset", []rdb.ZMember{{Member: "member1", Score: 1}, {Member: "member2", Score: 2}, {Member: "member3", Score: 3}}, 0)
+	w.Close()
+
+	if !bytes.Equal(b.Bytes(), expected.Bytes()) {
+		t.Errorf("expected %q, got %q", expected.Bytes(), b.Bytes())
+	}
+}
+
+func TestRDBStream(t *testing.T) {
+	s, err := rdbStream(Stream{
+		Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1"}}, {ID: "2-0", Fields: []string{"b", "2"}}},
+		LastID:  "1-5",
+		Groups: []StreamGroup{
+			{Name: "g", LastDeliveredID: "2-0", Pending: []StreamPendingEntry{
+				{ID: "1-1", Consumer: "c1", Idle: "10", Deliveries: "1"},
+				{ID: "2-0", Consumer: "c2", Idle: "10", Deliveries: "3"},
+			}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.LastID != (rdb.StreamID{Ms: 2, Seq: 0}) {
+		t.Errorf("expected the last ID to be the one of the last entry, got %s", s.LastID)
+	}
+	g := s.Groups[0]
+	if len(g.Pending) != 2 || g.Pending[1].DeliveryCount != 3 {
+		t.Errorf("unexpected pending entries %+v", g.Pending)
+	}
+	var consumers []string
+	for _, c := range g.Consumers {
+		consumers = append(consumers, c.Name)
+	}
+	if !reflect.DeepEqual(consumers, []string{"c1", "c2"}) || !reflect.DeepEqual(g.Consumers[1].Pending, []rdb.StreamID{{Ms: 2, Seq: 0}}) {
+		t.Errorf("unexpected consumers %+v", g.Consumers)
+	}
+
+	if _, err := rdbStream(Stream{Entries: []StreamEntry{{ID: "invalid"}}}); err == nil {
+		t.Errorf("expected an error for an invalid entry ID")
+	}
+}
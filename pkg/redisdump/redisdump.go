@@ -2,6 +2,8 @@ package redisdump
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,12 +12,44 @@ import (
 	"time"
 
 	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 var AllDBs *uint8 = nil
 
-func ttlToRedisCmd(k string, val int64) []string {
-	return []string{"EXPIREAT", k, fmt.Sprint(time.Now().Unix() + val)}
+// TTLMode sets how the expiration of keys is dumped
+type TTLMode int
+
+const (
+	// NoTTL drops the expiration of keys
+	NoTTL TTLMode = iota
+	// AbsoluteTTL dumps the Unix time keys expire at, with PEXPIREAT
+	AbsoluteTTL
+	// RelativeTTL dumps the time keys have left to live, with PEXPIRE.
+	// Restored keys then expire relative to the time of the restore.
+	RelativeTTL
+)
+
+// ttlToRedisCmd sets the expiration of a key, with pttl milliseconds
+// left to live when it was read
+func ttlToRedisCmd(k string, pttl int64, mode TTLMode) []string {
+	if mode == RelativeTTL {
+		return []string{"PEXPIRE", k, fmt.Sprint(pttl)}
+	}
+	return []string{"PEXPIREAT", k, fmt.Sprint(time.Now().UnixMilli() + pttl)}
+}
+
+// restoreToRedisCmd recreates a key from its DUMP payload. ttl is in
+// milliseconds, 0 if the key does not expire: a Unix time if absTTL
+// is set, the time left to live otherwise. hints are the optional
+// IDLETIME or FREQ arguments.
+func restoreToRedisCmd(k, payload string, ttl int64, absTTL bool, hints []string) []string {
+	cmd := []string{"RESTORE", k, fmt.Sprint(ttl), payload, "REPLACE"}
+	if absTTL {
+		cmd = append(cmd, "ABSTTL")
+	}
+	return append(cmd, hints...)
 }
 
 func stringToRedisCmd(k, val string) []string {
@@ -116,7 +150,117 @@ func zsetToRedisCmds(zsetKey string, val []string, batchSize int) [][]string {
 	return cmds
 }
 
-type Serializer func([]string) string
+// Key is a key read from Redis, as passed to a Serializer
+type Key struct {
+	Db   uint8
+	Name string
+	// Type is the type of the key, as returned by TYPE
+	Type string
+	// Value is a string for strings, a []string for lists and sets, a
+	// map[string]string for hashes, a []string of members each followed by
+	// its score for sorted sets, and a Stream for streams. Values read in
+	// chunks are passed as one Partial Key per chunk, followed by a Key
+	// holding no value but the TTL: the chunks of a stream hold its
+	// entries, and the last one its last ID and groups. Keys read with
+	// DUMP hold no value.
+	Value   interface{}
+	Partial bool
+	// PTTL is the number of milliseconds the key had left to live when it
+	// was read, ExpireAt the Unix time in milliseconds it expires at. Both
+	// are 0 if the key does not expire, or TTLs are not dumped.
+	PTTL     int64
+	ExpireAt int64
+	// Cmds are the commands recreating the key
+	Cmds [][]string
+}
+
+// endsChunks returns whether k is the Key holding the TTL of a value read
+// in chunks, passed after its chunks
+func (k *Key) endsChunks() bool {
+	return !k.Partial && k.Value == nil && k.Type != ""
+}
+
+// setTTL sets the expiration of k, which had pttl milliseconds left to
+// live, and appends the command setting it
+func (k *Key) setTTL(pttl int64, ttlMode TTLMode) {
+	k.PTTL = pttl
+	k.ExpireAt = time.Now().UnixMilli() + pttl
+	k.Cmds = append(k.Cmds, ttlToRedisCmd(k.Name, pttl, ttlMode))
+}
+
+// Serializer formats the output of a dump. Key serializes a key, and Cmd
+// the commands written around keys, such as SELECT or IncompleteDumpMarker.
+// Nothing is written when they return an empty string.
+type Serializer interface {
+	Key(k *Key) string
+	Cmd(cmd []string) string
+}
+
+// CmdSerializer writes keys as the commands recreating them, each command
+// serialized with the function
+type CmdSerializer func(cmd []string) string
+
+// Key implements the Serializer interface.
+func (s CmdSerializer) Key(k *Key) string {
+	cmds := make([]string, 0, len(k.Cmds))
+	for _, cmd := range k.Cmds {
+		cmds = append(cmds, s(cmd))
+	}
+	return strings.Join(cmds, "\n")
+}
+
+// chunksMarkerPrefix and chunksEndMarkerPrefix start the ECHO commands
+// ConflictSerializer writes before each chunk of a value read in chunks,
+// and after its last chunk and TTL, followed by the name of the key. Like
+// IncompleteDumpMarker, they have no effect on the server; restore uses
+// them to apply its conflict policy once to all chunks of the key.
+const (
+	chunksMarkerPrefix    = "redis-dump-go: chunk of "
+	chunksEndMarkerPrefix = "redis-dump-go: end of chunks of "
+)
+
+// chunksMarker returns the marker preceding a chunk of the value of key, or
+// following its last chunk if end is set
+func chunksMarker(key string, end bool) []string {
+	if end {
+		return []string{"ECHO", chunksEndMarkerPrefix + key}
+	}
+	return []string{"ECHO", chunksMarkerPrefix + key}
+}
+
+// parseChunksMarker returns the key of a chunk marker, and whether it
+// follows the last chunk. ok is false if cmd is not a chunk marker.
+func parseChunksMarker(cmd []string) (key string, end bool, ok bool) {
+	if len(cmd) != 2 || strings.ToUpper(cmd[0]) != "ECHO" {
+		return "", false, false
+	}
+	if key, ok := strings.CutPrefix(cmd[1], chunksMarkerPrefix); ok {
+		return key, false, true
+	}
+	if key, ok := strings.CutPrefix(cmd[1], chunksEndMarkerPrefix); ok {
+		return key, true, true
+	}
+	return "", false, false
+}
+
+// Cmd implements the Serializer interface.
+func (s CmdSerializer) Cmd(cmd []string) string {
+	return s(cmd)
+}
+
+// writeKey writes k to logger, serialized with serializer
+func writeKey(logger *log.Logger, serializer Serializer, k *Key) {
+	if out := serializer.Key(k); out != "" {
+		logger.Print(out)
+	}
+}
+
+// writeCmd writes cmd to logger, serialized with serializer
+func writeCmd(logger *log.Logger, serializer Serializer, cmd []string) {
+	if out := serializer.Cmd(cmd); out != "" {
+		logger.Print(out)
+	}
+}
 
 // RedisCmdSerializer will serialize cmd to a string with redis commands
 func RedisCmdSerializer(cmd []string) string {
@@ -149,83 +293,397 @@ func RESPSerializer(cmd []string) string {
 
 type radixCmder func(rcv interface{}, cmd string, args ...string) radix.CmdAction
 
-func dumpKeys(client radix.Client, cmd radixCmder, keys []string, withTTL bool, batchSize int, logger *log.Logger, serializer Serializer) error {
+// execReply receives the replies to the commands of a transaction, each
+// into the receiver at the same position
+type execReply []interface{}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (r execReply) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	if ah.N < 0 {
+		return errors.New("transaction aborted")
+	}
+
+	// All replies are read, even after an error, to leave the
+	// connection usable
+	var firstErr error
+	for i := 0; i < ah.N; i++ {
+		var rcv interface{}
+		if i < len(r) {
+			rcv = r[i]
+		}
+		if err := (resp2.Any{I: rcv}).UnmarshalRESP(br); err != nil {
+			if !errors.As(err, new(resp.ErrDiscarded)) {
+				return err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// readValue runs the command reading the value of key into rcv. If pttl
+// is set, PTTL is run in the same transaction, so the time to live matches
+// the value read. If keyType is set, the type of key is read into it in
+// the same transaction as well.
+func readValue(client radix.Client, cmd radixCmder, key string, keyType *string, rcv interface{}, pttl *int64, command string, args ...string) error {
+	if pttl == nil && keyType == nil {
+		return client.Do(cmd(rcv, command, args...))
+	}
+
+	actions := []radix.CmdAction{cmd(nil, "MULTI")}
+	var rcvs execReply
+	if keyType != nil {
+		actions = append(actions, cmd(nil, "TYPE", key))
+		rcvs = append(rcvs, keyType)
+	}
+	actions = append(actions, cmd(nil, command, args...))
+	rcvs = append(rcvs, rcv)
+	if pttl != nil {
+		actions = append(actions, cmd(nil, "PTTL", key))
+		rcvs = append(rcvs, pttl)
+	}
+	actions = append(actions, cmd(rcvs, "EXEC"))
+
+	return client.Do(radix.Pipeline(actions...))
+}
+
+// lengthCmds are the commands returning the number of elements of the
+// values that can be read in chunks, by type
+var lengthCmds = map[string]string{
+	"list":   "LLEN",
+	"set":    "SCARD",
+	"hash":   "HLEN",
+	"zset":   "ZCARD",
+	"stream": "XLEN",
+}
+
+// isLargeValue returns true if the value of key, of type keyType, holds
+// more than chunkThreshold elements. Values are never large if
+// chunkThreshold is negative.
+func isLargeValue(client radix.Client, cmd radixCmder, keyType string, key string, chunkThreshold int) (bool, error) {
+	lenCmd, ok := lengthCmds[keyType]
+	if !ok || chunkThreshold < 0 {
+		return false, nil
+	}
+
+	var n int
+	if err := client.Do(cmd(&n, lenCmd, key)); err != nil {
+		return false, err
+	}
+	return n > chunkThreshold, nil
+}
+
+// valueReader returns a receiver for the value of a key of type keyType,
+// and the command reading it at once. The receiver is nil for types that
+// can not be read with a single command.
+func valueReader(keyType string, key string) (interface{}, []string) {
+	switch keyType {
+	case "string":
+		return new(string), []string{"GET", key}
+	case "list":
+		return new([]string), []string{"LRANGE", key, "0", "-1"}
+	case "set":
+		return new([]string), []string{"SMEMBERS", key}
+	case "hash":
+		return new(map[string]string), []string{"HGETALL", key}
+	case "zset":
+		return new([]string), []string{"ZRANGEBYSCORE", key, "-inf", "+inf", "WITHSCORES"}
+	}
+	return nil, nil
+}
+
+// valueOf returns the value read into a receiver returned by valueReader
+func valueOf(rcv interface{}) interface{} {
+	switch v := rcv.(type) {
+	case *string:
+		return *v
+	case *[]string:
+		return *v
+	case *map[string]string:
+		return *v
+	}
+	return rcv
+}
+
+// valueToRedisCmds returns the commands recreating a key of type keyType
+// from its value, as held by Key
+func valueToRedisCmds(keyType string, key string, val interface{}, batchSize int) [][]string {
+	switch keyType {
+	case "string":
+		return [][]string{stringToRedisCmd(key, val.(string))}
+	case "list":
+		return listToRedisCmds(key, val.([]string), batchSize)
+	case "set":
+		return setToRedisCmds(key, val.([]string), batchSize)
+	case "hash":
+		return hashToRedisCmds(key, val.(map[string]string), batchSize)
+	case "zset":
+		return zsetToRedisCmds(key, val.([]string), batchSize)
+	case "stream":
+		return streamToRedisCmds(key, val.(Stream))
+	}
+	return nil
+}
+
+// scanValue reads a hash, set or sorted set with scanCmd - HSCAN, SSCAN or
+// ZSCAN - about count elements at a time, and passes each chunk to fn.
+// Elements can be returned more than once.
+func scanValue(client radix.Client, cmd radixCmder, scanCmd string, key string, count int, fn func([]string)) error {
+	cursor := "0"
+	for {
+		var res scanResult
+		if err := client.Do(cmd(&res, scanCmd, key, cursor, "COUNT", fmt.Sprint(count))); err != nil {
+			return err
+		}
+		if len(res.elements) > 0 {
+			fn(res.elements)
+		}
+		if res.cursor == "0" {
+			return nil
+		}
+		cursor = res.cursor
+	}
+}
+
+// rangeList reads a list count elements at a time, and passes each
+// chunk to fn
+func rangeList(client radix.Client, cmd radixCmder, key string, count int, fn func([]string)) error {
+	for start := 0; ; start += count {
+		var val []string
+		if err := client.Do(cmd(&val, "LRANGE", key, fmt.Sprint(start), fmt.Sprint(start+count-1))); err != nil {
+			return err
+		}
+		if len(val) > 0 {
+			fn(val)
+		}
+		if len(val) < count {
+			return nil
+		}
+	}
+}
+
+// readLargeValue reads a hash, set, sorted set, list or stream batchSize
+// elements at a time, and passes each chunk to write, with the commands
+// recreating it
+func readLargeValue(client radix.Client, cmd radixCmder, keyType string, key string, batchSize int, write func(interface{}, [][]string)) error {
+	switch keyType {
+	case "stream":
+		return readLargeStream(client, cmd, key, batchSize, write)
+	case "list":
+		return rangeList(client, cmd, key, batchSize, func(val []string) {
+			write(val, listToRedisCmds(key, val, batchSize))
+		})
+	case "set":
+		return scanValue(client, cmd, "SSCAN", key, batchSize, func(val []string) {
+			write(val, setToRedisCmds(key, val, batchSize))
+		})
+	case "hash":
+		return scanValue(client, cmd, "HSCAN", key, batchSize, func(fields []string) {
+			val := make(map[string]string, len(fields)/2)
+			for i := 0; i+1 < len(fields); i += 2 {
+				val[fields[i]] = fields[i+1]
+			}
+			write(val, hashToRedisCmds(key, val, batchSize))
+		})
+	case "zset":
+		return scanValue(client, cmd, "ZSCAN", key, batchSize, func(val []string) {
+			write(val, zsetToRedisCmds(key, val, batchSize))
+		})
+	}
+	return fmt.Errorf("Key %s of type %s can not be read in chunks", key, keyType)
+}
+
+// dumpKey writes key, from the database db, to the logger. Hashes, sets,
+// sorted sets, lists and streams of more than chunkThreshold elements are
+// read batchSize elements at a time, each chunk being written out before
+// the next one is read. The expiration of values read at once is read in the
+// same transaction. If atomic is set, so is their type, and
+// errKeyTypeChanged is returned if the key changed type since it was
+// first checked.
+func dumpKey(client radix.Client, cmd radixCmder, db uint8, key string, ttlMode TTLMode, batchSize int, chunkThreshold int, atomic bool, logger *log.Logger, serializer Serializer) error {
+	var pttl *int64
+	if ttlMode != NoTTL {
+		pttl = new(int64)
+	}
+	// Chunked values and streams are read in several commands, their
+	// expiration is read separately once they were read
+	pttlRead := false
 	var err error
-	var redisCmds [][]string
+	keyType := ""
+	read := func(rcv interface{}, command string, args ...string) error {
+		pttlRead = pttl != nil
+		if !atomic {
+			return readValue(client, cmd, key, nil, rcv, pttl, command, args...)
+		}
 
-	for _, key := range keys {
-		keyType := ""
+		// The type is read again with the value: if it changed, the
+		// value was read with the wrong command
+		var txType string
+		err := readValue(client, cmd, key, &txType, rcv, pttl, command, args...)
+		if txType != "" && txType != keyType {
+			return errKeyTypeChanged
+		}
+		return err
+	}
 
-		err = client.Do(cmd(&keyType, "TYPE", key))
+	err = client.Do(cmd(&keyType, "TYPE", key))
+	if err != nil {
+		return err
+	}
+	k := &Key{Db: db, Name: key, Type: keyType}
+	switch keyType {
+	case "string", "list", "set", "hash", "zset", "stream":
+		large, err := isLargeValue(client, cmd, keyType, key, chunkThreshold)
 		if err != nil {
 			return err
 		}
-		switch keyType {
-		case "string":
-			var val string
-			if err = client.Do(cmd(&val, "GET", key)); err != nil {
+		if large {
+			err = readLargeValue(client, cmd, keyType, key, batchSize, func(val interface{}, redisCmds [][]string) {
+				writeKey(logger, serializer, &Key{Db: db, Name: key, Type: keyType, Value: val, Partial: true, Cmds: redisCmds})
+			})
+			if err != nil {
 				return err
 			}
-			redisCmds = [][]string{stringToRedisCmd(key, val)}
+			break
+		}
 
-		case "list":
-			var val []string
-			if err = client.Do(cmd(&val, "LRANGE", key, "0", "-1")); err != nil {
+		if keyType == "stream" {
+			val, err := getStream(client, cmd, key, batchSize)
+			if err != nil {
 				return err
 			}
-			redisCmds = listToRedisCmds(key, val, batchSize)
-
-		case "set":
-			var val []string
-			if err = client.Do(cmd(&val, "SMEMBERS", key)); err != nil {
+			k.Value = val
+		} else {
+			val, readCmd := valueReader(keyType, key)
+			if err = read(val, readCmd[0], readCmd[1:]...); err != nil {
 				return err
 			}
-			redisCmds = setToRedisCmds(key, val, batchSize)
+			k.Value = valueOf(val)
+		}
+		k.Cmds = valueToRedisCmds(keyType, key, k.Value, batchSize)
 
-		case "hash":
-			var val map[string]string
-			if err = client.Do(cmd(&val, "HGETALL", key)); err != nil {
-				return err
-			}
-			redisCmds = hashToRedisCmds(key, val, batchSize)
+	case "none":
+		return nil
+
+	default:
+		return fmt.Errorf("Key %s is of unreconized type %s", key, keyType)
+	}
 
-		case "zset":
-			var val []string
-			if err = client.Do(cmd(&val, "ZRANGEBYSCORE", key, "-inf", "+inf", "WITHSCORES")); err != nil {
+	if pttl != nil {
+		if !pttlRead {
+			if err = client.Do(cmd(pttl, "PTTL", key)); err != nil {
 				return err
 			}
-			redisCmds = zsetToRedisCmds(key, val, batchSize)
+		}
+		if *pttl > 0 {
+			k.setTTL(*pttl, ttlMode)
+		}
+	}
+	writeKey(logger, serializer, k)
+
+	return nil
+}
 
-		case "none":
+// errKeyTypeChanged is returned when a key changed type while it was read
+var errKeyTypeChanged = errors.New("key changed type while being read")
+
+// maxTypeChangeRetries is the number of times a key that changed type
+// while being read is read again
+const maxTypeChangeRetries = 3
+
+// dumpKeys dumps all keys, even if some of them fail. The keys that could
+// not be dumped are returned as DumpErrors. Keys are read together with a
+// few pipelines, except for large values, streams and keys that changed
+// type while being read, which are then dumped one at a time. Keys that
+// changed type are retried. Once ctx is done, the remaining keys are skipped.
+func dumpKeys(ctx context.Context, client radix.Client, cmd radixCmder, db uint8, keys []string, opts DumpOptions, logger *log.Logger, serializer Serializer) error {
+	var errs DumpErrors
+	if ctx.Err() != nil {
+		return nil
+	}
 
-		default:
-			return fmt.Errorf("Key %s is of unreconized type %s", key, keyType)
+	var batch []*batchKey
+	var err error
+	if opts.DumpPayloads {
+		batch, err = readPayloadBatch(client, cmd, keys, opts.TTLMode)
+	} else {
+		batch, err = readBatch(client, cmd, keys, opts.TTLMode, opts.BatchSize, opts.ChunkThreshold, opts.Atomic)
+	}
+	if err != nil {
+		for _, key := range keys {
+			errs.add(KeyError{Db: db, Key: key, Err: err}, -1)
 		}
+		return &errs
+	}
 
-		for _, redisCmd := range redisCmds {
-			logger.Print(serializer(redisCmd))
+	for _, k := range batch {
+		if k.err != nil {
+			errs.add(KeyError{Db: db, Key: k.key, Err: k.err}, -1)
+			continue
+		}
+		if k.dumped != nil {
+			k.dumped.Db = db
+			writeKey(logger, serializer, k.dumped)
 		}
+	}
 
-		if withTTL {
-			var ttl int64
-			if err = client.Do(cmd(&ttl, "TTL", key)); err != nil {
-				return err
-			}
-			if ttl > 0 {
-				cmd := ttlToRedisCmd(key, ttl)
-				logger.Print(serializer(cmd))
-			}
+	for _, k := range batch {
+		if !k.single {
+			continue
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		err := dumpKey(client, cmd, db, k.key, opts.TTLMode, opts.BatchSize, opts.ChunkThreshold, opts.Atomic, logger, serializer)
+		for retry := 0; err == errKeyTypeChanged && retry < maxTypeChangeRetries; retry++ {
+			err = dumpKey(client, cmd, db, k.key, opts.TTLMode, opts.BatchSize, opts.ChunkThreshold, opts.Atomic, logger, serializer)
+		}
+		if err != nil {
+			errs.add(KeyError{Db: db, Key: k.key, Err: err}, -1)
 		}
 	}
 
+	if len(errs.Keys) > 0 {
+		return &errs
+	}
 	return nil
 }
 
-func dumpKeysWorker(client radix.Client, keyBatches <-chan []string, withTTL bool, batchSize int, logger *log.Logger, serializer Serializer, errors chan<- error, done chan<- bool) {
-	for keyBatch := range keyBatches {
-		if err := dumpKeys(client, radix.Cmd, keyBatch, withTTL, batchSize, logger, serializer); err != nil {
+// dumpKeysWorker dumps the batches of keys it receives, and marks them
+// as dumped in tracker if set. Once ctx is cancelled, the remaining
+// batches are discarded.
+func dumpKeysWorker(ctx context.Context, client radix.Client, db uint8, keyBatches <-chan keyBatch, opts DumpOptions, logger *log.Logger, serializer Serializer, tracker *cursorTracker, errors chan<- error, done chan<- bool) {
+	for batch := range keyBatches {
+		if ctx.Err() != nil {
+			continue
+		}
+		err := dumpKeys(ctx, client, radix.Cmd, db, batch.keys, opts, logger, serializer)
+		if err != nil {
 			errors <- err
 		}
+		// Keys skipped after a cancellation must be dumped again on resume
+		if tracker != nil && ctx.Err() == nil {
+			var retried, failed []string
+			if batch.retry {
+				retried = batch.keys
+			}
+			if dumpErrs, ok := err.(*DumpErrors); ok {
+				for _, keyErr := range dumpErrs.Keys {
+					failed = append(failed, keyErr.Key)
+				}
+			}
+			if len(retried) > 0 || len(failed) > 0 {
+				tracker.failed(retried, failed)
+			}
+			tracker.done(batch.seq)
+		}
 	}
 	done <- true
 }
@@ -271,27 +729,79 @@ func getDBIndexes(client *radix.Pool) ([]uint8, error) {
 	return parseKeyspaceInfo(keyspaceInfo)
 }
 
-func scanKeys(client radix.Client, cmd radixCmder, db uint8, keyBatchSize int, filter string, keyBatches chan<- []string, progressNotifications chan<- ProgressNotification) error {
-	s := radix.NewScanner(client, radix.ScanOpts{Command: "SCAN", Pattern: filter, Count: keyBatchSize})
+// keyBatch is a batch of keys to dump. seq is the sequence number of the
+// batch in the cursorTracker of the database, if any. retry is set for
+// the keys a checkpoint recorded as failed.
+type keyBatch struct {
+	keys  []string
+	seq   int
+	retry bool
+}
+
+// scanResult is the reply to a SCAN, HSCAN, SSCAN or ZSCAN command
+type scanResult struct {
+	cursor   string
+	elements []string
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (r *scanResult) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	if ah.N != 2 {
+		return fmt.Errorf("invalid SCAN reply of %d elements", ah.N)
+	}
+
+	var bs resp2.BulkString
+	if err := bs.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	r.cursor = bs.S
+
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	r.elements = make([]string, 0, ah.N)
+	for i := 0; i < ah.N; i++ {
+		if err := bs.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		r.elements = append(r.elements, bs.S)
+	}
+
+	return nil
+}
 
+// scanKeys iterates over the keys of the database with SCAN, starting from
+// cursor. Each page of keys returned is registered in tracker, if set,
+// before being sent to the workers.
+func scanKeys(ctx context.Context, client radix.Client, cmd radixCmder, db uint8, keyBatchSize int, filter string, cursor string, tracker *cursorTracker, keyBatches chan<- keyBatch, progressNotifications chan<- ProgressNotification) error {
 	nProcessed := 0
-	var key string
-	var keyBatch []string
-	for s.Next(&key) {
-		keyBatch = append(keyBatch, key)
-		if len(keyBatch) >= keyBatchSize {
-			nProcessed += len(keyBatch)
-			keyBatches <- keyBatch
-			keyBatch = nil
+	for ctx.Err() == nil {
+		var res scanResult
+		if err := client.Do(cmd(&res, "SCAN", cursor, "MATCH", filter, "COUNT", fmt.Sprint(keyBatchSize))); err != nil {
+			return err
+		}
+		cursor = res.cursor
+
+		batch := keyBatch{keys: res.elements}
+		if tracker != nil {
+			batch.seq = tracker.add(cursor)
+		}
+		keyBatches <- batch
+		nProcessed += len(res.elements)
+		if progressNotifications != nil {
 			progressNotifications <- ProgressNotification{Db: db, Done: nProcessed}
 		}
-	}
 
-	keyBatches <- keyBatch
-	nProcessed += len(keyBatch)
-	progressNotifications <- ProgressNotification{Db: db, Done: nProcessed}
+		if cursor == "0" {
+			break
+		}
+	}
 
-	return s.Close()
+	return nil
 }
 
 func min(a, b int) int {
@@ -301,16 +811,16 @@ func min(a, b int) int {
 	return b
 }
 
-func scanKeysLegacy(client radix.Client, cmd radixCmder, db uint8, keyBatchSize int, filter string, keyBatches chan<- []string, progressNotifications chan<- ProgressNotification) error {
+func scanKeysLegacy(ctx context.Context, client radix.Client, cmd radixCmder, db uint8, keyBatchSize int, filter string, cursor string, tracker *cursorTracker, keyBatches chan<- keyBatch, progressNotifications chan<- ProgressNotification) error {
 	var err error
 	var keys []string
 	if err = client.Do(cmd(&keys, "KEYS", filter)); err != nil {
 		return err
 	}
 
-	for i := 0; i < len(keys); i += keyBatchSize {
+	for i := 0; i < len(keys) && ctx.Err() == nil; i += keyBatchSize {
 		batchEnd := min(i+keyBatchSize, len(keys))
-		keyBatches <- keys[i:batchEnd]
+		keyBatches <- keyBatch{keys: keys[i:batchEnd]}
 		if progressNotifications != nil {
 			progressNotifications <- ProgressNotification{db, i}
 		}
@@ -350,36 +860,68 @@ func redisDialOpts(redisUsername string, redisPassword string, tlsHandler *TlsHa
 	return dialOpts, nil
 }
 
-func dumpDB(client radix.Client, db *uint8, filter string, nWorkers int, withTTL bool, batchSize int, noscan bool, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+// dumpDB dumps the database db. If checkpoint is set, the dump resumes
+// from the cursor saved for db, after dumping again the keys that failed,
+// and the progress is recorded to it.
+func dumpDB(ctx context.Context, client radix.Client, db *uint8, opts DumpOptions, logger *log.Logger, serializer Serializer, checkpoint *Checkpoint, collector *errorCollector, progress chan<- ProgressNotification) error {
 	keyGenerator := scanKeys
-	if noscan {
+	if opts.Noscan {
 		keyGenerator = scanKeysLegacy
 	}
 
-	logger.Print(serializer([]string{"SELECT", fmt.Sprint(*db)}))
+	cursor := "0"
+	scanned := false
+	var retry []string
+	var tracker *cursorTracker
+	if checkpoint != nil {
+		cursor, scanned = checkpoint.cursor(*db)
+		retry = checkpoint.failed(*db)
+		tracker = newCursorTracker(func(cursor string) {
+			checkpoint.update(*db, cursor, cursor == "0")
+		})
+		tracker.onFailed = func(retried []string, failed []string) {
+			checkpoint.retry(*db, retried, failed)
+		}
+	}
 
 	errors := make(chan error)
-	nErrors := 0
+	errorsDone := make(chan bool)
 	go func() {
 		for err := range errors {
-			fmt.Fprintln(os.Stderr, "Error: "+err.Error())
-			nErrors++
+			for _, keyErr := range err.(*DumpErrors).Keys {
+				keyErr.Db = *db
+				fmt.Fprintln(os.Stderr, "Error: "+keyErr.Error())
+				collector.add(keyErr)
+			}
 		}
+		errorsDone <- true
 	}()
 
 	done := make(chan bool)
-	keyBatches := make(chan []string)
-	for i := 0; i < nWorkers; i++ {
-		go dumpKeysWorker(client, keyBatches, withTTL, batchSize, logger, serializer, errors, done)
+	keyBatches := make(chan keyBatch)
+	for i := 0; i < opts.NWorkers; i++ {
+		go dumpKeysWorker(ctx, client, *db, keyBatches, opts, logger, serializer, tracker, errors, done)
 	}
 
-	keyGenerator(client, radix.Cmd, *db, 100, filter, keyBatches, progress)
+	// The cursor is only saved once the keys that failed were dumped again
+	if len(retry) > 0 {
+		keyBatches <- keyBatch{keys: retry, seq: tracker.add(cursor), retry: true}
+	}
+	var err error
+	if !scanned {
+		err = keyGenerator(ctx, client, radix.Cmd, *db, 100, opts.Filter, cursor, tracker, keyBatches, progress)
+	}
 	close(keyBatches)
 
-	for i := 0; i < nWorkers; i++ {
+	for i := 0; i < opts.NWorkers; i++ {
 		<-done
 	}
+	close(errors)
+	<-errorsDone
 
+	if err != nil {
+		return fmt.Errorf("failed scanning database %d: %w", *db, err)
+	}
 	return nil
 }
 
@@ -391,27 +933,80 @@ type Host struct {
 	TlsHandler *TlsHandler
 }
 
+// getConnFunc returns a function opening authenticated connections to
+// the server s, with database db selected
+func getConnFunc(s Host, db *uint8) func(network, addr string) (radix.Conn, error) {
+	return func(network, addr string) (radix.Conn, error) {
+		dialOpts, err := redisDialOpts(s.Username, s.Password, s.TlsHandler, db)
+		if err != nil {
+			return nil, err
+		}
+
+		return radix.Dial(network, addr, dialOpts...)
+	}
+}
+
+// IncompleteDumpMarker is the last command of a dump that was interrupted
+// before all keys were dumped. It has no effect when restoring the dump.
+var IncompleteDumpMarker = []string{"ECHO", "redis-dump-go: incomplete dump"}
+
+// DumpOptions are how the keys of a dump are read
+type DumpOptions struct {
+	// Filter is the pattern of the keys dumped
+	Filter string
+	// NWorkers is the number of batches of keys dumped in parallel, each
+	// with its own connection
+	NWorkers int
+	TTLMode  TTLMode
+	// BatchSize is the number of elements of the values read in chunks
+	// read at a time
+	BatchSize int
+	// ChunkThreshold is the number of elements of the hashes, sets, sorted
+	// sets, lists and streams read in chunks rather than at once: they are always
+	// read at once if it is negative
+	ChunkThreshold int
+	// Atomic reads the type, value and TTL of keys read at once in a
+	// single transaction, and keys that changed type again
+	Atomic bool
+	// Noscan lists the keys with KEYS rather than SCAN
+	Noscan bool
+	// DumpPayloads reads keys with DUMP, written as RESTORE commands
+	// rather than recreated from their values
+	DumpPayloads bool
+	// MaxErrors is the number of keys that can fail to be dumped before
+	// the dump is interrupted, it is never interrupted if negative
+	MaxErrors int
+}
+
 // DumpServer dumps all Keys from the redis server given by redisURL,
-// to the Logger logger. Progress notification informations
-// are regularly sent to the channel progressNotifications
-func DumpServer(s Host, db *uint8, filter string, nWorkers int, withTTL bool, batchSize int, noscan bool, logger *log.Logger, serializer func([]string) string, progress chan<- ProgressNotification) error {
-	redisURL := RedisURL(s.Host, fmt.Sprint(s.Port))
-	getConnFunc := func(db *uint8) func(network, addr string) (radix.Conn, error) {
-		return func(network, addr string) (radix.Conn, error) {
-			dialOpts, err := redisDialOpts(s.Username, s.Password, s.TlsHandler, db)
-			if err != nil {
-				return nil, err
-			}
+// to the Logger logger, reading them as opts set. Progress notification
+// informations are regularly sent to the channel progressNotifications.
+// Keys that fail to be dumped are returned as DumpErrors.
+// If checkpoint is set, the progress of the dump is saved to it, and
+// databases are dumped from where the checkpoint left them. Keys dumped
+// shortly before the checkpoint was saved can be dumped again.
+func DumpServer(s Host, db *uint8, opts DumpOptions, checkpoint *Checkpoint, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	return DumpServerContext(context.Background(), s, db, opts, checkpoint, logger, serializer, progress)
+}
 
-			return radix.Dial(network, addr, dialOpts...)
-		}
+// DumpServerContext is DumpServer, interrupted when ctx is done. Scanning
+// then stops, keys being dumped are written out, and IncompleteDumpMarker
+// ends the dump. The error of ctx is returned.
+func DumpServerContext(ctx context.Context, s Host, db *uint8, opts DumpOptions, checkpoint *Checkpoint, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	if checkpoint != nil && opts.Noscan {
+		return errors.New("checkpoints require SCAN, and can not be used with KEYS")
 	}
 
+	redisURL := RedisURL(s.Host, fmt.Sprint(s.Port))
+	dumpCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	collector := newErrorCollector(opts.MaxErrors, cancel)
+
 	dbs := []uint8{}
 	if db != AllDBs {
 		dbs = []uint8{*db}
 	} else {
-		client, err := radix.NewPool("tcp", redisURL, nWorkers, radix.PoolConnFunc(getConnFunc(nil)))
+		client, err := radix.NewPool("tcp", redisURL, opts.NWorkers, radix.PoolConnFunc(getConnFunc(s, nil)))
 		if err != nil {
 			return err
 		}
@@ -423,17 +1018,41 @@ func DumpServer(s Host, db *uint8, filter string, nWorkers int, withTTL bool, ba
 		client.Close()
 	}
 
+	var err error
 	for _, db := range dbs {
-		client, err := radix.NewPool("tcp", redisURL, nWorkers, radix.PoolConnFunc(getConnFunc(&db)))
+		if checkpoint != nil {
+			if _, done := checkpoint.cursor(db); done && len(checkpoint.failed(db)) == 0 {
+				continue
+			}
+		}
+
+		var client *radix.Pool
+		client, err = radix.NewPool("tcp", redisURL, opts.NWorkers, radix.PoolConnFunc(getConnFunc(s, &db)))
 		if err != nil {
-			return err
+			break
 		}
 		defer client.Close()
 
-		if err = dumpDB(client, &db, filter, nWorkers, withTTL, batchSize, noscan, logger, serializer, progress); err != nil {
-			return err
+		writeCmd(logger, serializer, []string{"SELECT", fmt.Sprint(db)})
+		err = dumpDB(dumpCtx, client, &db, opts, logger, serializer, checkpoint, collector, progress)
+		if err != nil || dumpCtx.Err() != nil {
+			break
 		}
 	}
 
-	return nil
+	if err != nil || dumpCtx.Err() != nil {
+		writeCmd(logger, serializer, IncompleteDumpMarker)
+	}
+	if checkpoint != nil {
+		if cpErr := checkpoint.Flush(); cpErr != nil && err == nil {
+			err = fmt.Errorf("failed saving checkpoint: %w", cpErr)
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	return collector.err()
 }
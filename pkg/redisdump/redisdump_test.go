@@ -3,14 +3,20 @@ package redisdump
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
 )
 
 func testEqString(a, b []string) bool {
@@ -73,6 +79,46 @@ func TestStringToRedisCmd(t *testing.T) {
 	}
 }
 
+func TestTtlToRedisCmd(t *testing.T) {
+	before := time.Now().UnixMilli()
+	cmd := ttlToRedisCmd("key", 250, AbsoluteTTL)
+	after := time.Now().UnixMilli()
+	if len(cmd) != 3 || cmd[0] != "PEXPIREAT" || cmd[1] != "key" {
+		t.Fatalf("unexpected command %q", cmd)
+	}
+	if expireAt, _ := strconv.ParseInt(cmd[2], 10, 64); expireAt < before+250 || expireAt > after+250 {
+		t.Errorf("expected key to expire 250ms from now, got %q", cmd)
+	}
+
+	if cmd := ttlToRedisCmd("key", 250, RelativeTTL); !testEqString(cmd, []string{"PEXPIRE", "key", "250"}) {
+		t.Errorf("unexpected command %q", cmd)
+	}
+}
+
+func TestRestoreToRedisCmd(t *testing.T) {
+	type testCase struct {
+		key, payload string
+		ttl          int64
+		absTTL       bool
+		hints        []string
+		expected     []string
+	}
+
+	testCases := []testCase{
+		{key: "city", payload: "\x00\x05Paris", ttl: 0, absTTL: true, expected: []string{"RESTORE", "city", "0", "\x00\x05Paris", "REPLACE", "ABSTTL"}},
+		{key: "city", payload: "\x00\x05Paris", ttl: 1700000000123, absTTL: true, expected: []string{"RESTORE", "city", "1700000000123", "\x00\x05Paris", "REPLACE", "ABSTTL"}},
+		{key: "city", payload: "\x00\x05Paris", ttl: 1500, absTTL: false, expected: []string{"RESTORE", "city", "1500", "\x00\x05Paris", "REPLACE"}},
+		{key: "city", payload: "\x00\x05Paris", ttl: 0, absTTL: true, hints: []string{"IDLETIME", "30"}, expected: []string{"RESTORE", "city", "0", "\x00\x05Paris", "REPLACE", "ABSTTL", "IDLETIME", "30"}},
+	}
+
+	for _, test := range testCases {
+		res := restoreToRedisCmd(test.key, test.payload, test.ttl, test.absTTL, test.hints)
+		if !testEqString(res, test.expected) {
+			t.Errorf("Failed generating RESTORE command for: %s, got %q", test.key, res)
+		}
+	}
+}
+
 func TestHashToRedisCmds(t *testing.T) {
 	type testCase struct {
 		key       string
@@ -185,6 +231,104 @@ func TestZsetToRedisCmds(t *testing.T) {
 	}
 }
 
+func TestStreamToRedisCmds(t *testing.T) {
+	type testCase struct {
+		key      string
+		value    Stream
+		expected [][]string
+	}
+
+	testCases := []testCase{
+		{
+			key:      "mystream",
+			value:    Stream{Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1", "a", "2"}}}, LastID: "1-1"},
+			expected: [][]string{{"XADD", "mystream", "1-1", "a", "1", "a", "2"}},
+		},
+		{
+			key:      "mystream",
+			value:    Stream{Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1"}}}, LastID: "5-0"},
+			expected: [][]string{{"XADD", "mystream", "1-1", "a", "1"}, {"XSETID", "mystream", "5-0"}},
+		},
+		{
+			key:      "mystream",
+			value:    Stream{LastID: "5-0"},
+			expected: [][]string{{"XADD", "mystream", "MAXLEN", "0", "*", "", ""}, {"XSETID", "mystream", "5-0"}},
+		},
+		{
+			key:      "mystream",
+			value:    Stream{LastID: "0-0"},
+			expected: [][]string{{"XADD", "mystream", "MAXLEN", "0", "*", "", ""}, {"XSETID", "mystream", "0-0"}},
+		},
+		{
+			key: "mystream",
+			value: Stream{
+				Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1"}}},
+				LastID:  "1-1",
+				Groups: []StreamGroup{
+					{Name: "group1", LastDeliveredID: "1-1", Pending: []StreamPendingEntry{{ID: "1-1", Consumer: "c1", Idle: "10", Deliveries: "1"}}},
+					{Name: "group2", LastDeliveredID: "0-0", EntriesRead: "0"},
+				},
+			},
+			expected: [][]string{
+				{"XADD", "mystream", "1-1", "a", "1"},
+				{"XGROUP", "CREATE", "mystream", "group1", "1-1", "MKSTREAM"},
+				{"XCLAIM", "mystream", "group1", "c1", "0", "1-1", "IDLE", "10", "RETRYCOUNT", "1", "FORCE", "JUSTID"},
+				{"XGROUP", "CREATE", "mystream", "group2", "0-0", "MKSTREAM", "ENTRIESREAD", "0"},
+			},
+		},
+		{
+			key: "mystream",
+			value: Stream{
+				Entries: []StreamEntry{{ID: "1-1", Fields: []string{"a", "1"}}},
+				LastID:  "1-1",
+				Groups: []StreamGroup{
+					{Name: "group1", LastDeliveredID: "1-1", Consumers: []string{"c1", "c2"}, Pending: []StreamPendingEntry{{ID: "1-1", Consumer: "c1", Idle: "10", Deliveries: "1"}}},
+				},
+			},
+			expected: [][]string{
+				{"XADD", "mystream", "1-1", "a", "1"},
+				{"XGROUP", "CREATE", "mystream", "group1", "1-1", "MKSTREAM"},
+				{"XGROUP", "CREATECONSUMER", "mystream", "group1", "c1"},
+				{"XGROUP", "CREATECONSUMER", "mystream", "group1", "c2"},
+				{"XCLAIM", "mystream", "group1", "c1", "0", "1-1", "IDLE", "10", "RETRYCOUNT", "1", "FORCE", "JUSTID"},
+			},
+		},
+	}
+
+	for i, testCase := range testCases {
+		res := streamToRedisCmds(testCase.key, testCase.value)
+		if len(testCase.expected) != len(res) {
+			t.Errorf("test %d: failed generating redis command from stream: got %s", i, res)
+			continue
+		}
+		for j := range res {
+			if !testEqString(res[j], testCase.expected[j]) {
+				t.Errorf("test %d: failed generating redis command from stream: expected %s, got %s", i, testCase.expected[j], res[j])
+			}
+		}
+	}
+}
+
+func TestNextStreamID(t *testing.T) {
+	for i, testCase := range []struct {
+		id       string
+		expected string
+		err      bool
+	}{
+		{"1-0", "1-1", false},
+		{"1526985054069-18446744073709551615", "1526985054070-0", false},
+		{"invalid", "", true},
+	} {
+		next, err := nextStreamID(testCase.id)
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+		if next != testCase.expected {
+			t.Errorf("test %d: expected %s, got %s", i, testCase.expected, next)
+		}
+	}
+}
+
 func TestRESPSerializer(t *testing.T) {
 	type testCase struct {
 		command  []string
@@ -287,9 +431,67 @@ func TestRedisDialOpts(t *testing.T) {
 	}
 }
 
-type mockRadixClient struct{}
+type mockRadixClient struct {
+	multi     bool
+	queued    []*mockRadixAction
+	pipelines int
+	// Keys containing "changing" are lists on their first TYPE
+	typeChecks int
+}
 
 func (m *mockRadixClient) Do(action radix.Action) error {
+	// Commands of a pipeline are run one after the other
+	if v := reflect.ValueOf(action); v.Kind() == reflect.Slice {
+		m.pipelines++
+		for i := 0; i < v.Len(); i++ {
+			if err := m.Do(v.Index(i).Interface().(radix.Action)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if a, ok := action.(*mockRadixAction); ok {
+		// Errors are kept in the reply, rather than returned
+		if reply, ok := a.rcv.(*cmdReply); ok {
+			a.rcv = reply.rcv
+			reply.err = m.Do(a)
+			return nil
+		}
+
+		switch {
+		case a.cmd == "MULTI":
+			m.multi = true
+			return nil
+
+		case a.cmd == "EXEC":
+			// Each queued command replies into the matching EXEC receiver
+			// and the first error is returned once all were run
+			rcvs := a.rcv.(execReply)
+			var execErr error
+			for i, queued := range m.queued {
+				queued.rcv = rcvs[i]
+				if err := queued.Run(nil); err != nil && execErr == nil {
+					execErr = err
+				}
+			}
+			m.multi = false
+			m.queued = nil
+			return execErr
+
+		case m.multi:
+			m.queued = append(m.queued, a)
+			return nil
+
+		case a.cmd == "TYPE" && strings.Contains(a.args[0], "changing"):
+			m.typeChecks++
+			if m.typeChecks == 1 {
+				*a.rcv.(*string) = "list"
+				return nil
+			}
+		}
+	}
+
 	return action.Run(nil)
 }
 func (m *mockRadixClient) Close() error {
@@ -328,11 +530,32 @@ func (m *mockRadixAction) Run(conn radix.Conn) error {
 				*v = "zset"
 			}
 		}
+		if strings.Contains(key, "stream") {
+			switch v := m.rcv.(type) {
+			case *string:
+				*v = "stream"
+			}
+		}
+		if strings.Contains(key, "module") {
+			switch v := m.rcv.(type) {
+			case *string:
+				*v = "ReJSON-RL"
+			}
+		}
+		if strings.Contains(key, "deleted") {
+			switch v := m.rcv.(type) {
+			case *string:
+				*v = "none"
+			}
+		}
 
 		return nil
 	}
 
 	if m.cmd == "GET" {
+		if strings.Contains(m.args[0], "failing") {
+			return fmt.Errorf("ERR failed reading %s", m.args[0])
+		}
 		switch v := m.rcv.(type) {
 		case *string:
 			*v = "stringvalue"
@@ -352,6 +575,43 @@ func (m *mockRadixAction) Run(conn radix.Conn) error {
 		return nil
 	}
 
+	if m.cmd == "PTTL" {
+		switch v := m.rcv.(type) {
+		case *int64:
+			*v = 5000
+		}
+
+		return nil
+	}
+
+	if m.cmd == "DUMP" {
+		switch v := m.rcv.(type) {
+		case *string:
+			if !strings.Contains(m.args[0], "missing") {
+				*v = "payload"
+			}
+		}
+
+		return nil
+	}
+
+	if m.cmd == "SENTINEL" {
+		switch v := m.rcv.(type) {
+		case *[]string:
+			if m.args[1] == "mymaster" {
+				*v = []string{"10.0.0.1", "6379"}
+			}
+		case *[]map[string]string:
+			// Sentinels of Redis 4 only know SENTINEL SLAVES
+			if m.args[0] == "REPLICAS" && m.args[1] == "redis4" {
+				return resp2.Error{E: errors.New("ERR Unknown sentinel subcommand 'replicas'")}
+			}
+			*v = []map[string]string{{"ip": "10.0.0.2", "port": "6380", "flags": "slave", "master-link-status": "ok", "slave-repl-offset": "10"}}
+		}
+
+		return nil
+	}
+
 	if m.cmd == "KEYS" {
 		switch v := m.rcv.(type) {
 		case *[]string:
@@ -362,9 +622,57 @@ func (m *mockRadixAction) Run(conn radix.Conn) error {
 		return nil
 	}
 
+	if m.cmd == "SCAN" {
+		switch v := m.rcv.(type) {
+		case *scanResult:
+			// Keys are returned over two pages
+			if m.args[0] == "0" {
+				*v = scanResult{cursor: "3", elements: []string{"key1", "key2", "key3"}}
+			} else {
+				*v = scanResult{cursor: "0", elements: []string{"key4", "key5"}}
+			}
+		}
+
+		return nil
+	}
+
+	if m.cmd == "LLEN" || m.cmd == "ZCARD" || m.cmd == "XLEN" {
+		switch v := m.rcv.(type) {
+		case *int:
+			if strings.Contains(m.args[0], "huge") {
+				*v = 6
+			} else {
+				*v = 2
+			}
+		}
+
+		return nil
+	}
+
+	if m.cmd == "ZSCAN" {
+		switch v := m.rcv.(type) {
+		case *scanResult:
+			if m.args[1] == "0" {
+				*v = scanResult{cursor: "7", elements: []string{"member1", "1", "member2", "2"}}
+			} else {
+				*v = scanResult{cursor: "0", elements: []string{"member3", "3"}}
+			}
+		}
+
+		return nil
+	}
+
 	if m.cmd == "LRANGE" {
 		switch v := m.rcv.(type) {
 		case *[]string:
+			if strings.Contains(m.args[0], "huge") {
+				// Lists of 6 elements, read in windows
+				a := []string{"item1", "item2", "item3", "item4", "item5", "item6"}
+				start, _ := strconv.Atoi(m.args[1])
+				end, _ := strconv.Atoi(m.args[2])
+				*v = a[min(start, len(a)):min(end+1, len(a))]
+				return nil
+			}
 			a := []string{"listkey1", "listval1", "listkey2", "listval2"}
 			*v = a
 
@@ -386,6 +694,63 @@ func (m *mockRadixAction) Run(conn radix.Conn) error {
 		return nil
 	}
 
+	if m.cmd == "XRANGE" {
+		switch v := m.rcv.(type) {
+		case *[]StreamEntry:
+			if strings.Contains(m.args[0], "huge") {
+				// Streams of 6 entries, read in pages
+				start := 1
+				if m.args[1] != "-" {
+					start, _ = strconv.Atoi(strings.TrimSuffix(m.args[1], "-1"))
+					start++
+				}
+				count, _ := strconv.Atoi(m.args[4])
+				for i := start; i <= 6 && len(*v) < count; i++ {
+					*v = append(*v, StreamEntry{ID: fmt.Sprintf("%d-0", i), Fields: []string{"field", fmt.Sprint(i)}})
+				}
+				return nil
+			}
+			if m.args[1] == "-" {
+				*v = []StreamEntry{{ID: "1-0", Fields: []string{"field1", "value1"}}, {ID: "2-0", Fields: []string{"field2", "value2"}}}
+			}
+
+		default:
+			fmt.Printf("ERROR")
+		}
+		return nil
+	}
+
+	if m.cmd == "XINFO" {
+		switch v := m.rcv.(type) {
+		case *map[string]interface{}:
+			*v = map[string]interface{}{"length": int64(2), "last-generated-id": []byte("3-0")}
+			if strings.Contains(m.args[1], "huge") {
+				*v = map[string]interface{}{"length": int64(6), "last-generated-id": []byte("6-0")}
+			}
+		case *[]map[string]string:
+			if m.args[0] == "CONSUMERS" {
+				*v = []map[string]string{{"name": "consumer1"}, {"name": "consumer2"}}
+				break
+			}
+			*v = []map[string]string{{"name": "group1", "last-delivered-id": "1-0", "entries-read": "1"}}
+
+		default:
+			fmt.Printf("ERROR")
+		}
+		return nil
+	}
+
+	if m.cmd == "XPENDING" {
+		switch v := m.rcv.(type) {
+		case *[][]string:
+			*v = [][]string{{"1-0", "consumer1", "1000", "2"}}
+
+		default:
+			fmt.Printf("ERROR")
+		}
+		return nil
+	}
+
 	return nil
 }
 
@@ -407,45 +772,300 @@ func getMockRadixAction(rcv interface{}, cmd string, args ...string) radix.CmdAc
 
 func TestDumpKeys(t *testing.T) {
 	for i, testCase := range []struct {
-		keys        []string
-		withTTL     bool
-		expectMatch string
+		keys           []string
+		ttlMode        TTLMode
+		chunkThreshold int
+		dumpPayloads   bool
+		expectMatch    string
 	}{
 		{
 			[]string{"somestring"},
+			NoTTL,
+			-1,
 			false,
 			"^SET somestring stringvalue\n$",
 		},
 		{
 			[]string{"somestring", "somelist"},
+			NoTTL,
+			-1,
 			false,
 			"^SET somestring stringvalue\nRPUSH somelist listkey1 listval1 listkey2 listval2\n$",
 		},
 		{
 			[]string{"somestring"},
-			true,
-			"^SET somestring stringvalue\nEXPIREAT somestring [0-9]+\n$",
+			AbsoluteTTL,
+			-1,
+			false,
+			"^SET somestring stringvalue\nPEXPIREAT somestring [0-9]{13}\n$",
 		},
 		{
 			[]string{"somezset"},
+			NoTTL,
+			-1,
 			false,
 			"^ZADD somezset 1 listkey1 2 listkey2\n$",
 		},
+		{
+			[]string{"somestream"},
+			NoTTL,
+			-1,
+			false,
+			"^XADD somestream 1-0 field1 value1\nXADD somestream 2-0 field2 value2\nXSETID somestream 3-0\nXGROUP CREATE somestream group1 1-0 MKSTREAM ENTRIESREAD 1\nXGROUP CREATECONSUMER somestream group1 consumer1\nXGROUP CREATECONSUMER somestream group1 consumer2\nXCLAIM somestream group1 consumer1 0 1-0 IDLE 1000 RETRYCOUNT 2 FORCE JUSTID\n$",
+		},
+		{
+			[]string{"somestring", "somemissingkey"},
+			NoTTL,
+			-1,
+			true,
+			"^RESTORE somestring 0 payload REPLACE ABSTTL\n$",
+		},
+		{
+			[]string{"somestream"},
+			AbsoluteTTL,
+			-1,
+			true,
+			"^RESTORE somestream [0-9]{13} payload REPLACE ABSTTL\n$",
+		},
+		{
+			[]string{"somestring", "somelist"},
+			NoTTL,
+			2,
+			false,
+			"^SET somestring stringvalue\nRPUSH somelist listkey1 listval1 listkey2 listval2\n$",
+		},
+		{
+			[]string{"somehugelist"},
+			NoTTL,
+			2,
+			false,
+			"^RPUSH somehugelist item1 item2 item3 item4 item5\nRPUSH somehugelist item6\n$",
+		},
+		{
+			[]string{"somehugezset"},
+			NoTTL,
+			2,
+			false,
+			"^ZADD somehugezset 1 member1 2 member2\nZADD somehugezset 3 member3\n$",
+		},
+		{
+			[]string{"somehugezset"},
+			NoTTL,
+			-1,
+			false,
+			"^ZADD somehugezset 1 listkey1 2 listkey2\n$",
+		},
+		{
+			[]string{"somestring"},
+			RelativeTTL,
+			-1,
+			false,
+			"^SET somestring stringvalue\nPEXPIRE somestring 5000\n$",
+		},
+		{
+			[]string{"somehugezset"},
+			RelativeTTL,
+			2,
+			false,
+			"^ZADD somehugezset 1 member1 2 member2\nZADD somehugezset 3 member3\nPEXPIRE somehugezset 5000\n$",
+		},
+		{
+			[]string{"somehugestream"},
+			RelativeTTL,
+			2,
+			false,
+			"^XADD somehugestream 1-0 field 1\nXADD somehugestream 2-0 field 2\nXADD somehugestream 3-0 field 3\nXADD somehugestream 4-0 field 4\nXADD somehugestream 5-0 field 5\nXADD somehugestream 6-0 field 6\nXGROUP CREATE somehugestream group1 1-0 MKSTREAM ENTRIESREAD 1\nXGROUP CREATECONSUMER somehugestream group1 consumer1\nXGROUP CREATECONSUMER somehugestream group1 consumer2\nXCLAIM somehugestream group1 consumer1 0 1-0 IDLE 1000 RETRYCOUNT 2 FORCE JUSTID\nPEXPIRE somehugestream 5000\n$",
+		},
+		{
+			[]string{"somestring"},
+			RelativeTTL,
+			-1,
+			true,
+			"^RESTORE somestring 5000 payload REPLACE\n$",
+		},
+	} {
+		for _, atomic := range []bool{false, true} {
+			var m mockRadixClient
+			var b bytes.Buffer
+			l := log.New(&b, "", 0)
+			err := dumpKeys(context.Background(), &m, getMockRadixAction, 0, testCase.keys, DumpOptions{TTLMode: testCase.ttlMode, BatchSize: 5, ChunkThreshold: testCase.chunkThreshold, Atomic: atomic, DumpPayloads: testCase.dumpPayloads}, l, CmdSerializer(RedisCmdSerializer))
+			if err != nil {
+				t.Errorf("received error %+v", err)
+			}
+			match, _ := regexp.MatchString(testCase.expectMatch, b.String())
+			if !match {
+				t.Errorf("test %d, atomic %t: expected to match %s, got %s", i, atomic, testCase.expectMatch, b.String())
+			}
+		}
+	}
+}
+
+func TestDumpKeysErrors(t *testing.T) {
+	var m mockRadixClient
+	var b bytes.Buffer
+	l := log.New(&b, "", 0)
+
+	err := dumpKeys(context.Background(), &m, getMockRadixAction, 0, []string{"somemodule", "somestring", "othermodule"}, DumpOptions{TTLMode: NoTTL, BatchSize: 5, ChunkThreshold: -1}, l, CmdSerializer(RedisCmdSerializer))
+	dumpErrs, ok := err.(*DumpErrors)
+	if !ok {
+		t.Fatalf("expected DumpErrors, got %+v", err)
+	}
+	if len(dumpErrs.Keys) != 2 || dumpErrs.Keys[0].Key != "somemodule" || dumpErrs.Keys[1].Key != "othermodule" {
+		t.Errorf("unexpected failed keys: %+v", dumpErrs.Keys)
+	}
+
+	// Keys following a failed key are still dumped
+	if b.String() != "SET somestring stringvalue\n" {
+		t.Errorf("expected somestring to be dumped, got %s", b.String())
+	}
+}
+
+func TestDumpKeysCancelled(t *testing.T) {
+	var m mockRadixClient
+	var b bytes.Buffer
+	l := log.New(&b, "", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := dumpKeys(ctx, &m, getMockRadixAction, 0, []string{"somestring", "somelist"}, DumpOptions{TTLMode: NoTTL, BatchSize: 5, ChunkThreshold: -1}, l, CmdSerializer(RedisCmdSerializer)); err != nil {
+		t.Errorf("received error %+v", err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("expected no key to be dumped once cancelled, got %s", b.String())
+	}
+}
+
+func TestDumpKeysPipelined(t *testing.T) {
+	for i, testCase := range []struct {
+		chunkThreshold int
+		pipelines      int
+	}{
+		// Types, then values
+		{-1, 2},
+		// Types, lengths, then values
+		{100, 3},
 	} {
 		var m mockRadixClient
 		var b bytes.Buffer
 		l := log.New(&b, "", 0)
-		err := dumpKeys(&m, getMockRadixAction, testCase.keys, testCase.withTTL, 5, l, RedisCmdSerializer)
-		if err != nil {
-			t.Errorf("received error %+v", err)
+
+		// A key failing to be read does not fail the rest of the batch
+		err := dumpKeys(context.Background(), &m, getMockRadixAction, 0, []string{"somestring", "somefailingstring", "somelist", "somezset"}, DumpOptions{TTLMode: AbsoluteTTL, BatchSize: 5, ChunkThreshold: testCase.chunkThreshold}, l, CmdSerializer(RedisCmdSerializer))
+		dumpErrs, ok := err.(*DumpErrors)
+		if !ok || len(dumpErrs.Keys) != 1 || dumpErrs.Keys[0].Key != "somefailingstring" {
+			t.Errorf("test %d: expected somefailingstring to fail, got %+v", i, err)
+		}
+
+		expected := "^SET somestring stringvalue\nPEXPIREAT somestring [0-9]{13}\nRPUSH somelist listkey1 listval1 listkey2 listval2\nPEXPIREAT somelist [0-9]{13}\nZADD somezset 1 listkey1 2 listkey2\nPEXPIREAT somezset [0-9]{13}\n$"
+		if match, _ := regexp.MatchString(expected, b.String()); !match {
+			t.Errorf("test %d: expected to match %s, got %s", i, expected, b.String())
+		}
+		if m.pipelines != testCase.pipelines {
+			t.Errorf("test %d: expected keys to be read with %d pipelines, got %d", i, testCase.pipelines, m.pipelines)
 		}
-		match, _ := regexp.MatchString(testCase.expectMatch, b.String())
-		if !match {
+	}
+}
+
+func TestDumpKeysTypeChanged(t *testing.T) {
+	// The key is first seen as a list, but is a string when read
+	for i, testCase := range []struct {
+		atomic      bool
+		expectMatch string
+	}{
+		{false, "^RPUSH somechangingstring listkey1 listval1 listkey2 listval2\n$"},
+		{true, "^SET somechangingstring stringvalue\n$"},
+	} {
+		var m mockRadixClient
+		var b bytes.Buffer
+		l := log.New(&b, "", 0)
+		if err := dumpKeys(context.Background(), &m, getMockRadixAction, 0, []string{"somechangingstring"}, DumpOptions{TTLMode: NoTTL, BatchSize: 5, ChunkThreshold: -1, Atomic: testCase.atomic}, l, CmdSerializer(RedisCmdSerializer)); err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+		}
+		if match, _ := regexp.MatchString(testCase.expectMatch, b.String()); !match {
 			t.Errorf("test %d: expected to match %s, got %s", i, testCase.expectMatch, b.String())
 		}
 	}
 }
 
+func TestErrorCollector(t *testing.T) {
+	for i, testCase := range []struct {
+		maxErrors int
+		nErrors   int
+		aborted   bool
+	}{
+		{-1, 0, false},
+		{-1, 10, false},
+		{0, 1, true},
+		{3, 3, false},
+		{3, 4, true},
+		// Keys are counted, but only the first ones are listed
+		{-1, maxKeyErrors + 10, false},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		c := newErrorCollector(testCase.maxErrors, cancel)
+		for j := 0; j < testCase.nErrors; j++ {
+			c.add(KeyError{Db: 0, Key: fmt.Sprintf("key%d", j), Err: fmt.Errorf("failed")})
+		}
+
+		if (ctx.Err() != nil) != testCase.aborted {
+			t.Errorf("test %d: expected aborted to be %t", i, testCase.aborted)
+		}
+
+		err := c.err()
+		if testCase.nErrors == 0 {
+			if err != nil {
+				t.Errorf("test %d: expected no error, got %s", i, err)
+			}
+			cancel()
+			continue
+		}
+
+		dumpErrs, ok := err.(*DumpErrors)
+		if !ok {
+			t.Errorf("test %d: expected DumpErrors, got %+v", i, err)
+		} else if len(dumpErrs.Keys) != min(testCase.nErrors, maxKeyErrors) || dumpErrs.Total() != testCase.nErrors || dumpErrs.Aborted != testCase.aborted {
+			t.Errorf("test %d: unexpected DumpErrors, %d keys listed, %d failed, aborted %t", i, len(dumpErrs.Keys), dumpErrs.Total(), dumpErrs.Aborted)
+		}
+		cancel()
+	}
+}
+
+func TestErrorCollectorAddErrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := newErrorCollector(2, cancel)
+	c.addErrors(&DumpErrors{Keys: []KeyError{{Db: 1, Key: "a"}}, Failed: map[uint8]int{1: 2}})
+	if ctx.Err() != nil {
+		t.Errorf("expected the dump not to be aborted after 2 errors")
+	}
+	c.add(KeyError{Db: 0, Key: "b"})
+
+	dumpErrs, ok := c.err().(*DumpErrors)
+	if !ok {
+		t.Fatalf("expected DumpErrors, got %+v", c.err())
+	}
+	if len(dumpErrs.Keys) != 2 || !reflect.DeepEqual(dumpErrs.Failed, map[uint8]int{0: 1, 1: 2}) || !dumpErrs.Aborted || ctx.Err() == nil {
+		t.Errorf("unexpected DumpErrors %+v", dumpErrs)
+	}
+}
+
+func TestDumpCompleted(t *testing.T) {
+	for i, testCase := range []struct {
+		err      error
+		expected bool
+	}{
+		{nil, true},
+		{&DumpErrors{Keys: []KeyError{{Key: "a"}}}, true},
+		{fmt.Errorf("dumping db 0: %w", &DumpErrors{Keys: []KeyError{{Key: "a"}}}), true},
+		{&DumpErrors{Keys: []KeyError{{Key: "a"}}, Aborted: true}, false},
+		{context.Canceled, false},
+	} {
+		if completed := DumpCompleted(testCase.err); completed != testCase.expected {
+			t.Errorf("test %d: expected %t, got %t", i, testCase.expected, completed)
+		}
+	}
+}
+
 func TestScanKeysLegacy(t *testing.T) {
 	for i, testCase := range []struct {
 		n     int
@@ -469,18 +1089,18 @@ func TestScanKeysLegacy(t *testing.T) {
 		},
 	} {
 		var m mockRadixClient
-		keyBatches := make(chan []string)
+		keyBatches := make(chan keyBatch)
 
 		n := 0
 		done := make(chan bool)
 		go func() {
 			for b := range keyBatches {
-				n += len(b)
+				n += len(b.keys)
 			}
 			done <- true
 		}()
 
-		err := scanKeysLegacy(&m, getMockRadixAction, 0, 100, "*", keyBatches, nil)
+		err := scanKeysLegacy(context.Background(), &m, getMockRadixAction, 0, 100, "*", "0", nil, keyBatches, nil)
 		close(keyBatches)
 		<-done
 		if err != testCase.err {
@@ -491,3 +1111,65 @@ func TestScanKeysLegacy(t *testing.T) {
 		}
 	}
 }
+
+func TestScanKeys(t *testing.T) {
+	for i, testCase := range []struct {
+		cursor  string
+		keys    []string
+		cursors []string
+	}{
+		{"0", []string{"key1", "key2", "key3", "key4", "key5"}, []string{"3", "0"}},
+		{"3", []string{"key4", "key5"}, []string{"0"}},
+	} {
+		var m mockRadixClient
+		var cursors []string
+		tracker := newCursorTracker(func(cursor string) {
+			cursors = append(cursors, cursor)
+		})
+		keyBatches := make(chan keyBatch)
+
+		var keys []string
+		done := make(chan bool)
+		go func() {
+			for b := range keyBatches {
+				keys = append(keys, b.keys...)
+				tracker.done(b.seq)
+			}
+			done <- true
+		}()
+
+		err := scanKeys(context.Background(), &m, getMockRadixAction, 0, 100, "*", testCase.cursor, tracker, keyBatches, nil)
+		close(keyBatches)
+		<-done
+		if err != nil {
+			t.Errorf("test %d: unexpected error %s", i, err)
+		}
+		if !testEqString(keys, testCase.keys) {
+			t.Errorf("test %d: expected keys %q, got %q", i, testCase.keys, keys)
+		}
+		if !testEqString(cursors, testCase.cursors) {
+			t.Errorf("test %d: expected cursors %q, got %q", i, testCase.cursors, cursors)
+		}
+	}
+}
+
+func TestExecReply(t *testing.T) {
+	var val string
+	var pttl int64
+	br := bufio.NewReader(strings.NewReader("*2\r\n$5\r\nvalue\r\n:1500\r\n+NEXT\r\n"))
+	if err := (execReply{&val, &pttl}).UnmarshalRESP(br); err != nil {
+		t.Fatalf("unexpected error %s", err)
+	}
+	if val != "value" || pttl != 1500 {
+		t.Errorf("unexpected replies %q, %d", val, pttl)
+	}
+
+	// Errors are returned once all replies were read
+	br = bufio.NewReader(strings.NewReader("*2\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n:1500\r\n+NEXT\r\n"))
+	if err := (execReply{&val, &pttl}).UnmarshalRESP(br); err == nil {
+		t.Errorf("expected an error")
+	}
+	if next, _ := br.ReadString('\n'); next != "+NEXT\r\n" {
+		t.Errorf("reply not fully read, next line is %q", next)
+	}
+}
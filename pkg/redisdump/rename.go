@@ -0,0 +1,155 @@
+package redisdump
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KeyRenamer returns the function renaming keys: stripPrefix is removed
+// from the start of key names, the matches of pattern - if set - are then
+// replaced with replacement, which can refer to submatches as $1, and
+// addPrefix is added to the start of key names.
+func KeyRenamer(stripPrefix string, pattern *regexp.Regexp, replacement string, addPrefix string) func(key string) string {
+	return func(key string) string {
+		key = strings.TrimPrefix(key, stripPrefix)
+		if pattern != nil {
+			key = pattern.ReplaceAllString(key, replacement)
+		}
+		return addPrefix + key
+	}
+}
+
+// cmdKeyIndexes returns the positions of the keys of a command. Besides
+// the commands of a dump, it knows the commands with several keys a server
+// replicates most, and the ones without keys.
+func cmdKeyIndexes(cmd []string) []int {
+	if len(cmd) < 2 {
+		return nil
+	}
+
+	var indexes []int
+	switch strings.ToUpper(cmd[0]) {
+	case "SELECT", "SWAPDB", "FLUSHDB", "FLUSHALL", "MULTI", "EXEC", "ECHO", "PING", "PUBLISH", "SCRIPT", "FUNCTION":
+	case "DEL", "UNLINK", "EXISTS", "TOUCH":
+		for i := 1; i < len(cmd); i++ {
+			indexes = append(indexes, i)
+		}
+	case "MSET", "MSETNX":
+		for i := 1; i < len(cmd); i += 2 {
+			indexes = append(indexes, i)
+		}
+	case "RENAME", "RENAMENX", "COPY", "SMOVE", "LMOVE", "RPOPLPUSH":
+		if len(cmd) > 2 {
+			indexes = []int{1, 2}
+		}
+	default:
+		if i := cmdKeyIndex(cmd); i >= 0 {
+			indexes = []int{i}
+		}
+	}
+	return indexes
+}
+
+// ParseDBMapping parses the databases keys are written to, given as
+// source:target or source->target pairs separated by commas, such as
+// "0:1,2:3" or "0->5,1->6"
+func ParseDBMapping(mapping string) (map[uint8]uint8, error) {
+	dbs := map[uint8]uint8{}
+	if mapping == "" {
+		return dbs, nil
+	}
+
+	for _, pair := range strings.Split(mapping, ",") {
+		from, to, ok := strings.Cut(strings.TrimSpace(pair), "->")
+		if !ok {
+			from, to, ok = strings.Cut(strings.TrimSpace(pair), ":")
+		}
+		src, srcErr := strconv.ParseUint(from, 10, 8)
+		dst, dstErr := strconv.ParseUint(to, 10, 8)
+		if !ok || srcErr != nil || dstErr != nil {
+			return nil, fmt.Errorf("invalid database mapping %q, expected source:target", pair)
+		}
+		dbs[uint8(src)] = uint8(dst)
+	}
+	return dbs, nil
+}
+
+// RenameSerializer writes the keys of a dump serialized with Serializer
+// under other names, and in other databases: a dump can be restored under
+// a new namespace. Keys are renamed in all their commands, including the
+// TTL, and databases in the SELECT commands.
+type RenameSerializer struct {
+	Serializer
+	rename func(key string) string
+	dbs    map[uint8]uint8
+}
+
+// NewRenameSerializer renames the keys serialized with s with rename, if
+// it is set, and writes them to the database dbs maps the database they
+// were read from to - the same database if it is not mapped.
+func NewRenameSerializer(s Serializer, rename func(key string) string, dbs map[uint8]uint8) *RenameSerializer {
+	return &RenameSerializer{
+		Serializer: s,
+		rename:     rename,
+		dbs:        dbs,
+	}
+}
+
+func (r *RenameSerializer) db(db uint8) uint8 {
+	if mapped, ok := r.dbs[db]; ok {
+		return mapped
+	}
+	return db
+}
+
+func (r *RenameSerializer) renameCmd(cmd []string) []string {
+	if r.rename == nil {
+		return cmd
+	}
+	renamed := append([]string{}, cmd...)
+	for _, i := range cmdKeyIndexes(cmd) {
+		renamed[i] = r.rename(cmd[i])
+	}
+	return renamed
+}
+
+// Key implements the Serializer interface.
+func (r *RenameSerializer) Key(k *Key) string {
+	renamed := *k
+	renamed.Db = r.db(k.Db)
+	if r.rename != nil {
+		renamed.Name = r.rename(k.Name)
+		renamed.Cmds = make([][]string, len(k.Cmds))
+		for i, cmd := range k.Cmds {
+			renamed.Cmds[i] = r.renameCmd(cmd)
+		}
+	}
+	return r.Serializer.Key(&renamed)
+}
+
+// Cmd implements the Serializer interface. Commands replicated with
+// several keys are only renamed if cmdKeyIndexes knows them, the first key
+// is renamed otherwise.
+func (r *RenameSerializer) Cmd(cmd []string) string {
+	if isIncompleteDumpMarker(cmd) {
+		return r.Serializer.Cmd(cmd)
+	}
+
+	switch {
+	case len(cmd) == 2 && strings.ToUpper(cmd[0]) == "SELECT":
+		if db, err := strconv.ParseUint(cmd[1], 10, 8); err == nil {
+			cmd = []string{cmd[0], fmt.Sprint(r.db(uint8(db)))}
+		}
+	case len(cmd) == 3 && strings.ToUpper(cmd[0]) == "SWAPDB":
+		db1, err1 := strconv.ParseUint(cmd[1], 10, 8)
+		db2, err2 := strconv.ParseUint(cmd[2], 10, 8)
+		if err1 == nil && err2 == nil {
+			cmd = []string{cmd[0], fmt.Sprint(r.db(uint8(db1))), fmt.Sprint(r.db(uint8(db2)))}
+		}
+	default:
+		cmd = r.renameCmd(cmd)
+	}
+	return r.Serializer.Cmd(cmd)
+}
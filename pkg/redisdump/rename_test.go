@@ -0,0 +1,103 @@
+package redisdump
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestKeyRenamer(t *testing.T) {
+	for i, testCase := range []struct {
+		stripPrefix string
+		pattern     string
+		replacement string
+		addPrefix   string
+		key         string
+		expected    string
+	}{
+		{"", "", "", "staging:", "user:1", "staging:user:1"},
+		{"prod:", "", "", "", "prod:user:1", "user:1"},
+		{"prod:", "", "", "", "user:1", "user:1"},
+		{"prod:", "^user:([0-9]+)$", "u:$1", "staging:", "prod:user:1", "staging:u:1"},
+		{"", "-", "_", "", "a-b-c", "a_b_c"},
+	} {
+		var pattern *regexp.Regexp
+		if testCase.pattern != "" {
+			pattern = regexp.MustCompile(testCase.pattern)
+		}
+		rename := KeyRenamer(testCase.stripPrefix, pattern, testCase.replacement, testCase.addPrefix)
+		if key := rename(testCase.key); key != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, key)
+		}
+	}
+}
+
+func TestParseDBMapping(t *testing.T) {
+	for i, testCase := range []struct {
+		mapping  string
+		expected map[uint8]uint8
+		err      bool
+	}{
+		{"", map[uint8]uint8{}, false},
+		{"0:1", map[uint8]uint8{0: 1}, false},
+		{"0:1, 2:0", map[uint8]uint8{0: 1, 2: 0}, false},
+		{"0->5,1->6", map[uint8]uint8{0: 5, 1: 6}, false},
+		{"0->", nil, true},
+		{"0", nil, true},
+		{"0:256", nil, true},
+		{"a:1", nil, true},
+	} {
+		dbs, err := ParseDBMapping(testCase.mapping)
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if !testCase.err && !reflect.DeepEqual(dbs, testCase.expected) {
+			t.Errorf("test %d: expected %v, got %v", i, testCase.expected, dbs)
+		}
+	}
+}
+
+func TestRenameSerializer(t *testing.T) {
+	rename := KeyRenamer("", nil, "", "new:")
+	for i, testCase := range []struct {
+		write    func(logger *log.Logger, s Serializer)
+		expected string
+	}{
+		{
+			func(logger *log.Logger, s Serializer) {
+				writeCmd(logger, s, []string{"SELECT", "0"})
+				writeKey(logger, s, &Key{Db: 0, Name: "k", Cmds: [][]string{{"SET", "k", "v"}, {"PEXPIREAT", "k", "1700000000000"}}})
+			},
+			"SELECT 5\nSET new:k v\nPEXPIREAT new:k 1700000000000\n",
+		},
+		{
+			func(logger *log.Logger, s Serializer) {
+				writeCmd(logger, s, []string{"SELECT", "1"})
+				writeKey(logger, s, &Key{Db: 1, Name: "s", Cmds: [][]string{{"XADD", "s", "1-1", "f", "v"}, {"XGROUP", "CREATE", "s", "g", "0", "MKSTREAM"}}})
+			},
+			"SELECT 1\nXADD new:s 1-1 f v\nXGROUP CREATE new:s g 0 MKSTREAM\n",
+		},
+		{
+			func(logger *log.Logger, s Serializer) {
+				writeCmd(logger, s, []string{"DEL", "a", "b"})
+				writeCmd(logger, s, []string{"MSET", "a", "1", "b", "2"})
+				writeCmd(logger, s, []string{"RENAME", "a", "b"})
+				writeCmd(logger, s, []string{"SWAPDB", "0", "1"})
+				writeCmd(logger, s, []string{"FLUSHALL", "ASYNC"})
+				writeCmd(logger, s, IncompleteDumpMarker)
+			},
+			"DEL new:a new:b\nMSET new:a 1 new:b 2\nRENAME new:a new:b\nSWAPDB 5 1\nFLUSHALL ASYNC\n" + RedisCmdSerializer(IncompleteDumpMarker) + "\n",
+		},
+	} {
+		var b bytes.Buffer
+		logger := log.New(&b, "", 0)
+		s := NewRenameSerializer(CmdSerializer(RedisCmdSerializer), rename, map[uint8]uint8{0: 5})
+		testCase.write(logger, s)
+		if b.String() != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, b.String())
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package redisdump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// replicationAckInterval is how often the replication offset is
+// acknowledged to the server, which disconnects replicas that stop
+// acknowledging
+var replicationAckInterval = time.Second
+
+// eofMarkReader reads a snapshot sent without its length, as servers
+// transferring snapshots without writing them to disk do: the snapshot is
+// followed by a mark of 40 random bytes instead.
+type eofMarkReader struct {
+	r       *bufio.Reader
+	mark    []byte
+	held    []byte
+	pending []byte
+	done    bool
+}
+
+func (m *eofMarkReader) Read(p []byte) (int, error) {
+	for len(m.pending) == 0 {
+		if m.done {
+			return 0, io.EOF
+		}
+		if _, err := m.r.Peek(1); err != nil {
+			return 0, noEOF(err)
+		}
+
+		// Only bytes up to the mark are consumed, the commands that follow
+		// it are left in the buffer
+		chunk, _ := m.r.Peek(m.r.Buffered())
+		data := append(m.held, chunk...)
+		if i := bytes.Index(data, m.mark); i >= 0 {
+			m.r.Discard(i + len(m.mark) - len(m.held))
+			m.pending, m.held, m.done = data[:i], nil, true
+			continue
+		}
+		m.r.Discard(len(chunk))
+		keep := min(len(data), len(m.mark)-1)
+		m.pending, m.held = data[:len(data)-keep], append([]byte{}, data[len(data)-keep:]...)
+	}
+
+	n := copy(p, m.pending)
+	m.pending = m.pending[n:]
+	return n, nil
+}
+
+// readReplyLine reads a line sent by the server, skipping the empty lines
+// it sends to keep the connection alive while it prepares the snapshot
+func readReplyLine(br *bufio.Reader) (string, error) {
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", noEOF(err)
+		}
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			return line, nil
+		}
+	}
+}
+
+// readSnapshotHeader reads the reply to PSYNC, and returns the snapshot
+// that follows it, and the replication offset it was taken at
+func readSnapshotHeader(br *bufio.Reader) (io.Reader, int64, error) {
+	line, err := readReplyLine(br)
+	if err != nil {
+		return nil, 0, err
+	}
+	if strings.HasPrefix(line, "-") {
+		return nil, 0, fmt.Errorf("PSYNC failed: %s", line[1:])
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		return nil, 0, fmt.Errorf("unexpected reply to PSYNC %q", line)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("unexpected reply to PSYNC %q", line)
+	}
+
+	if line, err = readReplyLine(br); err != nil {
+		return nil, 0, err
+	}
+	if mark, ok := strings.CutPrefix(line, "$EOF:"); ok && len(mark) == 40 {
+		return &eofMarkReader{r: br, mark: []byte(mark)}, offset, nil
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(line, "$"), 10, 64)
+	if !strings.HasPrefix(line, "$") || err != nil || size < 0 {
+		return nil, 0, fmt.Errorf("unexpected snapshot header %q", line)
+	}
+	return io.LimitReader(br, size), offset, nil
+}
+
+// respLen returns the size of cmd written as RESP, as the server
+// replicates commands
+func respLen(cmd []string) int64 {
+	n := len(strconv.Itoa(len(cmd))) + 3
+	for _, arg := range cmd {
+		n += len(strconv.Itoa(len(arg))) + 3 + len(arg) + 2
+	}
+	return int64(n)
+}
+
+// followReplication writes the commands the server replicates, in db or in
+// all databases if db is AllDBs, until reading fails. offset is increased
+// by the size of each command read.
+func followReplication(br *bufio.Reader, db *uint8, offset *atomic.Int64, logger *log.Logger, serializer Serializer) error {
+	cr := &cmdReader{r: br}
+	selected, written := 0, -1
+	for {
+		cmd, err := cr.nextRESP()
+		if err != nil {
+			return err
+		}
+		offset.Add(respLen(cmd))
+		if len(cmd) == 0 {
+			continue
+		}
+
+		switch strings.ToUpper(cmd[0]) {
+		case "PING", "REPLCONF":
+			continue
+		case "SELECT":
+			if len(cmd) == 2 {
+				selected, _ = strconv.Atoi(cmd[1])
+			}
+			continue
+		}
+		if db != AllDBs && selected != int(*db) {
+			continue
+		}
+
+		if selected != written {
+			writeCmd(logger, serializer, []string{"SELECT", fmt.Sprint(selected)})
+			written = selected
+		}
+		writeCmd(logger, serializer, cmd)
+	}
+}
+
+// DumpReplication dumps the server s as a replica copies it: the server is
+// asked for a snapshot with PSYNC, and the RDB file it sends is converted
+// as ConvertRDB does. Unlike keys read with SCAN, the snapshot is
+// consistent, taken at a single point in time.
+// If follow is set, the commands the server replicates after the snapshot
+// are then written as they are received, until ctx is done: the output
+// follows the changes made to the server. The commands replicated are
+// written for the keys of db, not only the ones matching filter.
+func DumpReplication(ctx context.Context, s Host, db *uint8, filter string, ttlMode TTLMode, batchSize int, maxErrors int, follow bool, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	dialOpts, err := redisDialOpts(s.Username, s.Password, s.TlsHandler, nil)
+	if err != nil {
+		return err
+	}
+	conn, err := radix.Dial("tcp", fmt.Sprintf("%s:%d", s.Host, s.Port), dialOpts...)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.Do(radix.Cmd(nil, "REPLCONF", "capa", "eof", "capa", "psync2")); err != nil {
+		return fmt.Errorf("failed starting replication: %w", err)
+	}
+	if !follow {
+		// Since Redis 7.0, the server does not keep the commands to
+		// replicate for replicas only reading the snapshot - older
+		// versions reject the option
+		conn.Do(radix.Cmd(nil, "REPLCONF", "rdb-only", "1"))
+	}
+
+	nc := conn.NetConn()
+	if _, err := nc.Write([]byte(RESPSerializer([]string{"PSYNC", "?", "-1"}))); err != nil {
+		return fmt.Errorf("failed starting replication: %w", err)
+	}
+
+	// Closing the connection interrupts reads once ctx is done
+	stop := make(chan bool)
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			nc.Close()
+		case <-stop:
+		}
+	}()
+
+	br := bufio.NewReaderSize(nc, 64*1024)
+	snapshot, initialOffset, err := readSnapshotHeader(br)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	err = ConvertRDB(ctx, snapshot, db, filter, ttlMode, batchSize, maxErrors, logger, serializer, progress)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if !follow || !DumpCompleted(err) {
+		return err
+	}
+	if _, copyErr := io.Copy(io.Discard, snapshot); copyErr != nil {
+		return copyErr
+	}
+
+	var offset atomic.Int64
+	offset.Store(initialOffset)
+	go func() {
+		ticker := time.NewTicker(replicationAckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				nc.Write([]byte(RESPSerializer([]string{"REPLCONF", "ACK", fmt.Sprint(offset.Load())})))
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	if followErr := followReplication(br, db, &offset, logger, serializer); ctx.Err() == nil {
+		return fmt.Errorf("replication interrupted: %w", followErr)
+	}
+	// The dump was followed until interrupted
+	return err
+}
@@ -0,0 +1,230 @@
+package redisdump
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+const testEOFMark = "0123456789abcdef0123456789abcdef01234567"
+
+func TestEOFMarkReader(t *testing.T) {
+	for i, testCase := range []struct {
+		r io.Reader
+	}{
+		{strings.NewReader("snapshot" + testEOFMark + "trailer")},
+		{iotest.OneByteReader(strings.NewReader("snapshot" + testEOFMark + "trailer"))},
+		{iotest.HalfReader(strings.NewReader(strings.Repeat("snapshot", 100) + testEOFMark + "trailer"))},
+	} {
+		br := bufio.NewReaderSize(testCase.r, 16)
+		snapshot, err := io.ReadAll(&eofMarkReader{r: br, mark: []byte(testEOFMark)})
+		if err != nil {
+			t.Errorf("test %d: received error %+v", i, err)
+			continue
+		}
+		if !strings.HasSuffix(string(snapshot), "snapshot") || strings.Contains(string(snapshot), testEOFMark) {
+			t.Errorf("test %d: unexpected snapshot %q", i, snapshot)
+		}
+		if rest, _ := io.ReadAll(br); string(rest) != "trailer" {
+			t.Errorf("test %d: expected the data after the mark to be left, got %q", i, rest)
+		}
+	}
+
+	br := bufio.NewReader(strings.NewReader("snapshot"))
+	if _, err := io.ReadAll(&eofMarkReader{r: br, mark: []byte(testEOFMark)}); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected an error reading a snapshot without mark, got %v", err)
+	}
+}
+
+func TestReadSnapshotHeader(t *testing.T) {
+	for i, testCase := range []struct {
+		reply    string
+		offset   int64
+		snapshot string
+		err      bool
+	}{
+		{"+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 42\r\n$8\r\nsnapshotSET", 42, "snapshot", false},
+		{"\n\n+FULLRESYNC id 0\r\n\n$EOF:" + testEOFMark + "\r\nsnapshot" + testEOFMark, 0, "snapshot", false},
+		{"-NOMASTERLINK Can't SYNC while not connected with my master\r\n", 0, "", true},
+		{"+CONTINUE\r\n", 0, "", true},
+		{"+FULLRESYNC id 0\r\n$EOF:short\r\n", 0, "", true},
+		{"+FULLRESYNC id 0\r\n", 0, "", true},
+	} {
+		snapshot, offset, err := readSnapshotHeader(bufio.NewReader(strings.NewReader(testCase.reply)))
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if b, _ := io.ReadAll(snapshot); string(b) != testCase.snapshot || offset != testCase.offset {
+			t.Errorf("test %d: expected snapshot %q at offset %d, got %q at %d", i, testCase.snapshot, testCase.offset, b, offset)
+		}
+	}
+}
+
+// fakeMaster accepts a single replica on l, and replies to PSYNC with
+// reply. It returns the commands the replica sent, and acknowledged
+// receives the offsets the replica acknowledges.
+func fakeMaster(t *testing.T, l net.Listener, reply string, acknowledged chan<- string) <-chan []string {
+	received := make(chan []string, 1)
+	go func() {
+		var cmds []string
+		defer func() { received <- cmds }()
+
+		conn, err := l.Accept()
+		if err != nil {
+			t.Errorf("failed accepting connection: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		cr := &cmdReader{r: bufio.NewReader(conn)}
+		for {
+			cmd, err := cr.Next()
+			if err != nil {
+				return
+			}
+			switch cmd[0] {
+			case "PSYNC":
+				cmds = append(cmds, strings.Join(cmd, " "))
+				conn.Write([]byte(reply))
+			case "REPLCONF":
+				if cmd[1] == "ACK" {
+					acknowledged <- cmd[2]
+					continue
+				}
+				cmds = append(cmds, strings.Join(cmd, " "))
+				conn.Write([]byte("+OK\r\n"))
+			default:
+				conn.Write([]byte("-ERR unknown command\r\n"))
+			}
+		}
+	}()
+	return received
+}
+
+func testHost(l net.Listener) Host {
+	addr := l.Addr().(*net.TCPAddr)
+	return Host{Host: addr.IP.String(), Port: addr.Port}
+}
+
+func TestDumpReplication(t *testing.T) {
+	file := testRDBFile(0)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	defer l.Close()
+
+	received := fakeMaster(t, l, fmt.Sprintf("+FULLRESYNC id 42\r\n\n$%d\r\n%s", len(file), file), nil)
+	var b bytes.Buffer
+	db := uint8(0)
+	if err := DumpReplication(context.Background(), testHost(l), &db, "user:*", NoTTL, 10, -1, false, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil); err != nil {
+		t.Fatalf("received error %+v", err)
+	}
+
+	if expected := "SELECT 0\nSET user:1 alice\nSET user:2 bob\n"; b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+	expectedCmds := []string{"REPLCONF capa eof capa psync2", "REPLCONF rdb-only 1", "PSYNC ? -1"}
+	if cmds := <-received; !reflect.DeepEqual(cmds, expectedCmds) {
+		t.Errorf("expected the replica to send %q, got %q", expectedCmds, cmds)
+	}
+}
+
+func TestDumpReplicationFollow(t *testing.T) {
+	defer func(interval time.Duration) { replicationAckInterval = interval }(replicationAckInterval)
+	replicationAckInterval = 10 * time.Millisecond
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	defer l.Close()
+
+	replicated := [][]string{
+		{"SELECT", "2"},
+		{"SET", "a", "b"},
+		{"PING"},
+		{"SELECT", "0"},
+		{"SET", "c", "d"},
+		{"REPLCONF", "GETACK", "*"},
+	}
+	reply := "+FULLRESYNC id 100\r\n\n$EOF:" + testEOFMark + "\r\n" + string(testRDBFile(0)) + testEOFMark
+	offset := int64(100)
+	for _, cmd := range replicated {
+		reply += RESPSerializer(cmd)
+		offset += respLen(cmd)
+	}
+
+	acknowledged := make(chan string, 100)
+	received := fakeMaster(t, l, reply, acknowledged)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var b bytes.Buffer
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := DumpReplication(ctx, testHost(l), AllDBs, "*", NoTTL, 10, -1, true, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil); err != nil {
+			t.Errorf("received error %+v", err)
+		}
+	}()
+
+	timeout := time.After(5 * time.Second)
+	for ack := ""; ack != fmt.Sprint(offset); {
+		select {
+		case ack = <-acknowledged:
+		case <-timeout:
+			t.Fatalf("expected offset %d to be acknowledged, last acknowledged %s", offset, ack)
+		}
+	}
+	cancel()
+	wg.Wait()
+	<-received
+
+	out := b.String()
+	if expected := "SELECT 2\nSET a b\nSELECT 0\nSET c d\n"; !strings.HasSuffix(out, "XADD stream 1-1 f v\n"+expected) {
+		t.Errorf("expected the snapshot followed by %q, got %q", expected, out)
+	}
+}
+
+func TestDumpReplicationErrors(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed listening: %v", err)
+	}
+	defer l.Close()
+
+	received := fakeMaster(t, l, "-NOMASTERLINK Can't SYNC while not connected with my master\r\n", nil)
+	var b bytes.Buffer
+	if err := DumpReplication(context.Background(), testHost(l), AllDBs, "*", NoTTL, 10, -1, false, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil); err == nil || !strings.Contains(err.Error(), "NOMASTERLINK") {
+		t.Errorf("expected the PSYNC error, got %v", err)
+	}
+	<-received
+
+	// The server stops sending the snapshot half way through
+	file := testRDBFile(0)
+	received = fakeMaster(t, l, fmt.Sprintf("+FULLRESYNC id 0\r\n$%d\r\n%s", len(file), file[:len(file)-40]), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	b.Reset()
+	err = DumpReplication(ctx, testHost(l), AllDBs, "*", NoTTL, 10, -1, false, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer), nil)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.HasSuffix(b.String(), RedisCmdSerializer(IncompleteDumpMarker)+"\n") {
+		t.Errorf("expected the dump to be interrupted, got %v: %q", err, b.String())
+	}
+	<-received
+}
@@ -0,0 +1,627 @@
+package redisdump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"strconv"
+	"strings"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// errJSONDump is returned when reading back a dump written as JSON
+var errJSONDump = errors.New("dumps written as JSON can not be restored")
+
+// cmdReader reads back the commands of a dump, written either as RESP
+// or as Redis commands. The format is detected on the first command.
+type cmdReader struct {
+	r    *bufio.Reader
+	resp *bool
+}
+
+func newCmdReader(r io.Reader) *cmdReader {
+	return &cmdReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// skipBlanks discards the new lines separating commands
+func (cr *cmdReader) skipBlanks() error {
+	for {
+		b, err := cr.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if b != '\n' && b != '\r' {
+			return cr.r.UnreadByte()
+		}
+	}
+}
+
+// Next returns the next command of the dump, or io.EOF if there is none left
+func (cr *cmdReader) Next() ([]string, error) {
+	if err := cr.skipBlanks(); err != nil {
+		return nil, err
+	}
+
+	if cr.resp == nil {
+		b, err := cr.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if b[0] == '{' {
+			return nil, errJSONDump
+		}
+		isResp := b[0] == '*'
+		cr.resp = &isResp
+	}
+
+	if *cr.resp {
+		return cr.nextRESP()
+	}
+	return cr.nextCommand()
+}
+
+func (cr *cmdReader) readLine() (string, error) {
+	line, err := cr.r.ReadString('\n')
+	if err == io.EOF && len(line) > 0 {
+		// The last line of the dump has no line break
+		err = io.ErrUnexpectedEOF
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), err
+}
+
+func (cr *cmdReader) nextRESP() ([]string, error) {
+	header, err := cr.readLine()
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if !strings.HasPrefix(header, "*") {
+		return nil, fmt.Errorf("invalid RESP array header %q", header)
+	}
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid RESP array header %q", header)
+	}
+
+	cmd := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := cr.readLine()
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("invalid RESP bulk string header %q", header)
+		}
+		l, err := strconv.Atoi(header[1:])
+		if err != nil || l < 0 {
+			return nil, fmt.Errorf("invalid RESP bulk string header %q", header)
+		}
+
+		arg := make([]byte, l+2)
+		if _, err := io.ReadFull(cr.r, arg); err != nil {
+			return nil, noEOF(err)
+		}
+		if arg[l] != '\r' || arg[l+1] != '\n' {
+			return nil, fmt.Errorf("invalid RESP bulk string terminator")
+		}
+		cmd = append(cmd, string(arg[:l]))
+	}
+
+	return cmd, nil
+}
+
+// nextCommand parses a line as written by RedisCmdSerializer: arguments
+// are separated by spaces, and quoted when they contain spaces or are empty
+func (cr *cmdReader) nextCommand() ([]string, error) {
+	line, err := cr.readLine()
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := []string{}
+	for len(line) > 0 {
+		if line[0] == ' ' {
+			line = line[1:]
+			continue
+		}
+
+		end := strings.IndexByte(line, ' ')
+		if line[0] == '"' {
+			if end = strings.Index(line, "\" "); end == -1 {
+				if !strings.HasSuffix(line[1:], "\"") {
+					return nil, fmt.Errorf("unterminated quoted argument in %q", line)
+				}
+				end = len(line) - 1
+			}
+			cmd = append(cmd, line[1:end])
+			line = line[end+1:]
+			continue
+		}
+
+		if end == -1 {
+			end = len(line)
+		}
+		cmd = append(cmd, line[:end])
+		line = line[end:]
+	}
+
+	return cmd, nil
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// RestoreError is a command of the dump that the server failed to apply
+type RestoreError struct {
+	Db  uint8
+	Cmd []string
+	Err error
+}
+
+func (e RestoreError) Error() string {
+	name := ""
+	if len(e.Cmd) > 0 {
+		name = e.Cmd[0]
+	}
+	if len(e.Cmd) > 1 {
+		name += " " + e.Cmd[1]
+	}
+
+	return fmt.Sprintf("db %d: %s: %s", e.Db, name, e.Err)
+}
+
+// ErrIncompleteDump is returned when restoring a dump that ends with
+// IncompleteDumpMarker
+var ErrIncompleteDump = errors.New("the dump is incomplete, it was interrupted before all keys were dumped")
+
+func isIncompleteDumpMarker(cmd []string) bool {
+	return len(cmd) == len(IncompleteDumpMarker) && strings.ToUpper(cmd[0]) == IncompleteDumpMarker[0] && cmd[1] == IncompleteDumpMarker[1]
+}
+
+// RestoreStats counts the commands sent to the server during a restore
+type RestoreStats struct {
+	Restored int
+	Failed   int
+}
+
+type restoreCmd struct {
+	db  uint8
+	cmd []string
+	// rcv receives the reply to commands sent along with the commands of
+	// the dump, which are not counted as restored
+	rcv interface{}
+}
+
+// cmdReply receives the reply to a command of a pipeline into rcv, if set.
+// Errors returned by Redis are kept, rather than interrupting the pipeline.
+type cmdReply struct {
+	rcv interface{}
+	err error
+}
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (r *cmdReply) UnmarshalRESP(br *bufio.Reader) error {
+	r.err = nil
+	err := resp2.Any{I: r.rcv}.UnmarshalRESP(br)
+	var discarded resp.ErrDiscarded
+	if errors.As(err, &discarded) {
+		r.err = discarded.Err
+		return nil
+	}
+	return err
+}
+
+// restorePipeline sends cmds to the server in a single round trip, selecting
+// databases as required. db is the database currently selected on conn.
+func restorePipeline(conn radix.Client, db *uint8, cmds []restoreCmd, failures chan<- RestoreError) (RestoreStats, error) {
+	var stats RestoreStats
+	var actions []radix.CmdAction
+	var replies []*cmdReply
+	var sent []restoreCmd
+
+	for _, c := range cmds {
+		if db == nil || *db != c.db {
+			selectDb := c.db
+			db = &selectDb
+			reply := &cmdReply{}
+			actions = append(actions, radix.Cmd(reply, "SELECT", fmt.Sprint(c.db)))
+			replies = append(replies, reply)
+			sent = append(sent, restoreCmd{db: c.db})
+		}
+
+		reply := &cmdReply{rcv: c.rcv}
+		actions = append(actions, radix.Cmd(reply, c.cmd[0], c.cmd[1:]...))
+		replies = append(replies, reply)
+		sent = append(sent, c)
+	}
+
+	if err := conn.Do(radix.Pipeline(actions...)); err != nil {
+		return stats, err
+	}
+
+	for i, reply := range replies {
+		if sent[i].cmd == nil {
+			// The following commands would be applied to the wrong database
+			if reply.err != nil {
+				return stats, fmt.Errorf("failed selecting database %d: %w", sent[i].db, reply.err)
+			}
+			continue
+		}
+
+		if reply.err != nil {
+			stats.Failed++
+			failures <- RestoreError{Db: sent[i].db, Cmd: sent[i].cmd, Err: reply.err}
+		} else if sent[i].rcv == nil {
+			stats.Restored++
+		}
+	}
+
+	return stats, nil
+}
+
+type restoreResult struct {
+	stats RestoreStats
+	err   error
+}
+
+// keyBody is the commands of a dump recreating a key, to which the conflict
+// policy applies once
+type keyBody struct {
+	key conflictKey
+	// skipped is set for existing keys with SkipExistingKeys, and deleted
+	// once the key was deleted with ReplaceKeys
+	skipped bool
+	deleted bool
+}
+
+// restoreConflicts applies a ConflictPolicy to the commands a worker sends,
+// as all commands of a key are sent by the same worker. The commands of a
+// key read at once follow each other in a dump, so only the key of the
+// last command is remembered, along with the keys whose chunks are being
+// restored until their end marker.
+type restoreConflicts struct {
+	policy  ConflictPolicy
+	last    *keyBody
+	chunked map[conflictKey]*keyBody
+}
+
+func newRestoreConflicts(policy ConflictPolicy) *restoreConflicts {
+	return &restoreConflicts{
+		policy:  policy,
+		chunked: map[conflictKey]*keyBody{},
+	}
+}
+
+// body returns the body a command of key belongs to, and whether it starts
+// with this command
+func (r *restoreConflicts) body(key conflictKey) (*keyBody, bool) {
+	if r.last != nil && r.last.key == key {
+		return r.last, false
+	}
+	b, ok := r.chunked[key]
+	if !ok {
+		b = &keyBody{key: key}
+	}
+	r.last = b
+	return b, !ok
+}
+
+// resolve returns the commands of batch to send, leaving out chunk
+// markers. With SkipExistingKeys, the keys of the bodies starting in the
+// batch are checked with a pipeline sent with send, and the commands of
+// existing keys are left out.
+func (r *restoreConflicts) resolve(batch []restoreCmd, send func([]restoreCmd) error) ([]restoreCmd, error) {
+	bodies := make([]*keyBody, len(batch))
+	var exists []restoreCmd
+	var checked []*keyBody
+	for i, c := range batch {
+		name, end, marker := parseChunksMarker(c.cmd)
+		if !marker {
+			name = cmdKey(c.cmd)
+		}
+		if r.policy == MergeKeys || !marker && name == "" {
+			continue
+		}
+
+		key := conflictKey{db: c.db, name: name}
+		b, fresh := r.body(key)
+		bodies[i] = b
+		switch {
+		case marker && end:
+			delete(r.chunked, key)
+		case marker:
+			r.chunked[key] = b
+		}
+		if fresh && r.policy == SkipExistingKeys {
+			// Keys that could not be checked are skipped
+			n := 1
+			exists = append(exists, restoreCmd{db: c.db, cmd: []string{"EXISTS", key.name}, rcv: &n})
+			checked = append(checked, b)
+		}
+	}
+	if len(exists) > 0 {
+		if err := send(exists); err != nil {
+			return nil, err
+		}
+		for i, c := range exists {
+			checked[i].skipped = *c.rcv.(*int) > 0
+		}
+	}
+
+	resolved := make([]restoreCmd, 0, len(batch))
+	for i, c := range batch {
+		if _, _, marker := parseChunksMarker(c.cmd); marker {
+			continue
+		}
+		b := bodies[i]
+		switch {
+		case b == nil:
+		case b.skipped:
+			continue
+		case r.policy == ReplaceKeys && !b.deleted:
+			resolved = append(resolved, restoreCmd{db: c.db, cmd: []string{"DEL", b.key.name}, rcv: new(int)})
+			b.deleted = true
+		}
+		resolved = append(resolved, c)
+	}
+	return resolved, nil
+}
+
+// restoreWorker sends the commands it receives, pipelineSize at a time or
+// as soon as no command is waiting, applying the conflict policy. Once a
+// pipeline failed, abort is called if set, and the remaining commands are
+// only drained.
+func restoreWorker(getConn func() (radix.Conn, error), cmds <-chan restoreCmd, pipelineSize int, conflict ConflictPolicy, failures chan<- RestoreError, abort func(error), result chan<- restoreResult) {
+	var res restoreResult
+	var conn radix.Conn
+	var db *uint8
+	conflicts := newRestoreConflicts(conflict)
+
+	flush := func(batch []restoreCmd) {
+		if res.err != nil || len(batch) == 0 {
+			return
+		}
+		if conn == nil {
+			if conn, res.err = getConn(); res.err != nil {
+				if abort != nil {
+					abort(res.err)
+				}
+				return
+			}
+		}
+
+		send := func(cmds []restoreCmd) error {
+			if len(cmds) == 0 {
+				return nil
+			}
+			stats, err := restorePipeline(conn, db, cmds, failures)
+			if err != nil {
+				return err
+			}
+			res.stats.Restored += stats.Restored
+			res.stats.Failed += stats.Failed
+			lastDb := cmds[len(cmds)-1].db
+			db = &lastDb
+			return nil
+		}
+
+		var err error
+		if batch, err = conflicts.resolve(batch, send); err == nil {
+			err = send(batch)
+		}
+		if err != nil {
+			res.err = err
+			if abort != nil {
+				abort(err)
+			}
+		}
+	}
+
+	// After an error, the remaining commands are only drained
+	batch := make([]restoreCmd, 0, pipelineSize)
+	for c := range cmds {
+		batch = append(batch, c)
+		if len(batch) >= pipelineSize || len(cmds) == 0 {
+			flush(batch)
+			batch = batch[:0]
+		}
+	}
+	flush(batch)
+
+	if conn != nil {
+		conn.Close()
+	}
+	result <- res
+}
+
+// restoreWorkerIndex returns the worker in charge of a command. All commands
+// for a given key are sent by the same worker, so they are applied in order.
+// Chunk markers are sent to the worker of their key.
+func restoreWorkerIndex(c restoreCmd, nWorkers int) int {
+	key, _, marker := parseChunksMarker(c.cmd)
+	if !marker {
+		key = cmdKey(c.cmd)
+	}
+	if key == "" {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte{c.db})
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(nWorkers))
+}
+
+// restoreRouter dispatches the commands of a dump to the workers sending
+// them. The commands of a MULTI/EXEC transaction are all sent by the worker
+// of its first key, so the transaction is applied as a whole; its keys may
+// then be applied out of order with commands other workers send.
+type restoreRouter struct {
+	workers []chan restoreCmd
+	// tx are the commands of the transaction being read, from its MULTI
+	tx []restoreCmd
+}
+
+// send sends c to the worker in charge of it. Scripts are loaded by all
+// workers, as each connection loads them before running them.
+func (r *restoreRouter) send(c restoreCmd) {
+	if isScriptLoad(c.cmd) {
+		for _, w := range r.workers {
+			w <- c
+		}
+		return
+	}
+	r.workers[restoreWorkerIndex(c, len(r.workers))] <- c
+}
+
+// route sends c as send does, unless it belongs to a transaction: the
+// commands of a transaction are sent once its EXEC or DISCARD is read.
+func (r *restoreRouter) route(c restoreCmd) {
+	name := strings.ToUpper(c.cmd[0])
+	switch {
+	case name == "MULTI":
+		r.flush()
+		r.tx = []restoreCmd{c}
+	case r.tx != nil:
+		r.tx = append(r.tx, c)
+		if name == "EXEC" || name == "DISCARD" {
+			r.flush()
+		}
+	default:
+		r.send(c)
+	}
+}
+
+// flush sends the commands of the transaction being read to the worker of
+// its first key, or the first worker if it has none
+func (r *restoreRouter) flush() {
+	if r.tx == nil {
+		return
+	}
+	w := 0
+	for _, c := range r.tx {
+		if cmdKey(c.cmd) != "" {
+			w = restoreWorkerIndex(c, len(r.workers))
+			break
+		}
+	}
+	for _, c := range r.tx {
+		r.workers[w] <- c
+	}
+	r.tx = nil
+}
+
+// close sends the commands of a transaction left open at the end of the
+// dump, and closes the workers
+func (r *restoreRouter) close() {
+	r.flush()
+	for _, w := range r.workers {
+		close(w)
+	}
+}
+
+// RestoreServer reads the commands of a dump from r, written as RESP or as
+// Redis commands, and sends them to the server s using nWorkers
+// connections, pipelining up to pipelineSize commands at a time. SELECT
+// commands switch the database the following commands are applied to.
+// Commands the server fails to apply are sent to the channel failures,
+// and counted in the returned RestoreStats. Dumps that were interrupted
+// are restored, but ErrIncompleteDump is returned if the dump ends with
+// IncompleteDumpMarker. Keys already on the server are handled as
+// conflict sets. MULTI/EXEC transactions are sent by a single connection.
+func RestoreServer(s Host, r io.Reader, nWorkers int, pipelineSize int, conflict ConflictPolicy, progress chan<- ProgressNotification, failures chan<- RestoreError) (RestoreStats, error) {
+	redisURL := RedisURL(s.Host, fmt.Sprint(s.Port))
+	getConn := func() (radix.Conn, error) {
+		return getConnFunc(s, nil)("tcp", redisURL)
+	}
+
+	results := make(chan restoreResult)
+	workers := make([]chan restoreCmd, nWorkers)
+	for i := range workers {
+		workers[i] = make(chan restoreCmd, pipelineSize)
+		go restoreWorker(getConn, workers[i], pipelineSize, conflict, failures, nil, results)
+	}
+
+	router := &restoreRouter{workers: workers}
+	var readErr error
+	incomplete := false
+	var db uint8
+	nRead := 0
+	cr := newCmdReader(r)
+	for {
+		cmd, err := cr.Next()
+		if err != nil {
+			if err != io.EOF {
+				readErr = fmt.Errorf("failed reading dump: %w", err)
+			}
+			break
+		}
+		if len(cmd) == 0 {
+			continue
+		}
+		// A dump resumed from a checkpoint follows the marker of the
+		// interrupted dump, only the marker ending the dump matters
+		incomplete = isIncompleteDumpMarker(cmd)
+		if incomplete {
+			continue
+		}
+
+		if strings.ToUpper(cmd[0]) == "SELECT" && len(cmd) == 2 {
+			newDb, err := strconv.ParseUint(cmd[1], 10, 8)
+			if err != nil {
+				readErr = fmt.Errorf("failed reading dump: invalid database %s", cmd[1])
+				break
+			}
+			if progress != nil && nRead > 0 {
+				progress <- ProgressNotification{Db: db, Done: nRead}
+			}
+			db = uint8(newDb)
+			nRead = 0
+			continue
+		}
+
+		router.route(restoreCmd{db: db, cmd: cmd})
+		if _, _, marker := parseChunksMarker(cmd); marker {
+			continue
+		}
+		nRead++
+		if progress != nil && nRead%pipelineSize == 0 {
+			progress <- ProgressNotification{Db: db, Done: nRead}
+		}
+	}
+	if progress != nil && nRead > 0 {
+		progress <- ProgressNotification{Db: db, Done: nRead}
+	}
+
+	router.close()
+
+	var stats RestoreStats
+	var err error
+	for range workers {
+		res := <-results
+		stats.Restored += res.stats.Restored
+		stats.Failed += res.stats.Failed
+		if res.err != nil && err == nil {
+			err = res.err
+		}
+	}
+
+	if readErr != nil {
+		return stats, readErr
+	}
+	if err == nil && incomplete {
+		err = ErrIncompleteDump
+	}
+	return stats, err
+}
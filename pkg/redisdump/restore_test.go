@@ -0,0 +1,205 @@
+package redisdump
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCmdReader(t *testing.T) {
+	for i, testCase := range []struct {
+		dump     string
+		expected [][]string
+		err      bool
+	}{
+		{
+			"*2\r\n$6\r\nSELECT\r\n$1\r\n0\r\n\n*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$12\r\nhello\r\nworld\r\n\n",
+			[][]string{{"SELECT", "0"}, {"SET", "key", "hello\r\nworld"}},
+			false,
+		},
+		{
+			RESPSerializer([]string{"SET", "key1", "😈"}) + "\n" + RESPSerializer([]string{"SET", "", ""}),
+			[][]string{{"SET", "key1", "😈"}, {"SET", "", ""}},
+			false,
+		},
+		{
+			"SELECT 0\nSET \"key name 1\" \"key value 1\"\nSET key \"\"\nHSET key1 \"key value 1\" f v\n",
+			[][]string{{"SELECT", "0"}, {"SET", "key name 1", "key value 1"}, {"SET", "key", ""}, {"HSET", "key1", "key value 1", "f", "v"}},
+			false,
+		},
+		{
+			"SELECT 0\nSET key value",
+			[][]string{{"SELECT", "0"}, {"SET", "key", "value"}},
+			false,
+		},
+		{
+			"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nval",
+			nil,
+			true,
+		},
+		{
+			"{\"db\":0,\"key\":\"key\",\"type\":\"string\",\"value\":\"value\"}\n",
+			nil,
+			true,
+		},
+	} {
+		cr := newCmdReader(strings.NewReader(testCase.dump))
+		var cmds [][]string
+		var err error
+		for {
+			var cmd []string
+			if cmd, err = cr.Next(); err != nil {
+				break
+			}
+			cmds = append(cmds, cmd)
+		}
+
+		if (err != io.EOF) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+		if testCase.err {
+			continue
+		}
+		if len(cmds) != len(testCase.expected) {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, cmds)
+			continue
+		}
+		for j := range cmds {
+			if !testEqString(cmds[j], testCase.expected[j]) {
+				t.Errorf("test %d: expected %q, got %q", i, testCase.expected[j], cmds[j])
+			}
+		}
+	}
+}
+
+func TestIsIncompleteDumpMarker(t *testing.T) {
+	for _, serializer := range []CmdSerializer{RESPSerializer, RedisCmdSerializer} {
+		cr := newCmdReader(strings.NewReader(serializer([]string{"SET", "a", "b"}) + "\n" + serializer(IncompleteDumpMarker) + "\n"))
+		cmd, err := cr.Next()
+		if err != nil || isIncompleteDumpMarker(cmd) {
+			t.Errorf("unexpected incomplete dump marker %q, %v", cmd, err)
+		}
+		cmd, err = cr.Next()
+		if err != nil || !isIncompleteDumpMarker(cmd) {
+			t.Errorf("expected incomplete dump marker, got %q, %v", cmd, err)
+		}
+	}
+}
+
+func TestParseChunksMarker(t *testing.T) {
+	for i, testCase := range []struct {
+		cmd    []string
+		key    string
+		end    bool
+		marker bool
+	}{
+		{chunksMarker("list", false), "list", false, true},
+		{chunksMarker("my list", true), "my list", true, true},
+		{[]string{"echo", "redis-dump-go: chunk of list"}, "list", false, true},
+		{IncompleteDumpMarker, "", false, false},
+		{[]string{"ECHO", "chunk of list"}, "", false, false},
+		{[]string{"RPUSH", "list", "redis-dump-go: chunk of list"}, "", false, false},
+	} {
+		// Markers are read back from dumps in both formats
+		for _, serializer := range []CmdSerializer{RESPSerializer, RedisCmdSerializer} {
+			cmd, err := newCmdReader(strings.NewReader(serializer(testCase.cmd) + "\n")).Next()
+			if err != nil {
+				t.Fatalf("test %d: received error %+v", i, err)
+			}
+			key, end, marker := parseChunksMarker(cmd)
+			if key != testCase.key || end != testCase.end || marker != testCase.marker {
+				t.Errorf("test %d: expected %q %t %t, got %q %t %t", i, testCase.key, testCase.end, testCase.marker, key, end, marker)
+			}
+		}
+	}
+}
+
+func TestRestoreWorkerIndex(t *testing.T) {
+	// All commands for a key must be sent by the same worker
+	a := restoreWorkerIndex(restoreCmd{db: 0, cmd: []string{"RPUSH", "list", "1"}}, 10)
+	b := restoreWorkerIndex(restoreCmd{db: 0, cmd: []string{"EXPIREAT", "list", "1700000000"}}, 10)
+	if a != b {
+		t.Errorf("commands for the same key were sent to different workers: %d, %d", a, b)
+	}
+
+	// Chunk markers are sent to the worker of their key
+	if m := restoreWorkerIndex(restoreCmd{db: 0, cmd: chunksMarker("list", true)}, 10); m != a {
+		t.Errorf("the chunk marker of a key was sent to another worker: %d, %d", a, m)
+	}
+
+	for _, c := range []restoreCmd{{db: 2, cmd: []string{"PING"}}, {db: 1, cmd: []string{"SET", "a", "b"}}} {
+		if i := restoreWorkerIndex(c, 3); i < 0 || i >= 3 {
+			t.Errorf("invalid worker index %d for %q", i, c.cmd)
+		}
+	}
+}
+
+func TestRestoreRouter(t *testing.T) {
+	// Keys sent to different workers, outside of a transaction
+	other := "b"
+	for restoreWorkerIndex(restoreCmd{cmd: []string{"SET", other, "1"}}, 4) == restoreWorkerIndex(restoreCmd{cmd: []string{"SET", "a", "1"}}, 4) {
+		other += "b"
+	}
+	cmds := [][]string{
+		{"MULTI"},
+		{"SET", "a", "1"},
+		{"SET", other, "1"},
+		{"EXEC"},
+		{"SET", other, "2"},
+		// Transactions left open are sent at the end of the dump
+		{"MULTI"},
+		{"INCR", other},
+	}
+
+	router := &restoreRouter{workers: make([]chan restoreCmd, 4)}
+	for i := range router.workers {
+		router.workers[i] = make(chan restoreCmd, len(cmds))
+	}
+	for _, cmd := range cmds {
+		router.route(restoreCmd{cmd: cmd})
+	}
+	router.close()
+
+	sent := make([][][]string, len(router.workers))
+	for i, w := range router.workers {
+		for c := range w {
+			sent[i] = append(sent[i], c.cmd)
+		}
+	}
+	a := restoreWorkerIndex(restoreCmd{cmd: []string{"SET", "a", "1"}}, 4)
+	if expected := cmds[:4]; !reflect.DeepEqual(sent[a], expected) {
+		t.Errorf("expected the transaction %q to be sent by worker %d, got %q", expected, a, sent[a])
+	}
+	b := restoreWorkerIndex(restoreCmd{cmd: []string{"SET", other, "1"}}, 4)
+	if expected := cmds[4:]; !reflect.DeepEqual(sent[b], expected) {
+		t.Errorf("expected %q to be sent by worker %d, got %q", expected, b, sent[b])
+	}
+}
+
+func TestCmdReply(t *testing.T) {
+	for i, testCase := range []struct {
+		reply    string
+		redisErr bool
+	}{
+		{"+OK\r\n", false},
+		{":1\r\n", false},
+		{"*2\r\n$1\r\na\r\n:1\r\n", false},
+		{"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", true},
+	} {
+		var r cmdReply
+		br := bufio.NewReader(strings.NewReader(testCase.reply + "+NEXT\r\n"))
+		if err := r.UnmarshalRESP(br); err != nil {
+			t.Errorf("test %d: unexpected error %s", i, err)
+		}
+		if (r.err != nil) != testCase.redisErr {
+			t.Errorf("test %d: unexpected reply error %v", i, r.err)
+		}
+
+		// The reply must have been fully consumed
+		if next, _ := br.ReadString('\n'); next != "+NEXT\r\n" {
+			t.Errorf("test %d: reply not fully read, next line is %q", i, next)
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package redisdump
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// s3PartSize is the size of the first parts objects are uploaded in
+var s3PartSize = 8 << 20
+
+// s3MaxParts is the number of parts S3 accepts in an upload
+const s3MaxParts = 10000
+
+// s3PartsPerSize is the number of parts uploaded before the size of the
+// parts doubles, so objects of any size fit in s3MaxParts parts
+const s3PartsPerSize = 1000
+
+// s3MaxPartSize is the largest part S3 accepts
+const s3MaxPartSize = 5 << 30
+
+// s3PartSizeOf returns the size of the part n, counted from 1: parts are
+// 8MiB up to the part 1000, 16MiB up to the part 2000, and so on up to
+// 4GiB, so objects can reach the 5TB S3 accepts
+func s3PartSizeOf(n int) (int, error) {
+	if n > s3MaxParts {
+		return 0, fmt.Errorf("objects can not have more than %d parts", s3MaxParts)
+	}
+	size := s3PartSize << ((n - 1) / s3PartsPerSize)
+	if size > s3MaxPartSize {
+		size = s3MaxPartSize
+	}
+	return size, nil
+}
+
+// s3ConcurrentParts is the number of parts of s3PartSize bytes uploaded at
+// the same time: as parts grow, fewer are, so the parts being uploaded
+// never hold more than s3ConcurrentParts*s3PartSize bytes, or a single part
+const s3ConcurrentParts = 4
+
+// s3Attempts is the number of times a request is sent before it fails
+const s3Attempts = 3
+
+// emptySHA256 is the hash of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// IsS3URL returns whether name is the URL of an object, s3://bucket/key
+func IsS3URL(name string) bool {
+	return strings.HasPrefix(name, "s3://")
+}
+
+func parseS3URL(name string) (string, string, error) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(name, "s3://"), "/")
+	if !IsS3URL(name) || bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid S3 URL %q, expected s3://bucket/key", name)
+	}
+	return bucket, key, nil
+}
+
+// S3Client reads and writes objects on S3, or on a service compatible
+// with its API, signing requests with AWS Signature Version 4
+type S3Client struct {
+	endpoint *url.URL
+	// pathStyle addresses buckets in the path of the URL, rather than in
+	// its host
+	pathStyle    bool
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+	now          func() time.Time
+}
+
+// NewS3Client returns a client for the S3 service at endpoint, such as
+// https://s3.eu-west-1.amazonaws.com. Buckets are addressed in the host of
+// the URL on amazonaws.com, and in its path on other services.
+func NewS3Client(endpoint string, region string, accessKey string, secretKey string, sessionToken string) (*S3Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	return &S3Client{
+		endpoint:     u,
+		pathStyle:    !strings.HasSuffix(u.Hostname(), ".amazonaws.com"),
+		region:       region,
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		sessionToken: sessionToken,
+		client:       &http.Client{},
+		now:          time.Now,
+	}, nil
+}
+
+// S3ClientFromEnv returns a client configured with the variables the AWS
+// tools read: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN,
+// AWS_REGION or AWS_DEFAULT_REGION, and AWS_ENDPOINT_URL_S3 or
+// AWS_ENDPOINT_URL for services other than AWS
+func S3ClientFromEnv() (*S3Client, error) {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	if os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required to access S3")
+	}
+	return NewS3Client(endpoint, region, os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"))
+}
+
+// s3Escape encodes s as Signature Version 4 requires: all characters but
+// letters, digits and -._~ are escaped, and / if escapeSlash is set
+func s3Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3Query(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var params []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			params = append(params, s3Escape(k, true)+"="+s3Escape(v, true))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds the Signature Version 4 authorization to req, whose payload
+// has the SHA-256 hash payloadHash. The headers of req are all signed.
+func (c *S3Client) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, strings.TrimSpace(headers[name]))
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3Query(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, c.region)
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.secretKey), date)
+	key = hmacSHA256(key, c.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", c.accessKey, scope, signedHeaders, signature))
+}
+
+func (c *S3Client) objectURL(bucket string, key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucket + "/" + key
+	} else {
+		u.Host = bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	// The path is sent as it is signed
+	u.RawPath = s3Escape(u.Path, false)
+	u.RawQuery = s3Query(query)
+	return &u
+}
+
+// s3Error is the error S3 returns
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a request for the object key of bucket, with body as payload,
+// and returns the response if it succeeded. Requests that fail, or that
+// the server fails to process, are sent again.
+func (c *S3Client) do(method string, bucket string, key string, query url.Values, body []byte) (*http.Response, error) {
+	payloadHash := emptySHA256
+	if len(body) > 0 {
+		hash := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(hash[:])
+	}
+
+	var err error
+	for attempt := 0; attempt < s3Attempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+
+		var req *http.Request
+		if req, err = http.NewRequest(method, c.objectURL(bucket, key, query).String(), bytes.NewReader(body)); err != nil {
+			return nil, err
+		}
+		c.sign(req, payloadHash)
+
+		var resp *http.Response
+		if resp, err = c.client.Do(req); err != nil {
+			continue
+		}
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return resp, nil
+		}
+
+		var s3Err s3Error
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if xml.Unmarshal(b, &s3Err) == nil && s3Err.Code != "" {
+			err = fmt.Errorf("%s %s: %s: %s", method, c.objectURL(bucket, key, nil), s3Err.Code, s3Err.Message)
+		} else {
+			err = fmt.Errorf("%s %s: %s", method, c.objectURL(bucket, key, nil), resp.Status)
+		}
+		if resp.StatusCode < 500 {
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// Open reads the object name, given as s3://bucket/key
+func (c *S3Client) Open(name string) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URL(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(http.MethodGet, bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create creates the object name, given as s3://bucket/key
+func (c *S3Client) Create(name string) (Output, error) {
+	bucket, key, err := parseS3URL(name)
+	if err != nil {
+		return nil, err
+	}
+	o := &s3Object{
+		c:            c,
+		bucket:       bucket,
+		key:          key,
+		maxUploading: s3ConcurrentParts * s3PartSize,
+	}
+	o.uploaded = sync.NewCond(&o.mu)
+	return o, nil
+}
+
+// s3Object is an object uploaded as it is written: once a part is written,
+// it is uploaded while the next one is written. The object is created by
+// Commit, once the upload is completed; an object of a single part is
+// uploaded by Commit.
+type s3Object struct {
+	c      *S3Client
+	bucket string
+	key    string
+	// buf is the part being written
+	buf      []byte
+	uploadID string
+	// parts is the number of parts uploaded
+	parts int
+	etags []string
+	wg    sync.WaitGroup
+
+	mu sync.Mutex
+	// uploading is the number of bytes of the parts being uploaded, up to
+	// maxUploading, unless a single part is larger. uploaded is signaled
+	// once a part was uploaded.
+	uploading    int
+	maxUploading int
+	uploaded     *sync.Cond
+	// free is the buffer of a part uploaded, reused for the next parts
+	free []byte
+	err  error
+}
+
+type s3CompletedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type s3CompleteMultipartUpload struct {
+	XMLName xml.Name          `xml:"CompleteMultipartUpload"`
+	Parts   []s3CompletedPart `xml:"Part"`
+}
+
+func (o *s3Object) fail(err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err == nil {
+		o.err = err
+	}
+}
+
+func (o *s3Object) failed() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.err
+}
+
+// uploadPart uploads the next part of the object in the background
+func (o *s3Object) uploadPart(part []byte) error {
+	if o.uploadID == "" {
+		resp, err := o.c.do(http.MethodPost, o.bucket, o.key, url.Values{"uploads": {""}}, nil)
+		if err != nil {
+			return err
+		}
+		var upload struct {
+			UploadID string `xml:"UploadId"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&upload)
+		resp.Body.Close()
+		if err != nil || upload.UploadID == "" {
+			return fmt.Errorf("failed starting upload of s3://%s/%s: %v", o.bucket, o.key, err)
+		}
+		o.uploadID = upload.UploadID
+	}
+
+	o.parts++
+	n := o.parts
+	o.mu.Lock()
+	for o.uploading > 0 && o.uploading+len(part) > o.maxUploading {
+		o.uploaded.Wait()
+	}
+	o.uploading += len(part)
+	o.etags = append(o.etags, "")
+	o.mu.Unlock()
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		query := url.Values{"partNumber": {fmt.Sprint(n)}, "uploadId": {o.uploadID}}
+		resp, err := o.c.do(http.MethodPut, o.bucket, o.key, query, part)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		o.uploading -= len(part)
+		o.uploaded.Broadcast()
+		if err != nil {
+			if o.err == nil {
+				o.err = err
+			}
+			return
+		}
+		o.etags[n-1] = resp.Header.Get("ETag")
+		if cap(part) > cap(o.free) {
+			o.free = part[:0]
+		}
+	}()
+	return nil
+}
+
+// partBuffer returns the buffer the next part is written to, reusing the
+// buffer of a part uploaded before if there is one
+func (o *s3Object) partBuffer() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	buf := o.free
+	o.free = nil
+	return buf
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	if err := o.failed(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		size, err := s3PartSizeOf(o.parts + 1)
+		if err != nil {
+			err = fmt.Errorf("failed uploading s3://%s/%s: %w", o.bucket, o.key, err)
+			o.fail(err)
+			return 0, err
+		}
+		if o.buf == nil {
+			o.buf = o.partBuffer()
+		}
+		written := min(len(p), size-len(o.buf))
+		o.buf = append(o.buf, p[:written]...)
+		p = p[written:]
+		if len(o.buf) < size {
+			break
+		}
+
+		part := o.buf
+		o.buf = nil
+		if err := o.uploadPart(part); err != nil {
+			o.fail(err)
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Commit implements the Output interface.
+func (o *s3Object) Commit() error {
+	if err := o.failed(); err != nil {
+		o.Abort()
+		return err
+	}
+	if o.uploadID == "" {
+		resp, err := o.c.do(http.MethodPut, o.bucket, o.key, nil, o.buf)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+
+	if len(o.buf) > 0 {
+		if err := o.uploadPart(o.buf); err != nil {
+			o.fail(err)
+		}
+	}
+	o.wg.Wait()
+	if err := o.failed(); err != nil {
+		o.Abort()
+		return err
+	}
+
+	complete := s3CompleteMultipartUpload{}
+	for i, etag := range o.etags {
+		complete.Parts = append(complete.Parts, s3CompletedPart{PartNumber: i + 1, ETag: etag})
+	}
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return err
+	}
+	resp, err := o.c.do(http.MethodPost, o.bucket, o.key, url.Values{"uploadId": {o.uploadID}}, body)
+	if err != nil {
+		o.Abort()
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Completing the upload can fail after the response started
+	var s3Err s3Error
+	if b, _ := io.ReadAll(resp.Body); xml.Unmarshal(b, &s3Err) == nil && s3Err.Code != "" {
+		o.Abort()
+		return fmt.Errorf("failed completing upload of s3://%s/%s: %s: %s", o.bucket, o.key, s3Err.Code, s3Err.Message)
+	}
+	return nil
+}
+
+// Remove implements the Output interface.
+func (o *s3Object) Remove() error {
+	resp, err := o.c.do(http.MethodDelete, o.bucket, o.key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Abort implements the Output interface. The parts uploaded are deleted.
+func (o *s3Object) Abort() {
+	o.wg.Wait()
+	if o.uploadID != "" {
+		if resp, err := o.c.do(http.MethodDelete, o.bucket, o.key, url.Values{"uploadId": {o.uploadID}}, nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+}
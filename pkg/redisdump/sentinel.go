@@ -0,0 +1,115 @@
+package redisdump
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// healthyReplica returns the address of the most up to date replica,
+// amongst those that are neither down nor disconnected from their primary
+func healthyReplica(replicas []map[string]string) (string, error) {
+	addr := ""
+	var bestOffset int64 = -1
+	for _, r := range replicas {
+		flags := strings.Split(r["flags"], ",")
+		healthy := r["master-link-status"] == "ok"
+		for _, flag := range flags {
+			if flag == "s_down" || flag == "o_down" || flag == "disconnected" {
+				healthy = false
+			}
+		}
+		if !healthy {
+			continue
+		}
+
+		offset, _ := strconv.ParseInt(r["slave-repl-offset"], 10, 64)
+		if offset > bestOffset {
+			bestOffset = offset
+			addr = net.JoinHostPort(r["ip"], r["port"])
+		}
+	}
+
+	if addr == "" {
+		return "", errors.New("no healthy replica found")
+	}
+	return addr, nil
+}
+
+// sentinelNode asks a sentinel for the address of the primary named master,
+// or of one of its replicas if replica is set. Sentinels older than Redis 5
+// only know SENTINEL REPLICAS as SENTINEL SLAVES.
+func sentinelNode(client radix.Client, cmd radixCmder, master string, replica bool) (string, error) {
+	if replica {
+		var replicas []map[string]string
+		err := client.Do(cmd(&replicas, "SENTINEL", "REPLICAS", master))
+		if errors.As(err, new(resp2.Error)) {
+			err = client.Do(cmd(&replicas, "SENTINEL", "SLAVES", master))
+		}
+		if err != nil {
+			return "", err
+		}
+		return healthyReplica(replicas)
+	}
+
+	var addr []string
+	if err := client.Do(cmd(&addr, "SENTINEL", "GET-MASTER-ADDR-BY-NAME", master)); err != nil {
+		return "", err
+	}
+	if len(addr) != 2 {
+		return "", fmt.Errorf("unknown master %s", master)
+	}
+
+	return net.JoinHostPort(addr[0], addr[1]), nil
+}
+
+// ResolveSentinel asks the sentinels in turn for the current primary named
+// master, or for one of its replicas if replica is set, and returns the
+// Host s pointing to it. Sentinels are given as host:port, the port
+// defaulting to 26379. They are authenticated with sentinelUsername and
+// sentinelPassword, rather than with the credentials of s, and use the
+// same TLS settings as s.
+func ResolveSentinel(sentinels []string, master string, replica bool, sentinelUsername string, sentinelPassword string, s Host) (Host, error) {
+	dialOpts, err := redisDialOpts(sentinelUsername, sentinelPassword, s.TlsHandler, nil)
+	if err != nil {
+		return s, err
+	}
+
+	var errs []string
+	for _, sentinel := range sentinels {
+		if _, _, err := net.SplitHostPort(sentinel); err != nil {
+			sentinel = net.JoinHostPort(sentinel, "26379")
+		}
+
+		conn, err := radix.Dial("tcp", sentinel, dialOpts...)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", sentinel, err))
+			continue
+		}
+
+		addr, err := sentinelNode(conn, radix.Cmd, master, replica)
+		conn.Close()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", sentinel, err))
+			continue
+		}
+
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return s, err
+		}
+		if s.Port, err = strconv.Atoi(port); err != nil {
+			return s, fmt.Errorf("invalid port for %s: %s", addr, port)
+		}
+		s.Host = host
+
+		return s, nil
+	}
+
+	return s, fmt.Errorf("failed resolving %s through sentinels: %s", master, strings.Join(errs, ", "))
+}
@@ -0,0 +1,74 @@
+package redisdump
+
+import (
+	"testing"
+)
+
+func TestHealthyReplica(t *testing.T) {
+	for i, testCase := range []struct {
+		replicas []map[string]string
+		expected string
+		err      bool
+	}{
+		{
+			[]map[string]string{
+				{"ip": "10.0.0.2", "port": "6379", "flags": "slave", "master-link-status": "ok", "slave-repl-offset": "100"},
+				{"ip": "10.0.0.3", "port": "6379", "flags": "slave", "master-link-status": "ok", "slave-repl-offset": "200"},
+			},
+			"10.0.0.3:6379",
+			false,
+		},
+		{
+			[]map[string]string{
+				{"ip": "10.0.0.2", "port": "6379", "flags": "slave", "master-link-status": "ok", "slave-repl-offset": "100"},
+				{"ip": "10.0.0.3", "port": "6379", "flags": "s_down,slave", "master-link-status": "ok", "slave-repl-offset": "200"},
+				{"ip": "10.0.0.4", "port": "6379", "flags": "slave", "master-link-status": "err", "slave-repl-offset": "300"},
+			},
+			"10.0.0.2:6379",
+			false,
+		},
+		{
+			[]map[string]string{
+				{"ip": "10.0.0.3", "port": "6379", "flags": "slave,disconnected", "master-link-status": "ok"},
+			},
+			"",
+			true,
+		},
+		{
+			nil,
+			"",
+			true,
+		},
+	} {
+		addr, err := healthyReplica(testCase.replicas)
+		if (err != nil) != testCase.err {
+			t.Errorf("test %d: unexpected error %v", i, err)
+		}
+		if addr != testCase.expected {
+			t.Errorf("test %d: expected %s, got %s", i, testCase.expected, addr)
+		}
+	}
+}
+
+func TestSentinelNode(t *testing.T) {
+	var m mockRadixClient
+
+	addr, err := sentinelNode(&m, getMockRadixAction, "mymaster", false)
+	if err != nil || addr != "10.0.0.1:6379" {
+		t.Errorf("expected master 10.0.0.1:6379, got %s, %v", addr, err)
+	}
+
+	addr, err = sentinelNode(&m, getMockRadixAction, "mymaster", true)
+	if err != nil || addr != "10.0.0.2:6380" {
+		t.Errorf("expected replica 10.0.0.2:6380, got %s, %v", addr, err)
+	}
+
+	addr, err = sentinelNode(&m, getMockRadixAction, "redis4", true)
+	if err != nil || addr != "10.0.0.2:6380" {
+		t.Errorf("expected replica 10.0.0.2:6380 through SENTINEL SLAVES, got %s, %v", addr, err)
+	}
+
+	if _, err = sentinelNode(&m, getMockRadixAction, "unknown", false); err == nil {
+		t.Errorf("expected an error for an unknown master")
+	}
+}
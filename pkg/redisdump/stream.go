@@ -0,0 +1,286 @@
+package redisdump
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	radix "github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// StreamEntry is an entry of a stream, as returned by XRANGE. Unlike
+// radix.StreamEntry, fields are kept as a list of field/value pairs, as
+// their order matters and a field name can appear several times.
+type StreamEntry struct {
+	ID     string
+	Fields []string
+}
+
+var errInvalidStreamEntry = errors.New("invalid stream entry")
+
+// UnmarshalRESP implements the resp.Unmarshaler interface.
+func (e *StreamEntry) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	if ah.N != 2 {
+		return errInvalidStreamEntry
+	}
+
+	var bs resp2.BulkString
+	if err := bs.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	e.ID = bs.S
+
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	e.Fields = nil
+	if ah.N%2 != 0 {
+		return errInvalidStreamEntry
+	}
+	for i := 0; i < ah.N; i++ {
+		if err := bs.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		e.Fields = append(e.Fields, bs.S)
+	}
+
+	return nil
+}
+
+// StreamPendingEntry is an entry delivered to a consumer of a group,
+// but not acknowledged yet
+type StreamPendingEntry struct {
+	ID         string
+	Consumer   string
+	Idle       string
+	Deliveries string
+}
+
+// StreamGroup is a consumer group of a stream, with its pending entries.
+// Consumers are the names of all its consumers, including those no entry
+// is pending for.
+type StreamGroup struct {
+	Name            string
+	LastDeliveredID string
+	EntriesRead     string
+	Consumers       []string
+	Pending         []StreamPendingEntry
+}
+
+// Stream is the value of a stream key: its entries, the last ID it
+// generated and its consumer groups
+type Stream struct {
+	Entries []StreamEntry
+	LastID  string
+	Groups  []StreamGroup
+}
+
+// nextStreamID returns the smallest stream ID greater than id
+func nextStreamID(id string) (string, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+	t, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid stream ID %s", id)
+	}
+
+	return radix.StreamEntryID{Time: t, Seq: seq}.Next().String(), nil
+}
+
+func getStreamPending(client radix.Client, cmd radixCmder, key, group string, batchSize int) ([]StreamPendingEntry, error) {
+	var pending []StreamPendingEntry
+	start := "-"
+	for {
+		var chunk [][]string
+		if err := client.Do(cmd(&chunk, "XPENDING", key, group, start, "+", fmt.Sprint(batchSize))); err != nil {
+			return nil, err
+		}
+
+		for _, p := range chunk {
+			if len(p) != 4 {
+				return nil, fmt.Errorf("invalid XPENDING reply for key %s", key)
+			}
+			pending = append(pending, StreamPendingEntry{ID: p[0], Consumer: p[1], Idle: p[2], Deliveries: p[3]})
+		}
+
+		if len(chunk) < batchSize {
+			return pending, nil
+		}
+
+		var err error
+		if start, err = nextStreamID(chunk[len(chunk)-1][0]); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rangeStream reads the entries of a stream count entries at a time, and
+// passes each chunk to fn
+func rangeStream(client radix.Client, cmd radixCmder, key string, count int, fn func([]StreamEntry)) error {
+	start := "-"
+	for {
+		var chunk []StreamEntry
+		if err := client.Do(cmd(&chunk, "XRANGE", key, start, "+", "COUNT", fmt.Sprint(count))); err != nil {
+			return err
+		}
+		if len(chunk) > 0 {
+			fn(chunk)
+		}
+		if len(chunk) < count {
+			return nil
+		}
+
+		var err error
+		if start, err = nextStreamID(chunk[len(chunk)-1].ID); err != nil {
+			return err
+		}
+	}
+}
+
+// getStreamInfo reads the last ID and the consumer groups of a stream into
+// s, fetching pending entries batchSize at a time
+func getStreamInfo(client radix.Client, cmd radixCmder, key string, batchSize int, s *Stream) error {
+	var info map[string]interface{}
+	if err := client.Do(cmd(&info, "XINFO", "STREAM", key)); err != nil {
+		return err
+	}
+	if lastID, ok := info["last-generated-id"].([]byte); ok {
+		s.LastID = string(lastID)
+	}
+
+	var groups []map[string]string
+	if err := client.Do(cmd(&groups, "XINFO", "GROUPS", key)); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		var consumers []map[string]string
+		if err := client.Do(cmd(&consumers, "XINFO", "CONSUMERS", key, g["name"])); err != nil {
+			return err
+		}
+		pending, err := getStreamPending(client, cmd, key, g["name"], batchSize)
+		if err != nil {
+			return err
+		}
+		group := StreamGroup{
+			Name:            g["name"],
+			LastDeliveredID: g["last-delivered-id"],
+			EntriesRead:     g["entries-read"],
+			Pending:         pending,
+		}
+		for _, c := range consumers {
+			group.Consumers = append(group.Consumers, c["name"])
+		}
+		s.Groups = append(s.Groups, group)
+	}
+	return nil
+}
+
+// getStream reads a stream with its consumer groups, fetching entries
+// and pending entries batchSize at a time
+func getStream(client radix.Client, cmd radixCmder, key string, batchSize int) (Stream, error) {
+	var s Stream
+	err := rangeStream(client, cmd, key, batchSize, func(entries []StreamEntry) {
+		s.Entries = append(s.Entries, entries...)
+	})
+	if err != nil {
+		return s, err
+	}
+	err = getStreamInfo(client, cmd, key, batchSize, &s)
+	return s, err
+}
+
+// readLargeStream reads a stream batchSize entries at a time, and passes
+// each chunk to write, with the commands recreating it. Its last ID and
+// consumer groups are passed last, once all entries were read.
+func readLargeStream(client radix.Client, cmd radixCmder, key string, batchSize int, write func(interface{}, [][]string)) error {
+	lastEntryID := ""
+	err := rangeStream(client, cmd, key, batchSize, func(entries []StreamEntry) {
+		lastEntryID = entries[len(entries)-1].ID
+		write(Stream{Entries: entries}, streamEntriesToRedisCmds(key, entries))
+	})
+	if err != nil {
+		return err
+	}
+
+	var val Stream
+	if err := getStreamInfo(client, cmd, key, batchSize, &val); err != nil {
+		return err
+	}
+	write(val, streamInfoToRedisCmds(key, val, lastEntryID))
+	return nil
+}
+
+// streamEntriesToRedisCmds recreates entries of a stream, with their IDs
+func streamEntriesToRedisCmds(streamKey string, entries []StreamEntry) [][]string {
+	cmds := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		cmd := []string{"XADD", streamKey, entry.ID}
+		cmds = append(cmds, append(cmd, entry.Fields...))
+	}
+	return cmds
+}
+
+// streamInfoToRedisCmds sets the last ID of a stream whose last entry is
+// lastEntryID - empty if it has none - and recreates its consumer groups,
+// their consumers and their pending entries. Consumers are created with
+// XGROUP CREATECONSUMER, so those no entry is pending for are kept: this
+// requires Redis 6.2 or later.
+func streamInfoToRedisCmds(streamKey string, val Stream, lastEntryID string) [][]string {
+	cmds := [][]string{}
+
+	switch {
+	case lastEntryID == "":
+		// XADD with MAXLEN 0 creates an empty stream, whose last ID is
+		// then set back to the one of the stream, even 0-0
+		lastID := val.LastID
+		if lastID == "" {
+			lastID = "0-0"
+		}
+		cmds = append(cmds, []string{"XADD", streamKey, "MAXLEN", "0", "*", "", ""})
+		cmds = append(cmds, []string{"XSETID", streamKey, lastID})
+
+	case val.LastID != "" && val.LastID != lastEntryID:
+		// Entries were deleted at the end of the stream
+		cmds = append(cmds, []string{"XSETID", streamKey, val.LastID})
+	}
+
+	for _, group := range val.Groups {
+		cmd := []string{"XGROUP", "CREATE", streamKey, group.Name, group.LastDeliveredID, "MKSTREAM"}
+		if group.EntriesRead != "" {
+			cmd = append(cmd, "ENTRIESREAD", group.EntriesRead)
+		}
+		cmds = append(cmds, cmd)
+
+		for _, c := range group.Consumers {
+			cmds = append(cmds, []string{"XGROUP", "CREATECONSUMER", streamKey, group.Name, c})
+		}
+		for _, p := range group.Pending {
+			cmds = append(cmds, []string{"XCLAIM", streamKey, group.Name, p.Consumer, "0", p.ID, "IDLE", p.Idle, "RETRYCOUNT", p.Deliveries, "FORCE", "JUSTID"})
+		}
+	}
+
+	return cmds
+}
+
+// streamToRedisCmds recreates the entries of a stream, with their IDs,
+// followed by its last ID and its consumer groups
+func streamToRedisCmds(streamKey string, val Stream) [][]string {
+	lastEntryID := ""
+	if n := len(val.Entries); n > 0 {
+		lastEntryID = val.Entries[n-1].ID
+	}
+	return append(streamEntriesToRedisCmds(streamKey, val.Entries), streamInfoToRedisCmds(streamKey, val, lastEntryID)...)
+}
@@ -0,0 +1,112 @@
+package redisdump
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// TargetSerializer sends the keys dumped to another server, rather than
+// writing them out: keys are migrated from server to server without an
+// intermediate file. Commands are sent by nWorkers connections, up to
+// pipelineSize at a time, as RestoreServer sends them; the commands of a
+// key are all sent by the same connection, in order. Key and Cmd block
+// while the target lags behind, and always return an empty string.
+type TargetSerializer struct {
+	mu      sync.Mutex
+	router  *restoreRouter
+	results chan restoreResult
+	// db is the database selected by the last SELECT passed to Cmd
+	db         uint8
+	incomplete bool
+}
+
+// NewTargetSerializer sends the keys to the server s, in the database they
+// were read from. Commands the server fails to apply are sent to failures.
+// abort is called once commands can no longer be sent, if it is set.
+func NewTargetSerializer(s Host, nWorkers int, pipelineSize int, failures chan<- RestoreError, abort func(error)) *TargetSerializer {
+	redisURL := RedisURL(s.Host, fmt.Sprint(s.Port))
+	getConn := func() (radix.Conn, error) {
+		return getConnFunc(s, nil)("tcp", redisURL)
+	}
+
+	t := &TargetSerializer{
+		router:  &restoreRouter{workers: make([]chan restoreCmd, nWorkers)},
+		results: make(chan restoreResult),
+	}
+	// Only the first failure interrupts the dump
+	var once sync.Once
+	workerAbort := func(err error) {
+		once.Do(func() {
+			if abort != nil {
+				abort(err)
+			}
+		})
+	}
+	for i := range t.router.workers {
+		t.router.workers[i] = make(chan restoreCmd, pipelineSize)
+		go restoreWorker(getConn, t.router.workers[i], pipelineSize, MergeKeys, failures, workerAbort, t.results)
+	}
+	return t
+}
+
+// Key implements the Serializer interface.
+func (t *TargetSerializer) Key(k *Key) string {
+	for _, cmd := range k.Cmds {
+		t.router.send(restoreCmd{db: k.Db, cmd: cmd})
+	}
+	return ""
+}
+
+// Cmd implements the Serializer interface. SELECT selects the database
+// the following commands are sent to, and IncompleteDumpMarker is not
+// sent, but returned as an error by Close. MULTI/EXEC transactions are
+// sent by a single connection.
+func (t *TargetSerializer) Cmd(cmd []string) string {
+	if len(cmd) == 0 {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch {
+	case isIncompleteDumpMarker(cmd):
+		t.incomplete = true
+	case strings.ToUpper(cmd[0]) == "SELECT" && len(cmd) == 2:
+		if db, err := strconv.ParseUint(cmd[1], 10, 8); err == nil {
+			t.db = uint8(db)
+		}
+	default:
+		t.router.route(restoreCmd{db: t.db, cmd: cmd})
+	}
+	return ""
+}
+
+// Close waits for all commands to be sent, and returns the number of
+// commands the target applied or failed to apply. The error returned is the
+// one that prevented commands from being sent, or ErrIncompleteDump if the
+// dump was interrupted.
+func (t *TargetSerializer) Close() (RestoreStats, error) {
+	t.mu.Lock()
+	t.router.close()
+	t.mu.Unlock()
+
+	var stats RestoreStats
+	var err error
+	for range t.router.workers {
+		res := <-t.results
+		stats.Restored += res.stats.Restored
+		stats.Failed += res.stats.Failed
+		if res.err != nil && err == nil {
+			err = fmt.Errorf("failed writing to target: %w", res.err)
+		}
+	}
+
+	if err == nil && t.incomplete {
+		err = ErrIncompleteDump
+	}
+	return stats, err
+}
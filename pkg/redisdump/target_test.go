@@ -0,0 +1,43 @@
+package redisdump
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTargetSerializer(t *testing.T) {
+	target := &TargetSerializer{
+		router: &restoreRouter{workers: []chan restoreCmd{make(chan restoreCmd, 10), make(chan restoreCmd, 10)}},
+	}
+
+	target.Cmd([]string{"SELECT", "1"})
+	target.Key(&Key{Db: 1, Name: "list", Cmds: [][]string{{"RPUSH", "list", "a"}, {"PEXPIREAT", "list", "1700000000000"}}})
+	target.Cmd([]string{"DEL", "list"})
+	target.Cmd([]string{"SELECT", "2"})
+	target.Key(&Key{Db: 2, Name: "list", Cmds: [][]string{{"RPUSH", "list", "b"}}})
+	target.Cmd(IncompleteDumpMarker)
+
+	sent := map[int][]restoreCmd{}
+	for i, w := range target.router.workers {
+		close(w)
+		for c := range w {
+			if c.db == 1 {
+				sent[i] = append(sent[i], c)
+			}
+		}
+	}
+
+	// Commands of a key are sent in order, by the same worker
+	expected := []restoreCmd{
+		{db: 1, cmd: []string{"RPUSH", "list", "a"}},
+		{db: 1, cmd: []string{"PEXPIREAT", "list", "1700000000000"}},
+		{db: 1, cmd: []string{"DEL", "list"}},
+	}
+	i := restoreWorkerIndex(expected[0], 2)
+	if !reflect.DeepEqual(sent[i], expected) || len(sent) != 1 {
+		t.Errorf("expected %v to be sent by worker %d, got %v", expected, i, sent)
+	}
+	if !target.incomplete {
+		t.Errorf("expected the dump to be marked incomplete")
+	}
+}
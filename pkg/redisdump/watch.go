@@ -0,0 +1,263 @@
+package redisdump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+// keyEventClasses are the classes of keyspace notifications that report
+// changes to keys - generic commands, then commands on strings, lists,
+// sets, hashes, sorted sets and streams, and expirations and evictions
+const keyEventClasses = "g$lshztxe"
+
+// watchPingInterval is how often the connection receiving keyspace
+// notifications is checked: changes are missed once it is lost
+var watchPingInterval = 5 * time.Second
+
+// missingKeyEventClasses returns the classes of keyEventClasses the
+// notify-keyspace-events setting flags does not publish key events for
+func missingKeyEventClasses(flags string) string {
+	if !strings.Contains(flags, "E") {
+		return keyEventClasses
+	}
+	if strings.Contains(flags, "A") {
+		return ""
+	}
+	missing := ""
+	for _, class := range keyEventClasses {
+		if !strings.ContainsRune(flags, class) {
+			missing += string(class)
+		}
+	}
+	return missing
+}
+
+// parseKeyEvent returns the database and the key of a key event, published
+// to the channel __keyevent@<db>__:<event>
+func parseKeyEvent(m radix.PubSubMessage) (uint8, string, bool) {
+	channel, ok := strings.CutPrefix(m.Channel, "__keyevent@")
+	if !ok {
+		return 0, "", false
+	}
+	i := strings.Index(channel, "__:")
+	if i < 0 {
+		return 0, "", false
+	}
+	db, err := strconv.ParseUint(channel[:i], 10, 8)
+	if err != nil {
+		return 0, "", false
+	}
+	return uint8(db), string(m.Message), true
+}
+
+// changedKeys gathers the keys reported as changed, until they are dumped
+// again. A key changed several times is only dumped once.
+type changedKeys struct {
+	mu   sync.Mutex
+	keys map[uint8][]string
+	seen map[uint8]map[string]bool
+	// notify receives a value once keys are added
+	notify chan bool
+}
+
+func newChangedKeys() *changedKeys {
+	return &changedKeys{
+		keys:   map[uint8][]string{},
+		seen:   map[uint8]map[string]bool{},
+		notify: make(chan bool, 1),
+	}
+}
+
+func (c *changedKeys) add(db uint8, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seen[db] == nil {
+		c.seen[db] = map[string]bool{}
+	}
+	if c.seen[db][key] {
+		return
+	}
+	c.seen[db][key] = true
+	c.keys[db] = append(c.keys[db], key)
+
+	select {
+	case c.notify <- true:
+	default:
+	}
+}
+
+// take returns the keys changed since the last call, by database
+func (c *changedKeys) take() map[uint8][]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	keys := c.keys
+	c.keys, c.seen = map[uint8][]string{}, map[uint8]map[string]bool{}
+	return keys
+}
+
+// watchKeys writes the keys that changed, as they are added to changed,
+// until ctx is done or ping fails. Each key is deleted, then dumped again
+// with its current value - keys deleted since are only deleted. Keys are
+// read with the client returned for their database.
+func watchKeys(ctx context.Context, changed *changedKeys, ping func() error, client func(db uint8) (radix.Client, error), cmd radixCmder, opts DumpOptions, collector *errorCollector, logger *log.Logger, serializer Serializer) error {
+	ticker := time.NewTicker(watchPingInterval)
+	defer ticker.Stop()
+
+	selected := -1
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return fmt.Errorf("lost keyspace notifications: %w", err)
+			}
+			continue
+		case <-changed.notify:
+		}
+
+		keys := changed.take()
+		dbs := make([]int, 0, len(keys))
+		for db := range keys {
+			dbs = append(dbs, int(db))
+		}
+		sort.Ints(dbs)
+
+		for _, db := range dbs {
+			c, err := client(uint8(db))
+			if err != nil {
+				return err
+			}
+			if db != selected {
+				writeCmd(logger, serializer, []string{"SELECT", fmt.Sprint(db)})
+				selected = db
+			}
+
+			// Batches are dumped whole even once ctx is done, as their keys
+			// were already deleted
+			dbKeys := keys[uint8(db)]
+			for i := 0; i < len(dbKeys); i += 100 {
+				batch := dbKeys[i:min(i+100, len(dbKeys))]
+				for _, key := range batch {
+					writeCmd(logger, serializer, []string{"DEL", key})
+				}
+				err := dumpKeys(context.WithoutCancel(ctx), c, cmd, uint8(db), batch, opts, logger, serializer)
+				if err != nil {
+					for _, keyErr := range err.(*DumpErrors).Keys {
+						keyErr.Db = uint8(db)
+						fmt.Fprintln(os.Stderr, "Error: "+keyErr.Error())
+						collector.add(keyErr)
+					}
+				}
+				if ctx.Err() != nil {
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// WatchServer dumps the server s as DumpServerContext does, then keeps
+// writing the keys that change on the server, as keyspace notifications
+// report them, until ctx is done: replaying the output keeps another
+// server in sync with s, to migrate it with little downtime. Changed keys
+// are deleted and dumped again, keys deleted, expired or evicted are only
+// deleted.
+// Notifications are received from before the dump starts, so keys changed
+// while the databases are scanned are dumped again once the scan
+// completed. The server must publish key events for all types of keys,
+// with notify-keyspace-events set to "EA" for instance; FLUSHDB, FLUSHALL
+// and SWAPDB are not reported.
+// If ctx is done once the dump completed, the error of the dump is
+// returned - keys that failed to be dumped, if any. Keys changed once the
+// notifications can no longer be received are not dumped: the dump ends
+// with IncompleteDumpMarker.
+func WatchServer(ctx context.Context, s Host, db *uint8, opts DumpOptions, logger *log.Logger, serializer Serializer, progress chan<- ProgressNotification) error {
+	redisURL := RedisURL(s.Host, fmt.Sprint(s.Port))
+	conn, err := getConnFunc(s, nil)("tcp", redisURL)
+	if err != nil {
+		return err
+	}
+
+	var config map[string]string
+	if err := conn.Do(radix.Cmd(&config, "CONFIG", "GET", "notify-keyspace-events")); err != nil {
+		// CONFIG is disabled on some managed services
+		fmt.Fprintf(os.Stderr, "Warning: failed checking notify-keyspace-events, changes may be missed: %s\n", err)
+	} else if missing := missingKeyEventClasses(config["notify-keyspace-events"]); missing != "" {
+		conn.Close()
+		return fmt.Errorf("keyspace notifications are not published for all changes - notify-keyspace-events is %q, and misses E or %s", config["notify-keyspace-events"], missing)
+	}
+
+	msgs := make(chan radix.PubSubMessage, 1000)
+	defer close(msgs)
+	ps := radix.PubSub(conn)
+	defer ps.Close()
+
+	changed := newChangedKeys()
+	go func() {
+		for m := range msgs {
+			if db, key, ok := parseKeyEvent(m); ok && matchPattern(opts.Filter, key) {
+				changed.add(db, key)
+			}
+		}
+	}()
+
+	pattern := "__keyevent@*__:*"
+	if db != AllDBs {
+		pattern = fmt.Sprintf("__keyevent@%d__:*", *db)
+	}
+	if err := ps.PSubscribe(msgs, pattern); err != nil {
+		return fmt.Errorf("failed subscribing to keyspace notifications: %w", err)
+	}
+
+	dumpErr := DumpServerContext(ctx, s, db, opts, nil, logger, serializer, progress)
+	var dumpErrs *DumpErrors
+	if dumpErr != nil && (!errors.As(dumpErr, &dumpErrs) || dumpErrs.Aborted) {
+		return dumpErr
+	}
+
+	pools := map[uint8]*radix.Pool{}
+	defer func() {
+		for _, pool := range pools {
+			pool.Close()
+		}
+	}()
+	client := func(db uint8) (radix.Client, error) {
+		if pools[db] == nil {
+			pool, err := radix.NewPool("tcp", redisURL, 1, radix.PoolConnFunc(getConnFunc(s, &db)))
+			if err != nil {
+				return nil, err
+			}
+			pools[db] = pool
+		}
+		return pools[db], nil
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	collector := newErrorCollector(opts.MaxErrors, cancel)
+	if dumpErrs != nil {
+		collector.addErrors(dumpErrs)
+	}
+
+	err = watchKeys(watchCtx, changed, ps.Ping, client, radix.Cmd, opts, collector, logger, serializer)
+	if err != nil || (watchCtx.Err() != nil && ctx.Err() == nil) {
+		writeCmd(logger, serializer, IncompleteDumpMarker)
+	}
+	if err != nil {
+		return err
+	}
+	return collector.err()
+}
@@ -0,0 +1,106 @@
+package redisdump
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+
+	radix "github.com/mediocregopher/radix/v3"
+)
+
+func TestMissingKeyEventClasses(t *testing.T) {
+	for i, testCase := range []struct {
+		flags    string
+		expected string
+	}{
+		{"", "g$lshztxe"},
+		{"AK", "g$lshztxe"},
+		{"AE", ""},
+		{"KEA", ""},
+		{"Eg$lshztxe", ""},
+		{"Eg$x", "lshzte"},
+	} {
+		if got := missingKeyEventClasses(testCase.flags); got != testCase.expected {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.expected, got)
+		}
+	}
+}
+
+func TestParseKeyEvent(t *testing.T) {
+	for i, testCase := range []struct {
+		channel string
+		db      uint8
+		ok      bool
+	}{
+		{"__keyevent@0__:set", 0, true},
+		{"__keyevent@12__:expired", 12, true},
+		{"__keyspace@0__:key", 0, false},
+		{"__keyevent@256__:set", 0, false},
+		{"__keyevent@0", 0, false},
+	} {
+		db, key, ok := parseKeyEvent(radix.PubSubMessage{Channel: testCase.channel, Message: []byte("key")})
+		if ok != testCase.ok || (ok && (db != testCase.db || key != "key")) {
+			t.Errorf("test %d: unexpected event of db %d, key %s, ok %t", i, db, key, ok)
+		}
+	}
+}
+
+func TestChangedKeys(t *testing.T) {
+	c := newChangedKeys()
+	c.add(0, "a")
+	c.add(0, "b")
+	c.add(0, "a")
+	c.add(2, "a")
+
+	select {
+	case <-c.notify:
+	default:
+		t.Errorf("expected changed keys to be notified")
+	}
+	expected := map[uint8][]string{0: {"a", "b"}, 2: {"a"}}
+	if keys := c.take(); !reflect.DeepEqual(keys, expected) {
+		t.Errorf("expected %v, got %v", expected, keys)
+	}
+	if keys := c.take(); len(keys) != 0 {
+		t.Errorf("expected keys to be taken once, got %v", keys)
+	}
+}
+
+func TestWatchKeys(t *testing.T) {
+	defer func(interval time.Duration) { watchPingInterval = interval }(watchPingInterval)
+	watchPingInterval = 50 * time.Millisecond
+
+	changed := newChangedKeys()
+	changed.add(2, "somestring")
+	changed.add(2, "deletedkey")
+	changed.add(0, "somemodule")
+
+	var b bytes.Buffer
+	collector := newErrorCollector(-1, func() {})
+	errLost := errors.New("connection closed")
+	client := func(db uint8) (radix.Client, error) { return &mockRadixClient{}, nil }
+	err := watchKeys(context.Background(), changed, func() error { return errLost }, client, getMockRadixAction, DumpOptions{TTLMode: NoTTL, BatchSize: 10, ChunkThreshold: -1}, collector, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer))
+	if !errors.Is(err, errLost) {
+		t.Errorf("expected the watch to end once the notifications were lost, got %v", err)
+	}
+
+	expected := "SELECT 0\nDEL somemodule\nSELECT 2\nDEL somestring\nDEL deletedkey\nSET somestring stringvalue\n"
+	if b.String() != expected {
+		t.Errorf("expected %q, got %q", expected, b.String())
+	}
+	var dumpErrs *DumpErrors
+	if err := collector.err(); !errors.As(err, &dumpErrs) || len(dumpErrs.Keys) != 1 || dumpErrs.Keys[0].Db != 0 || dumpErrs.Keys[0].Key != "somemodule" {
+		t.Errorf("expected somemodule to fail, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Reset()
+	if err := watchKeys(ctx, newChangedKeys(), func() error { return nil }, client, getMockRadixAction, DumpOptions{TTLMode: NoTTL, BatchSize: 10, ChunkThreshold: -1}, collector, log.New(&b, "", 0), CmdSerializer(RedisCmdSerializer)); err != nil || b.Len() != 0 {
+		t.Errorf("expected the watch to end once ctx is done, got %v: %q", err, b.String())
+	}
+}
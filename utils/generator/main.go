@@ -21,13 +21,13 @@ func randSeq(n int) string {
 	return string(b)
 }
 
-func GenerateStrings(w io.Writer, nKeys int, serializer redisdump.Serializer) {
+func GenerateStrings(w io.Writer, nKeys int, serializer redisdump.CmdSerializer) {
 	for i := 0; i < nKeys; i++ {
 		io.WriteString(w, serializer([]string{"SET", randSeq(8), randSeq(16)})+"\n")
 	}
 }
 
-func GenerateZSET(w io.Writer, nKeys int, serializer redisdump.Serializer) {
+func GenerateZSET(w io.Writer, nKeys int, serializer redisdump.CmdSerializer) {
 	zsetKey := randSeq(16)
 	for i := 0; i < nKeys; i++ {
 		io.WriteString(w, serializer([]string{"ZADD", zsetKey, "1", randSeq(16)})+"\n")
@@ -40,7 +40,7 @@ func main() {
 	oType := flag.String("output", "resp", "resp or commands")
 	flag.Parse()
 
-	var s redisdump.Serializer
+	var s redisdump.CmdSerializer
 	switch strings.ToLower(*oType) {
 	case "resp":
 		s = redisdump.RESPSerializer